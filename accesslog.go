@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultaccesslogmaxsize is when the access log rotates if AccessLogMaxSize isn't set.
+const defaultAccessLogMaxSize = 10 << 20
+
+// accesslogbackups is how many rotated files are kept, as .1 for the newest up to .5.
+const accessLogBackups = 5
+
+// accesslog appends a json line per served file to AccessLogFile, renaming it
+// to .1, .2 and so on once it grows past its maximum size. it's separate from
+// the log package, so it doesn't depend on what goes to the console.
+type accessLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+
+	// closed is set once the log is closed for good, and drops anything
+	// recorded after
+	closed bool
+}
+
+// accessentry is one line of the access log.
+type accessEntry struct {
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Bytes      uint64    `json:"bytes"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// openaccesslog opens the access log for appending, creating it if needed. a
+// maxsize of 0 never rotates it.
+func openAccessLog(path string, maxSize int64) (*accessLog, error) {
+	l := &accessLog{path: path, maxSize: maxSize}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the current file and picks up its size, so a restart keeps
+// appending to it.
+func (l *accessLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, info.Size()
+	return nil
+}
+
+// rotate shifts the old files up by one, dropping the oldest, and starts a new one.
+func (l *accessLog) rotate() error {
+	l.file.Close()
+	for n := accessLogBackups - 1; n > 0; n-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, n), fmt.Sprintf("%s.%d", l.path, n+1))
+	}
+
+	// carry on in the same file if it couldn't be moved aside, trying again
+	// only once it has grown by another maxsize
+	renameErr := os.Rename(l.path, l.path+".1")
+	if err := l.open(); err != nil {
+		l.file = nil
+		return err
+	}
+	if renameErr != nil {
+		l.size = 0
+	}
+	return renameErr
+}
+
+// resize changes how large the file may grow before it's rotated.
+func (l *accessLog) resize(maxSize int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxSize = maxSize
+}
+
+// close closes the file for good.
+func (l *accessLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// record writes one entry. a failed write is logged to the console, since
+// the access log is the thing that broke.
+func (l *accessLog) record(entry accessEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+
+	// a file that couldn't be reopened after rotating is tried again
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			log.Println("Error opening access log:", err)
+			return
+		}
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			log.Println("Error rotating access log:", err)
+			if l.file == nil {
+				return
+			}
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Println("Error writing access log:", err)
+	}
+}
+
+// track returns a writer that counts the response, and a function to call once
+// it's sent that records it. without AccessLogFile, w comes back as it is.
+func (s *server) track(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	if s.accessLog == nil {
+		return w, func() {}
+	}
+	start := time.Now()
+	rw := &recordingWriter{ResponseWriter: w}
+	return rw, func() {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		s.accessLog.record(accessEntry{
+			Time:       start.UTC(),
+			Client:     client,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     status,
+			Bytes:      rw.written,
+			DurationMS: time.Since(start).Milliseconds(),
+		})
+	}
+}
+
+// recordingwriter wraps a responsewriter and keeps the status and the bytes
+// written through it for the access log.
+type recordingWriter struct {
+	http.ResponseWriter
+	status  int
+	written uint64
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(p)
+	rw.written += uint64(n)
+	return n, err
+}
+
+// unwrap exposes the underlying responsewriter to http.ResponseController.
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
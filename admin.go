@@ -0,0 +1,18 @@
+package main
+
+import "net/http"
+
+// handleadminconfig returns the categories as the server parsed them, for
+// checking a config remotely. category passwords are never included.
+func (s *server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		challenge(w)
+		return
+	}
+
+	categories := s.cfg.Categories
+	if categories == nil {
+		categories = []CategoryConfig{}
+	}
+	writeJSON(w, http.StatusOK, categories)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the headings for tracks whose tags leave out who or what they're from
+const (
+	unknownArtist = "Unknown artist"
+	unknownAlbum  = "Unknown album"
+)
+
+// taggedfile is an audio file with tags, with the category it's played from.
+type taggedFile struct {
+	Category string
+	File     MediaFile
+}
+
+// albumartist returns who the file's album is filed under: its album artist
+// when tagged, otherwise its artist.
+func albumArtist(file MediaFile) string {
+	if file.AlbumArtist != "" {
+		return file.AlbumArtist
+	}
+	return file.Artist
+}
+
+// tagentry is one line on an artists or albums page: a link and how many
+// albums or tracks are behind it.
+type tagEntry struct {
+	Name   string
+	Detail string
+	Link   string
+}
+
+// tagsdata is what the artists and albums pages render: a list of links, a
+// list of tracks, or an artist's albums followed by their loose tracks.
+type tagsData struct {
+	SiteTitle string
+	BasePath  string
+	Offline   bool
+	Theme     string
+	Heading   string
+	Crumbs    []tagEntry
+	Entries   []tagEntry
+	Tracks    []taggedFile
+}
+
+// taggedfiles walks the categories the request may see for audio files that
+// have an artist or an album. collections are left out, since their files are
+// already found in the categories they come from.
+func (s *server) taggedFiles(r *http.Request) ([]taggedFile, error) {
+	var configs []CategoryConfig
+	for _, config := range s.categories(r) {
+		if !isCollection(config) {
+			configs = append(configs, config)
+		}
+	}
+	groups, err := buildMediaList(r.Context(), configs, s.cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+	var files []taggedFile
+	for _, group := range groups {
+		for _, file := range group.Files {
+			if file.Kind() == kindAudio && (file.Artist != "" || file.Album != "") {
+				files = append(files, taggedFile{Category: group.Name, File: file})
+			}
+		}
+	}
+	return files, nil
+}
+
+// orname returns name, or fallback when it's empty.
+func orName(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+	return name
+}
+
+// lessname orders names ignoring case, so ABBA and Abba sit together.
+func lessName(a, b string) bool {
+	la, lb := strings.ToLower(a), strings.ToLower(b)
+	if la != lb {
+		return la < lb
+	}
+	return a < b
+}
+
+// sorttracks orders an album's tracks by track number, then untracked ones by
+// name.
+func sortTracks(tracks []taggedFile) {
+	sort.SliceStable(tracks, func(i, j int) bool {
+		a, b := tracks[i].File, tracks[j].File
+		switch {
+		case a.Track != b.Track && a.Track != 0 && b.Track != 0:
+			return a.Track < b.Track
+		case (a.Track == 0) != (b.Track == 0):
+			return a.Track != 0
+		}
+		return lessName(a.DisplayName(), b.DisplayName())
+	})
+}
+
+// plural formats n with the singular or plural noun.
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return strconv.Itoa(n) + " " + many
+}
+
+// albumlink returns the page of an album.
+func albumLink(basePath, artist, album string) string {
+	return basePath + "/albums?" + url.Values{"artist": {artist}, "album": {album}}.Encode()
+}
+
+// handleartists lists every artist at /artists, and an artist's albums and
+// tracks at /artists?name=.
+func (s *server) handleArtists(w http.ResponseWriter, r *http.Request) {
+	files, err := s.taggedFiles(r)
+	if err != nil {
+		message, status := libraryError(err)
+		http.Error(w, message, status)
+		return
+	}
+	data := s.tagsPage(w, r, "Artists")
+
+	query := r.URL.Query()
+	if !query.Has("name") {
+		albums := make(map[string]map[string]bool)
+		tracks := make(map[string]int)
+		for _, f := range files {
+			artist := albumArtist(f.File)
+			if albums[artist] == nil {
+				albums[artist] = make(map[string]bool)
+			}
+			if f.File.Album != "" {
+				albums[artist][f.File.Album] = true
+			}
+			tracks[artist]++
+		}
+		for artist := range albums {
+			detail := plural(tracks[artist], "track", "tracks")
+			if n := len(albums[artist]); n > 0 {
+				detail = plural(n, "album", "albums") + ", " + detail
+			}
+			data.Entries = append(data.Entries, tagEntry{
+				Name:   orName(artist, unknownArtist),
+				Detail: detail,
+				Link:   data.BasePath + "/artists?" + url.Values{"name": {artist}}.Encode(),
+			})
+		}
+		sort.Slice(data.Entries, func(i, j int) bool { return lessName(data.Entries[i].Name, data.Entries[j].Name) })
+		s.renderTags(w, data)
+		return
+	}
+
+	// an artist's albums come first, then any of their tracks without one
+	artist := query.Get("name")
+	albums := make(map[string]int)
+	for _, f := range files {
+		if albumArtist(f.File) != artist {
+			continue
+		}
+		if f.File.Album == "" {
+			data.Tracks = append(data.Tracks, f)
+		} else {
+			albums[f.File.Album]++
+		}
+	}
+	if len(albums) == 0 && len(data.Tracks) == 0 {
+		s.notFound(w, r)
+		return
+	}
+	for album, n := range albums {
+		data.Entries = append(data.Entries, tagEntry{Name: album, Detail: plural(n, "track", "tracks"), Link: albumLink(data.BasePath, artist, album)})
+	}
+	sort.Slice(data.Entries, func(i, j int) bool { return lessName(data.Entries[i].Name, data.Entries[j].Name) })
+	sortTracks(data.Tracks)
+	data.Heading = orName(artist, unknownArtist)
+	data.Crumbs = []tagEntry{{Name: "Artists", Link: data.BasePath + "/artists"}}
+	s.renderTags(w, data)
+}
+
+// handlealbums lists every album at /albums, and an album's tracks in track
+// order at /albums?artist=&album=. albums are told apart by who they're filed
+// under, so two artists' Greatest Hits stay apart.
+func (s *server) handleAlbums(w http.ResponseWriter, r *http.Request) {
+	files, err := s.taggedFiles(r)
+	if err != nil {
+		message, status := libraryError(err)
+		http.Error(w, message, status)
+		return
+	}
+	data := s.tagsPage(w, r, "Albums")
+
+	query := r.URL.Query()
+	if !query.Has("album") {
+		type albumKey struct{ artist, album string }
+		tracks := make(map[albumKey]int)
+		for _, f := range files {
+			if f.File.Album != "" {
+				tracks[albumKey{albumArtist(f.File), f.File.Album}]++
+			}
+		}
+		for key, n := range tracks {
+			data.Entries = append(data.Entries, tagEntry{
+				Name:   key.album,
+				Detail: orName(key.artist, unknownArtist) + ", " + plural(n, "track", "tracks"),
+				Link:   albumLink(data.BasePath, key.artist, key.album),
+			})
+		}
+		sort.SliceStable(data.Entries, func(i, j int) bool {
+			a, b := data.Entries[i], data.Entries[j]
+			if a.Name != b.Name {
+				return lessName(a.Name, b.Name)
+			}
+			return lessName(a.Detail, b.Detail)
+		})
+		s.renderTags(w, data)
+		return
+	}
+
+	artist, album := query.Get("artist"), query.Get("album")
+	for _, f := range files {
+		if f.File.Album == album && albumArtist(f.File) == artist {
+			data.Tracks = append(data.Tracks, f)
+		}
+	}
+	if album == "" || len(data.Tracks) == 0 {
+		s.notFound(w, r)
+		return
+	}
+	sortTracks(data.Tracks)
+	data.Heading = orName(album, unknownAlbum)
+	data.Crumbs = []tagEntry{
+		{Name: "Albums", Link: data.BasePath + "/albums"},
+		{Name: orName(artist, unknownArtist), Link: data.BasePath + "/artists?" + url.Values{"name": {artist}}.Encode()},
+	}
+	s.renderTags(w, data)
+}
+
+// tagspage starts the data for an artists or albums page.
+func (s *server) tagsPage(w http.ResponseWriter, r *http.Request, heading string) tagsData {
+	return tagsData{
+		SiteTitle: s.cfg.Server.SiteTitle,
+		BasePath:  s.cfg.Server.BasePath,
+		Offline:   s.cfg.Server.Offline,
+		Theme:     s.theme(w, r),
+		Heading:   heading,
+	}
+}
+
+// rendertags writes an artists or albums page.
+func (s *server) renderTags(w http.ResponseWriter, data tagsData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.tagsTmpl.Execute(w, data); err != nil {
+		log.Println("Error executing template:", err)
+	}
+}
+
+// html template for the artists and albums pages
+const tagsTemplate = `
+<!DOCTYPE html>
+<html lang="en"{{if ne .Theme "auto"}} data-bs-theme="{{.Theme}}"{{end}}>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    {{if .Offline}}<link href="{{.BasePath}}/assets/bootstrap.min.css" rel="stylesheet">{{else}}<link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0-alpha3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-KK94CHFLLe+nY2dmCWGMq91rCGa5gtU4mk92HdvYe+M/SXH301p5ILy+dN9+nJOZ" crossorigin="anonymous">{{end}}
+		<title>{{.Heading}} - {{.SiteTitle}}</title>
+    {{template "icons"}}
+    {{template "theme" .}}
+</head>
+<body>
+<div class="container-fluid">
+    <div class="row">
+        <div class="col">
+            <h1><a href="{{.BasePath}}/">{{.SiteTitle}}</a></h1>
+            {{template "theme-switch"}}
+            <p><a href="{{.BasePath}}/artists">artists</a> · <a href="{{.BasePath}}/albums">albums</a></p>
+            <h2 class="h4">{{range .Crumbs}}<a href="{{.Link}}">{{.Name}}</a> / {{end}}{{.Heading}}</h2>
+        </div>
+    </div>
+    <div class="row">
+        <div class="col">
+            {{if .Entries}}
+            <ul>
+                {{range .Entries}}
+                <li><a href="{{.Link}}"><strong>{{.Name}}</strong></a> <small class="text-muted">{{.Detail}}</small></li>
+                {{end}}
+            </ul>
+            {{end}}
+            {{if .Tracks}}
+            <ol>
+                {{range .Tracks}}
+                <li{{if .File.Track}} value="{{.File.Track}}"{{end}}>
+                    <i class="{{icon .File.Path}}" aria-hidden="true"></i><a href="{{$.BasePath}}/{{.File.Path}}" name="{{.File.Path}}" title="{{.File.Path}}" target="_blank">{{.File.DisplayName}}</a>
+                    <small><a href="{{$.BasePath}}/play/{{.Category}}/{{.File.Path}}">play</a></small>
+                    {{if .File.Duration}}<small class="text-muted">{{duration .File.Duration}}</small>{{end}}
+                </li>
+                {{end}}
+            </ol>
+            {{end}}
+            {{if not (or .Entries .Tracks)}}<p class="text-muted">no tagged audio files yet</p>{{end}}
+        </div>
+    </div>
+</div>
+</body>
+</html>
+`
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pagination limits for /api/media
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// apiversion is the shape of the /api/media response, raised whenever a change
+// would break an existing client. fields may be added without raising it.
+const apiVersion = 1
+
+// mediaresponse is the body of /api/media.
+type mediaResponse struct {
+	APIVersion int          `json:"api_version"`
+	Summary    mediaSummary `json:"summary"`
+	Groups     []MediaGroup `json:"groups"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// mediasummary totals the library, so a dashboard can show them without
+// adding up every file.
+type mediaSummary struct {
+	Categories int   `json:"categories"`
+	TotalFiles int   `json:"total_files"`
+	TotalBytes int64 `json:"total_bytes"`
+}
+
+// summarize totals the categories in groups. synthetic groups like recently
+// added only repeat files from the categories, so they aren't counted.
+func summarize(groups []MediaGroup) mediaSummary {
+	var summary mediaSummary
+	for _, group := range groups {
+		if group.Directory == "" {
+			continue
+		}
+		summary.Categories++
+		summary.TotalFiles += group.FileCount
+		summary.TotalBytes += group.TotalSize
+	}
+	return summary
+}
+
+// checkapiversion refuses a request for a shape this server doesn't produce.
+// /api/v1/media pins the version in the path and ?v= asks for one on any
+// path, so a client built against an older shape fails loudly instead of
+// misreading the response.
+func checkAPIVersion(w http.ResponseWriter, r *http.Request) bool {
+	want := r.URL.Query().Get("v")
+	if want == "" {
+		return true
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(want, "v")); err != nil || n != apiVersion {
+		writeJSONError(w, fmt.Sprintf("api version %s is not supported, this server speaks version %d", want, apiVersion), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// handleapimedia returns the same groups the listing renders as json. when a
+// cursor or limit is given the files are returned a page at a time instead, and
+// ?category= narrows either to a single category.
+func (s *server) handleAPIMedia(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIVersion(w, r) {
+		return
+	}
+	query := r.URL.Query()
+
+	// a single category skips the other walks, which is what lazy loading relies on
+	configs := s.categories(r)
+	name := query.Get("category")
+	if name != "" {
+		config, ok := s.category(name)
+		if !ok {
+			writeJSONError(w, "no such category", http.StatusNotFound)
+			return
+		}
+		if !s.authorize(w, r, config) {
+			return
+		}
+		configs = []CategoryConfig{config}
+	}
+
+	if !query.Has("cursor") && !query.Has("limit") {
+
+		// the whole listing includes the synthetic groups, a single category doesn't
+		var groups []MediaGroup
+		var err error
+		if name != "" {
+			groups, err = buildMediaList(r.Context(), configs, s.cfg.Server)
+		} else {
+			groups, err = s.mediaList(r)
+		}
+		if err != nil {
+			message, status := libraryError(err)
+			writeJSONError(w, message, status)
+			return
+		}
+		writeJSON(w, http.StatusOK, mediaResponse{APIVersion: apiVersion, Summary: summarize(groups), Groups: groups})
+		return
+	}
+
+	limit := defaultPageLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeJSONError(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+	}
+
+	var after *pageCursor
+	if v := query.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		after = &c
+	}
+
+	// pages cover the real categories only, the recently added view would repeat files
+	groups, err := buildMediaList(r.Context(), configs, s.cfg.Server)
+	if err != nil {
+		message, status := libraryError(err)
+		writeJSONError(w, message, status)
+		return
+	}
+
+	// the summary covers the whole library, not just this page
+	resp := paginate(groups, after, limit)
+	resp.APIVersion = apiVersion
+	resp.Summary = summarize(groups)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// pagecursor marks the last file returned by a page. clients treat the encoded
+// form as opaque; it is base64url json of the group index and name plus the
+// path and index of the file within the group.
+type pageCursor struct {
+	GroupIndex int    `json:"g"`
+	GroupName  string `json:"n"`
+	Path       string `json:"p"`
+	Index      int    `json:"i"`
+}
+
+// encode returns the opaque string form of the cursor.
+func (c pageCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodecursor parses a cursor previously returned as next_cursor.
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil {
+		return c, errors.New("invalid cursor")
+	}
+	return c, nil
+}
+
+// paginate returns up to limit files following the cursor. files come in the
+// order the listing shows them, by group and then in each category's SortBy or
+// custom order, and a page resumes just after the cursor's file, so files added
+// or removed between requests don't shift the pages.
+func paginate(groups []MediaGroup, after *pageCursor, limit int) mediaResponse {
+	resp := mediaResponse{Groups: []MediaGroup{}}
+
+	// find where the previous page stopped
+	startGroup, startFile := 0, 0
+	if after != nil {
+		startGroup = len(groups)
+		for i, group := range groups {
+			if group.Name == after.GroupName {
+				startGroup = i
+				break
+			}
+		}
+
+		// a category that disappeared resumes at its old position
+		if startGroup == len(groups) && after.GroupIndex < len(groups) {
+			startGroup = after.GroupIndex
+		} else if startGroup < len(groups) {
+			startFile = resumeAt(groups[startGroup].Files, after)
+		}
+	}
+
+	remaining := limit
+	for gi := startGroup; gi < len(groups) && remaining > 0; gi++ {
+		files := groups[gi].Files
+		first := 0
+		if gi == startGroup {
+			first = startFile
+		}
+		if first >= len(files) {
+			continue
+		}
+
+		last := first + remaining
+		if last > len(files) {
+			last = len(files)
+		}
+		page := groups[gi]
+		page.Files = files[first:last]
+		resp.Groups = append(resp.Groups, page)
+		remaining -= last - first
+
+		// only hand out a cursor when there is more to fetch
+		if remaining == 0 && (last < len(files) || hasFilesAfter(groups, gi)) {
+			end := files[last-1]
+			resp.NextCursor = pageCursor{GroupIndex: gi, GroupName: page.Name, Path: end.Path, Index: last - 1}.encode()
+		}
+	}
+
+	return resp
+}
+
+// resumeat returns where the page after the cursor starts in files: just past
+// the cursor's file, or at the place it held when it has since been removed,
+// which the file after it has moved into.
+func resumeAt(files []MediaFile, after *pageCursor) int {
+	for i, file := range files {
+		if file.Path == after.Path {
+			return i + 1
+		}
+	}
+	if after.Index < 0 {
+		return 0
+	}
+	if after.Index > len(files) {
+		return len(files)
+	}
+	return after.Index
+}
+
+// hasfilesafter reports whether any group after index gi has files.
+func hasFilesAfter(groups []MediaGroup, gi int) bool {
+	for _, group := range groups[gi+1:] {
+		if len(group.Files) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// marshaljson adds the display name and reports the duration in seconds,
+// with how often the file was played when there's a library database.
+func (f MediaFile) MarshalJSON() ([]byte, error) {
+	plays := database.plays(f.Path)
+	var lastPlayed *time.Time
+	if plays.Count > 0 {
+		lastPlayed = &plays.Last
+	}
+	return json.Marshal(struct {
+		ID          string     `json:"id,omitempty"`
+		Name        string     `json:"name"`
+		DisplayName string     `json:"display_name"`
+		Path        string     `json:"path"`
+		Kind        mediaKind  `json:"kind,omitempty"`
+		Icon        string     `json:"icon"`
+		Size        int64      `json:"size"`
+		ModTime     time.Time  `json:"modtime"`
+		Duration    float64    `json:"duration,omitempty"`
+		Title       string     `json:"title,omitempty"`
+		Artist      string     `json:"artist,omitempty"`
+		AlbumArtist string     `json:"album_artist,omitempty"`
+		Album       string     `json:"album,omitempty"`
+		Track       int        `json:"track,omitempty"`
+		Subtitles   []Subtitle `json:"subtitles,omitempty"`
+		Plays       int        `json:"plays,omitempty"`
+		LastPlayed  *time.Time `json:"last_played,omitempty"`
+	}{
+		ID:          f.ID,
+		Name:        f.Name,
+		DisplayName: f.DisplayName(),
+		Path:        f.Path,
+		Kind:        f.Kind(),
+		Icon:        fileIcon(f.Path),
+		Size:        f.Size,
+		ModTime:     f.ModTime,
+		Duration:    f.Duration.Seconds(),
+		Title:       f.Title,
+		Artist:      f.Artist,
+		AlbumArtist: f.AlbumArtist,
+		Album:       f.Album,
+		Track:       f.Track,
+		Subtitles:   f.Subtitles,
+		Plays:       plays.Count,
+		LastPlayed:  lastPlayed,
+	})
+}
+
+// writejson writes v as a json response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Error writing json:", err)
+	}
+}
+
+// writejsonerror writes an error message as a json response.
+func writeJSONError(w http.ResponseWriter, message string, status int) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{Error: message})
+}
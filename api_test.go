@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// listed is a file as the api lists it, with its category.
+type listed struct {
+	Category string
+	Path     string
+}
+
+// getMedia fetches /api/media with the query and decodes the response.
+func getMedia(t *testing.T, h http.Handler, query url.Values) mediaResponse {
+	t.Helper()
+	w := request(h, http.MethodGet, "/api/media?"+query.Encode())
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/media?%s = %d: %s", query.Encode(), w.Code, w.Body)
+	}
+	var resp mediaResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding /api/media: %v", err)
+	}
+	return resp
+}
+
+// filesOf flattens the groups of a response in order.
+func filesOf(groups []MediaGroup) []listed {
+	var files []listed
+	for _, group := range groups {
+		for _, file := range group.Files {
+			files = append(files, listed{group.Name, file.Path})
+		}
+	}
+	return files
+}
+
+func TestPaginateToCompletion(t *testing.T) {
+	// testtree writes each file its own path, so the mp3s are largest first in
+	// sub/dddd, bbbbbbb, eeeee, cc, a order, which isn't path order
+	music := testTree(t, "a.mp3", "bbbbbbb.mp3", "cc.mp3", "sub/dddd.mp3", "eeeee.mp3")
+	films := testTree(t, "x.mkv", "yyy.mkv", "zz.mkv")
+	empty := testTree(t, "nothing/")
+	h := testServer(t, "[Musik]\nDirectory="+music+"\nFileTypes=.mp3\nSortBy=size\nReverse=true\n"+
+		"[Empty]\nDirectory="+empty+"\nFileTypes=.mp3\n"+
+		"[Films]\nOrder=-1\nDirectory="+films+"\nFileTypes=.mkv\nSortBy=name\n").routes()
+
+	want := filesOf(getMedia(t, h, nil).Groups)
+	if len(want) != 8 {
+		t.Fatalf("the unpaginated listing has %d files, want 8: %v", len(want), want)
+	}
+	if want[0].Category != "Films" || want[3].Path != "sub/dddd.mp3" {
+		t.Fatalf("the listing isn't in Order and SortBy order: %v", want)
+	}
+
+	for limit := 1; limit <= 9; limit++ {
+		var got []listed
+		query := url.Values{"limit": {strconv.Itoa(limit)}}
+		for pages := 0; ; pages++ {
+			if pages > len(want) {
+				t.Fatalf("limit %d: the cursor never ran out", limit)
+			}
+			resp := getMedia(t, h, query)
+			page := filesOf(resp.Groups)
+			if len(page) > limit {
+				t.Fatalf("limit %d: a page has %d files", limit, len(page))
+			}
+			got = append(got, page...)
+			if resp.NextCursor == "" {
+				break
+			}
+			query.Set("cursor", resp.NextCursor)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("limit %d: pages gave\n%v\nwant the listing\n%v", limit, got, want)
+		}
+	}
+}
+
+func TestPaginateResumesAfterRemovedFile(t *testing.T) {
+	files := []MediaFile{{Path: "c"}, {Path: "a"}, {Path: "b"}}
+	groups := []MediaGroup{{Name: "Music", Files: files}}
+	first := paginate(groups, nil, 2)
+	cursor, err := decodeCursor(first.NextCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last file of the first page went away before the second page
+	groups[0].Files = []MediaFile{{Path: "c"}, {Path: "b"}}
+	second := paginate(groups, &cursor, 2)
+	if got := filesOf(second.Groups); !reflect.DeepEqual(got, []listed{{"Music", "b"}}) {
+		t.Errorf("second page = %v, want just b", got)
+	}
+}
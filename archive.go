@@ -0,0 +1,217 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// archive is a zip file served as a read-only category.
+type archive struct {
+	file    *os.File
+	reader  *zip.Reader
+	members map[string]*zip.File
+}
+
+// archiveset holds the archives opened so far, by path.
+type archiveSet struct {
+	mu   sync.Mutex
+	open map[string]*archive
+}
+
+// archives keeps every archive category open after its first use, so
+// replacing the zip on disk needs a restart.
+var archives = &archiveSet{open: make(map[string]*archive)}
+
+// isarchive reports whether a category's Directory is a zip file rather than
+// a directory, going by its extension.
+func isArchive(dir string) bool {
+	return strings.EqualFold(filepath.Ext(dir), ".zip")
+}
+
+// openarchive returns the open archive at name, opening it on first use. a
+// failure isn't remembered, so a broken pack can be fixed without a restart.
+func openArchive(name string) (*archive, error) {
+	archives.mu.Lock()
+	defer archives.mu.Unlock()
+	if a, ok := archives.open[name]; ok {
+		return a, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	reader, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// index the regular files so a request doesn't scan the whole directory
+	a := &archive{file: f, reader: reader, members: make(map[string]*zip.File)}
+	for _, member := range reader.File {
+		if !member.FileInfo().IsDir() {
+			a.members[path.Clean(member.Name)] = member
+		}
+	}
+	archives.open[name] = a
+	return a, nil
+}
+
+// archivename turns a slash-separated path inside a category into a name in
+// the archive, where the root is "." and nothing can climb out of it.
+func archiveName(sub string) string {
+	name := strings.TrimPrefix(path.Clean("/"+sub), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// walkarchive collects the media files of a zip category, with the same
+// hidden, depth, file type and subtitle rules as a directory walk.
+func walkArchive(ctx context.Context, config CategoryConfig, server ServerConfig) (MediaGroup, error) {
+	group := MediaGroup{Name: config.Name, Title: config.Title, Directory: config.Directory, Files: []MediaFile{}}
+	a, err := openArchive(config.Directory)
+	if err != nil {
+		return offline(config, group, err), nil
+	}
+	online(config)
+	subtitles := subtitleIndex{}
+	orders := orderIndex{}
+	limit := maxFiles(config, server)
+
+	err = fs.WalkDir(a.reader, ".", func(relPath string, entry fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			log.Println("Error reading archive:", err)
+			return nil
+		}
+		if relPath == "." {
+			return nil
+		}
+
+		if !entry.IsDir() && entry.Name() == orderFileName {
+			orders.add(config, relPath)
+			return nil
+		}
+
+		// prune hidden entries like on disk, and the __MACOSX folder macos adds to zips
+		if !server.ShowHidden && (isHidden(entry.Name()) || entry.Name() == "__MACOSX") {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			if config.MaxDepth > 0 && pathDepth(relPath) > config.MaxDepth {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if server.Subtitles && isSubtitle(relPath) {
+			subtitles.add(relPath)
+		}
+		if !isAllowedFileType(relPath, config.FileTypes) {
+			return nil
+		}
+		if limit > 0 && len(group.Files) >= limit {
+			group.Truncated = true
+			return fs.SkipAll
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+
+		// durations and tags are read from files on disk, so members go without them
+		group.Files = append(group.Files, MediaFile{Name: info.Name(), Path: relPath, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return MediaGroup{}, err
+	}
+	sortMedia(group.Files, config, server)
+	orders.apply(group.Files)
+	subtitles.attach(group.Files)
+	if server.StableIDs {
+		assignIDs(config.Name, group.Files)
+	}
+	warnTruncated(config, group, limit)
+	group.Cover = findCover(config, server, "")
+	group.Description = findDescription(config, "")
+
+	metrics.setCategoryFiles(config.Name, len(group.Files))
+	return group, nil
+}
+
+// member returns the regular file at sub, a slash-separated path in the archive.
+func (a *archive) member(sub string) (*zip.File, bool) {
+	member, ok := a.members[archiveName(sub)]
+	return member, ok
+}
+
+// serve writes a member as the response. stored members, as media usually is,
+// are read straight from the zip so range requests and seeking work; compressed
+// ones can only be streamed from the start.
+func (a *archive) serve(w http.ResponseWriter, r *http.Request, member *zip.File) {
+	if member.Method == zip.Store {
+		offset, err := member.DataOffset()
+		if err == nil {
+			content := io.NewSectionReader(a.file, offset, int64(member.UncompressedSize64))
+			http.ServeContent(w, r, member.Name, member.Modified, content)
+			return
+		}
+	}
+
+	rc, err := member.Open()
+	if err != nil {
+		http.Error(w, "could not read archive", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	if ctype := mime.TypeByExtension(path.Ext(member.Name)); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	w.Header().Set("Content-Length", strconv.FormatUint(member.UncompressedSize64, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, rc)
+}
+
+// opencategoryfile opens a file by its slash-separated path inside a category,
+// from the directory or from the archive.
+func openCategoryFile(config CategoryConfig, relPath string) (io.ReadCloser, error) {
+	if !isArchive(config.Directory) {
+		return os.Open(filepath.Join(config.partWith(relPath).Directory, filepath.FromSlash(relPath)))
+	}
+	a, err := openArchive(config.Directory)
+	if err != nil {
+		return nil, err
+	}
+	member, ok := a.member(relPath)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return member.Open()
+}
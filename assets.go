@@ -0,0 +1,55 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// assets holds the static files compiled into the binary.
+//
+//go:embed assets
+var assets embed.FS
+
+// assethandler serves the embedded assets under /assets/.
+func assetHandler() http.Handler {
+	sub, err := fs.Sub(assets, "assets")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix("/assets/", http.FileServer(http.FS(sub)))
+
+	// embedded files carry no modification time, so let browsers cache them for a day
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// only individual files are served, never a listing of the directory
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		files.ServeHTTP(w, r)
+	})
+}
+
+// handlefavicon serves the embedded favicon so browsers stop hitting the listing.
+func handleFavicon(w http.ResponseWriter, r *http.Request) {
+	icon, err := assets.ReadFile("assets/favicon.ico")
+	if err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// the icon never changes for a given build, so let browsers keep it for a week
+	w.Header().Set("Content-Type", "image/x-icon")
+	w.Header().Set("Cache-Control", "public, max-age=604800")
+	w.Write(icon)
+}
+
+// handlecustomcss serves the stylesheet named by CustomCSS in the config.
+func (s *server) handleCustomCSS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	http.ServeFile(w, r, s.cfg.Server.CustomCSS)
+}
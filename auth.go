@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sort"
+)
+
+// private reports whether the category needs a username and password.
+func (c CategoryConfig) private() bool {
+	return c.AuthUser != "" || c.AuthPass != ""
+}
+
+// canaccess reports whether the request may see the category, either because it
+// is public or because the request carries the category's credentials.
+func canAccess(r *http.Request, c CategoryConfig) bool {
+	if !c.private() {
+		return true
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	// compare both in constant time so neither leaks through timing
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(c.AuthUser)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(c.AuthPass)) == 1
+	return userOK && passOK
+}
+
+// isadmin reports whether the request carries the admin login. without an
+// AdminUser and AdminPass in the config nobody is an admin.
+func (s *server) isAdmin(r *http.Request) bool {
+	if s.cfg.Server.AdminUser == "" || s.cfg.Server.AdminPass == "" {
+		return false
+	}
+	return canAccess(r, CategoryConfig{AuthUser: s.cfg.Server.AdminUser, AuthPass: s.cfg.Server.AdminPass})
+}
+
+// categories returns the categories the request may see, lowest Order first
+// and in config order otherwise. private categories are left out entirely for
+// requests without their credentials.
+func (s *server) categories(r *http.Request) []CategoryConfig {
+	configs := make([]CategoryConfig, 0, len(s.cfg.Categories))
+	for _, config := range s.cfg.Categories {
+		if canAccess(r, config) && config.visible() {
+			configs = append(configs, config)
+		}
+	}
+	sort.SliceStable(configs, func(i, j int) bool { return configs[i].Order < configs[j].Order })
+	return configs
+}
+
+// locked reports whether a private category is hidden from the request for
+// want of its login, so the listing offers one.
+func (s *server) locked(r *http.Request) bool {
+	for _, config := range s.cfg.Categories {
+		if config.private() && !canAccess(r, config) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize reports whether the request may use the category, asking for
+// credentials with a 401 when it may not.
+func (s *server) authorize(w http.ResponseWriter, r *http.Request, c CategoryConfig) bool {
+	if canAccess(r, c) {
+		return true
+	}
+	challenge(w)
+	return false
+}
+
+// challenge asks the browser for a username and password.
+func challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="chill", charset="UTF-8"`)
+	http.Error(w, "authentication required", http.StatusUnauthorized)
+}
+
+// handlelogin asks for credentials until they match a private category, then
+// returns to the listing, where that category is now shown. private categories
+// are hidden from the listing, so this is how a browser gets to send credentials.
+func (s *server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	for _, config := range s.cfg.Categories {
+		if config.private() && canAccess(r, config) {
+			http.Redirect(w, r, s.cfg.Server.BasePath+"/", http.StatusSeeOther)
+			return
+		}
+	}
+	challenge(w)
+}
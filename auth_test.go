@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// requestAs sends a request to h with a basic auth login.
+func requestAs(h http.Handler, method, target, user, pass string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	r.SetBasicAuth(user, pass)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestPrivateCategory(t *testing.T) {
+	public := testTree(t, "cartoon.mp4")
+	private := testTree(t, "late/film.mkv")
+	h := testServer(t, "[Cartoons]\nDirectory="+public+"\nFileTypes=.mp4\n"+
+		"[Late]\nDirectory="+private+"\nFileTypes=.mkv\nAuthUser=grown\nAuthPass=up\n").routes()
+
+	// without the login the category isn't listed anywhere
+	for _, target := range []string{"/", "/api/media", "/api/categories", "/search?q=.m"} {
+		body := request(h, http.MethodGet, target).Body.String()
+		if !strings.Contains(body, "cartoon.mp4") && !strings.Contains(body, "Cartoons") {
+			t.Errorf("GET %s lost the public category:\n%s", target, body)
+		}
+		if strings.Contains(body, "Late") || strings.Contains(body, "film.mkv") {
+			t.Errorf("GET %s shows the private category without its login:\n%s", target, body)
+		}
+	}
+
+	// and its files can't be fetched, with or without a wrong login
+	for _, target := range []string{"/late/film.mkv", "/browse/Late/", "/browse/Late/late/", "/download/Late.zip"} {
+		for _, login := range [][2]string{{"", ""}, {"grown", "down"}, {"kid", "up"}} {
+			w := requestAs(h, http.MethodGet, target, login[0], login[1])
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("GET %s as %q = %d, want 401", target, login[0], w.Code)
+			}
+			if strings.Contains(w.Body.String(), "late/film.mkv") {
+				t.Errorf("GET %s as %q shows the file:\n%s", target, login[0], w.Body)
+			}
+		}
+	}
+	if w := request(h, http.MethodGet, "/cartoon.mp4"); w.Code != http.StatusOK {
+		t.Errorf("GET /cartoon.mp4 = %d, want the public file", w.Code)
+	}
+
+	// with the login it's listed and served
+	if body := requestAs(h, http.MethodGet, "/", "grown", "up").Body.String(); !strings.Contains(body, "film.mkv") {
+		t.Errorf("the listing with the login leaves out the private file:\n%s", body)
+	}
+	if w := requestAs(h, http.MethodGet, "/late/film.mkv", "grown", "up"); w.Code != http.StatusOK || w.Body.String() != "late/film.mkv" {
+		t.Errorf("GET /late/film.mkv with the login = %d %q", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strconv"
+	"sync"
+)
+
+// bcrypt hashes look like $2b$10$ followed by 22 characters of salt and 31 of
+// hash, as made by htpasswd -B or any bcrypt library. chill only checks them,
+// it never makes them.
+const (
+	bcryptSaltLen = 22
+	bcryptHashLen = 31
+	bcryptMinCost = 4
+	bcryptMaxCost = 31
+
+	// bcrypt only uses the first 72 bytes of a password
+	bcryptMaxKey = 72
+)
+
+// bcryptencoding is base64 with bcrypt's own alphabet and no padding.
+var bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").WithPadding(base64.NoPadding)
+
+// bcryptmagic is encrypted 64 times with the expanded key to make the hash.
+var bcryptMagic = []byte("OrpheanBeholderScryDoubt")
+
+// bcrypthash is a parsed bcrypt hash.
+type bcryptHash struct {
+	cost int
+	salt []byte
+	sum  string
+}
+
+// parsebcrypt reads a $2a$, $2b$ or $2y$ hash. the three only differ in how
+// old implementations mishandled long or non-ascii passwords, so they're
+// checked the same way.
+func parseBcrypt(s string) (bcryptHash, error) {
+	var h bcryptHash
+	if len(s) != 7+bcryptSaltLen+bcryptHashLen || s[0] != '$' || s[1] != '2' || s[3] != '$' || s[6] != '$' {
+		return h, errors.New("not a bcrypt hash")
+	}
+	switch s[2] {
+	case 'a', 'b', 'y':
+	default:
+		return h, errors.New("unsupported bcrypt version")
+	}
+	cost, err := strconv.Atoi(s[4:6])
+	if err != nil || cost < bcryptMinCost || cost > bcryptMaxCost {
+		return h, errors.New("invalid bcrypt cost")
+	}
+
+	// 22 characters hold 132 bits, the last 4 are unused
+	salt, err := bcryptEncoding.DecodeString(s[7 : 7+bcryptSaltLen])
+	if err != nil || len(salt) != 16 {
+		return h, errors.New("invalid bcrypt salt")
+	}
+	h.cost = cost
+	h.salt = salt
+	h.sum = s[7+bcryptSaltLen:]
+	return h, nil
+}
+
+// matches reports whether password hashes to h.
+func (h bcryptHash) matches(password string) bool {
+	sum := bcryptSum(h.cost, h.salt, password)
+	return subtle.ConstantTimeCompare([]byte(sum), []byte(h.sum)) == 1
+}
+
+// bcryptsum runs the expensive key setup and encrypts the magic text with it,
+// returning the 31 characters that follow the salt in a hash.
+func bcryptSum(cost int, salt []byte, password string) string {
+	key := append([]byte(password), 0)
+	if len(key) > bcryptMaxKey {
+		key = key[:bcryptMaxKey]
+	}
+
+	c := newBlowfish()
+	c.expandKey(key, salt)
+	for i := 0; i < 1<<cost; i++ {
+		c.expandKey(key, nil)
+		c.expandKey(salt, nil)
+	}
+
+	text := make([]uint32, len(bcryptMagic)/4)
+	pos := 0
+	for i := range text {
+		text[i] = streamWord(bcryptMagic, &pos)
+	}
+	for i := 0; i < 64; i++ {
+		for j := 0; j < len(text); j += 2 {
+			text[j], text[j+1] = c.encrypt(text[j], text[j+1])
+		}
+	}
+
+	// the last of the 24 bytes is dropped, a quirk every implementation keeps
+	out := make([]byte, 0, len(bcryptMagic))
+	for _, word := range text {
+		out = append(out, byte(word>>24), byte(word>>16), byte(word>>8), byte(word))
+	}
+	return bcryptEncoding.EncodeToString(out[:23])
+}
+
+// blowfish is the cipher state bcrypt expands its key into.
+type blowfish struct {
+	p [18]uint32
+	s [4][256]uint32
+}
+
+// piwords is the fractional part of pi, 32 bits at a time, which blowfish
+// starts from: the first 18 words are its p-array and the next 1024 its
+// s-boxes. they're worked out once, on first use, instead of kept as a table.
+var (
+	piOnce  sync.Once
+	piTable []uint32
+)
+
+// piwords returns the words of pi, working them out the first time.
+func piWords() []uint32 {
+	piOnce.Do(func() { piTable = computePiWords() })
+	return piTable
+}
+
+// computepiwords works out the words of pi for piwords.
+func computePiWords() []uint32 {
+	const words = 18 + 4*256
+	const guard = 64
+	bits := uint(words*32 + guard)
+
+	// machin's formula, pi = 16 atan(1/5) - 4 atan(1/239), in fixed point
+	pi := new(big.Int).Mul(atanInverse(5, bits), big.NewInt(16))
+	pi.Sub(pi, new(big.Int).Mul(atanInverse(239, bits), big.NewInt(4)))
+
+	// drop the 3 and the guard bits, leaving just the fraction
+	pi.Sub(pi, new(big.Int).Lsh(big.NewInt(3), bits))
+	pi.Rsh(pi, guard)
+
+	out := make([]uint32, words)
+	mask := big.NewInt(0xffffffff)
+	word := new(big.Int)
+	for i := words - 1; i >= 0; i-- {
+		out[i] = uint32(word.And(pi, mask).Uint64())
+		pi.Rsh(pi, 32)
+	}
+	return out
+}
+
+// ataninverse returns atan(1/x) scaled by 2^bits.
+func atanInverse(x int64, bits uint) *big.Int {
+	x2 := big.NewInt(x * x)
+	term := new(big.Int).Lsh(big.NewInt(1), bits)
+	term.Quo(term, big.NewInt(x))
+	sum := new(big.Int).Set(term)
+	t := new(big.Int)
+	for k := int64(1); term.Sign() != 0; k++ {
+		term.Quo(term, x2)
+		t.Quo(term, big.NewInt(2*k+1))
+		if k%2 == 1 {
+			sum.Sub(sum, t)
+		} else {
+			sum.Add(sum, t)
+		}
+	}
+	return sum
+}
+
+// newblowfish returns blowfish in its initial state, before any key.
+func newBlowfish() *blowfish {
+	words := piWords()
+	c := &blowfish{}
+	copy(c.p[:], words)
+	for i := range c.s {
+		copy(c.s[i][:], words[18+256*i:])
+	}
+	return c
+}
+
+// streamword reads the next big-endian word from data, wrapping around to its
+// start, which is how blowfish stretches a short key or salt.
+func streamWord(data []byte, pos *int) uint32 {
+	var word uint32
+	for i := 0; i < 4; i++ {
+		word = word<<8 | uint32(data[*pos])
+		*pos = (*pos + 1) % len(data)
+	}
+	return word
+}
+
+// expandkey mixes key into the state, and salt into every block the state is
+// rebuilt from. a nil salt is the plain blowfish key schedule.
+func (c *blowfish) expandKey(key, salt []byte) {
+	pos := 0
+	for i := range c.p {
+		c.p[i] ^= streamWord(key, &pos)
+	}
+
+	var l, r uint32
+	saltPos := 0
+	next := func() {
+		if salt != nil {
+			l ^= streamWord(salt, &saltPos)
+			r ^= streamWord(salt, &saltPos)
+		}
+		l, r = c.encrypt(l, r)
+	}
+	for i := 0; i < len(c.p); i += 2 {
+		next()
+		c.p[i], c.p[i+1] = l, r
+	}
+	for i := range c.s {
+		for j := 0; j < len(c.s[i]); j += 2 {
+			next()
+			c.s[i][j], c.s[i][j+1] = l, r
+		}
+	}
+}
+
+// encrypt runs one block through the 16 blowfish rounds.
+func (c *blowfish) encrypt(l, r uint32) (uint32, uint32) {
+	for i := 0; i < 16; i += 2 {
+		l ^= c.p[i]
+		r ^= c.f(l)
+		r ^= c.p[i+1]
+		l ^= c.f(r)
+	}
+	l ^= c.p[16]
+	r ^= c.p[17]
+	return r, l
+}
+
+// f is blowfish's round function.
+func (c *blowfish) f(x uint32) uint32 {
+	return ((c.s[0][x>>24] + c.s[1][x>>16&0xff]) ^ c.s[2][x>>8&0xff]) + c.s[3][x&0xff]
+}
@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestBcryptVectors(t *testing.T) {
+
+	// the openbsd and crypt_blowfish test vectors, in both the $2a$ and the
+	// $2b$ spelling, which only differ for passwords over 255 bytes
+	tests := []struct {
+		password string
+		hash     string
+	}{
+		{"U*U", "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"},
+		{"U*U*", "$2a$05$CCCCCCCCCCCCCCCCCCCCC.VGOzA784oUp/Z0DY336zx7pLYAy0lwK"},
+		{"U*U*U", "$2a$05$XXXXXXXXXXXXXXXXXXXXXOAcXxm9kjPGEMsLznoKqmqw7tc8WCx4a"},
+		{"", "$2a$05$CCCCCCCCCCCCCCCCCCCCC.7uG0VCzI2bS7j6ymqJi9CdcdxiRTWNy"},
+		{"0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789chars after 72 are ignored", "$2a$05$abcdefghijklmnopqrstuu5s2v8.iXieOjg/.AySBTTZIIVFJeBui"},
+		{"U*U", "$2b$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"},
+		{"", "$2b$05$CCCCCCCCCCCCCCCCCCCCC.7uG0VCzI2bS7j6ymqJi9CdcdxiRTWNy"},
+		{"0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789chars after 72 are ignored", "$2b$05$abcdefghijklmnopqrstuu5s2v8.iXieOjg/.AySBTTZIIVFJeBui"},
+		{"correct horse battery staple", "$2b$04$abcdefghijklmnopqrstuu7EJV7kdjBBQxyb0HjTh9KS7.Lah/6CG"},
+
+		// non-ascii passwords are hashed as their utf-8 bytes
+		{"£", "$2a$05$/OK.fbVrR/bpIqNJ5ianF.crQZGxQ7hWEf.fNKRjrYcudfgPvGbVK"},
+		{"£", "$2b$05$/OK.fbVrR/bpIqNJ5ianF.crQZGxQ7hWEf.fNKRjrYcudfgPvGbVK"},
+		{"émigré", "$2a$04$0123456789abcdefghijke/1Gw8r951MPpxKCtVEQSbmx.FZ0BPjm"},
+	}
+	for _, tt := range tests {
+		h, err := parseBcrypt(tt.hash)
+		if err != nil {
+			t.Errorf("parseBcrypt(%s): %v", tt.hash, err)
+			continue
+		}
+		if !h.matches(tt.password) {
+			t.Errorf("%s doesn't match %q", tt.hash, tt.password)
+		}
+		if h.matches("x" + tt.password) {
+			t.Errorf("%s matches %q", tt.hash, "x"+tt.password)
+		}
+	}
+}
+
+func TestParseBcryptErrors(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"not a hash",
+		"$2x$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"$2a$03$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"$2a$32$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"$2a$xx$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOe",
+		"$2a$05$CCCCCCCCCCCCCCCCCCCC!.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+	} {
+		if _, err := parseBcrypt(hash); err == nil {
+			t.Errorf("parseBcrypt(%q) succeeded, want an error", hash)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// crumb is one link in the breadcrumb trail of a browse page.
+type crumb struct {
+	Name string
+	Path string
+}
+
+// browsedata is what the browse template renders.
+type browseData struct {
+	SiteTitle   string
+	BasePath    string
+	Offline     bool
+	Theme       string
+	Category    string
+	Title       string
+	Crumbs      []crumb
+	Dirs        []crumb
+	Files       []MediaFile
+	External    *externalLink
+	Transcode   bool
+	Cover       string
+	Description string
+}
+
+// handlebrowse lists the immediate contents of one directory inside a category
+// at /browse/{category}/{subpath}, with a breadcrumb back to the category root.
+func (s *server) handleBrowse(w http.ResponseWriter, r *http.Request) {
+	name, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/browse/"), "/")
+	config, ok := s.category(name)
+	if !ok {
+		s.notFound(w, r)
+		return
+	}
+	if !s.authorize(w, r, config) {
+		return
+	}
+
+	// a category root without the trailing slash gets one so relative links work
+	if sub == "" && !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, s.cfg.Server.BasePath+r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+	s.browse(w, r, config, strings.Trim(sub, "/"))
+}
+
+// browse renders the browse page for sub, a slash-separated directory in the
+// category, once the request has been allowed to see it.
+func (s *server) browse(w http.ResponseWriter, r *http.Request, config CategoryConfig, sub string) {
+	dirs, files, ok := s.readCategoryDir(config, sub)
+	if !ok {
+		s.notFound(w, r)
+		return
+	}
+
+	// the category's title, when it has one, is shown in place of its name
+	title := config.Title
+	if title == "" {
+		title = config.Name
+	}
+
+	data := browseData{
+		SiteTitle:   s.cfg.Server.SiteTitle,
+		BasePath:    s.cfg.Server.BasePath,
+		Offline:     s.cfg.Server.Offline,
+		Theme:       s.theme(w, r),
+		Category:    config.Name,
+		Title:       title,
+		Crumbs:      breadcrumbs(title, sub),
+		Dirs:        dirs,
+		Files:       files,
+		External:    s.external(r),
+		Transcode:   s.cfg.Server.Transcode,
+		Cover:       findCover(config, s.cfg.Server, sub),
+		Description: findDescription(config, sub),
+	}
+
+	if err := s.browseTmpl.Execute(w, data); err != nil {
+		log.Println("Error executing template:", err)
+	}
+}
+
+// what a request for a directory outside /browse/ gets, set by DirectoryRequests
+const (
+	dirRequestRedirect = "redirect"
+	dirRequestIndex    = "index"
+	dirRequestOff      = "off"
+)
+
+// isvaliddirrequests reports whether mode is a DirectoryRequests setting.
+func isValidDirRequests(mode string) bool {
+	switch mode {
+	case dirRequestRedirect, dirRequestIndex, dirRequestOff:
+		return true
+	}
+	return false
+}
+
+// servedirectory answers a request for a directory inside a category, like
+// /Albums/Blue, reporting false when there's no such directory or
+// DirectoryRequests is off. by default it redirects to the browse page, and
+// with index it shows that page at the requested path instead.
+func (s *server) serveDirectory(w http.ResponseWriter, r *http.Request) bool {
+	mode := s.cfg.Server.DirectoryRequests
+	if mode == dirRequestOff || s.cfg.Server.Kiosk {
+		return false
+	}
+	if !s.cfg.Server.ShowHidden && hasHiddenSegment(r.URL.Path) {
+		return false
+	}
+	config, _, ok := s.find(r, r.URL.Path, s.hasDir)
+	if !ok {
+		return false
+	}
+
+	// the folder is shown from every directory of a glob category, not just
+	// the one it was found in
+	config, _ = s.category(config.Name)
+	if !s.authorize(w, r, config) {
+		return true
+	}
+	sub := strings.Trim(path.Clean(r.URL.Path), "/")
+
+	if mode == dirRequestIndex {
+
+		// the trailing slash marks a directory, the same as on /browse/
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			target := url.URL{Path: s.cfg.Server.BasePath + r.URL.Path + "/", RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+			return true
+		}
+		s.browse(w, r, config, sub)
+		return true
+	}
+
+	target := url.URL{Path: s.cfg.Server.BasePath + "/browse/" + config.Name + "/" + sub + "/", RawQuery: r.URL.RawQuery}
+	http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+	return true
+}
+
+// readcategorydir lists the immediate subdirectories and media files of sub, a
+// slash-separated path inside the category. it reports false for paths outside
+// the category, past its depth limit, hidden, or that can't be read, and for
+// collections, which have no folders of their own.
+func (s *server) readCategoryDir(config CategoryConfig, sub string) ([]crumb, []MediaFile, bool) {
+	if isCollection(config) {
+		return nil, nil, false
+	}
+	tooDeep := config.MaxDepth > 0 && pathDepth(sub) > config.MaxDepth
+	if tooDeep || (!s.cfg.Server.ShowHidden && hasHiddenSegment(sub)) {
+		return nil, nil, false
+	}
+
+	// entries come back sorted by name, so directories and files stay in order.
+	// a glob category shows the folder from each of its directories together,
+	// with a name in an earlier one hiding the same name in a later one
+	dirs := []crumb{}
+	files := []MediaFile{}
+	subtitles := subtitleIndex{}
+	orders := orderIndex{}
+	seen := make(map[string]bool)
+	found := false
+	for _, part := range config.parts() {
+		dir, ok := resolveInCategory(part.Directory, sub)
+		if !ok {
+			return nil, nil, false
+		}
+		entries, err := readDir(part, dir, sub)
+		if err != nil {
+			continue
+		}
+		found = true
+		for _, entry := range entries {
+			relPath := path.Join(sub, entry.Name())
+			if seen[relPath] {
+				continue
+			}
+			seen[relPath] = true
+			if !entry.IsDir() && entry.Name() == orderFileName {
+				orders.add(part, relPath)
+				continue
+			}
+			if !s.cfg.Server.ShowHidden && isHidden(entry.Name()) {
+				continue
+			}
+
+			if entry.IsDir() {
+
+				// directories past the depth limit aren't walked, so they aren't offered either
+				if config.MaxDepth == 0 || pathDepth(relPath) <= config.MaxDepth {
+					dirs = append(dirs, crumb{Name: entry.Name(), Path: relPath})
+				}
+				continue
+			}
+			if s.cfg.Server.Subtitles && isSubtitle(entry.Name()) {
+				subtitles.add(relPath)
+			}
+			if !isAllowedFileType(entry.Name(), config.FileTypes) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			if isArchive(config.Directory) {
+				files = append(files, MediaFile{Name: info.Name(), Path: relPath, Size: info.Size(), ModTime: info.ModTime()})
+				continue
+			}
+			files = append(files, newMediaFile(filepath.Join(dir, entry.Name()), relPath, info, s.cfg.Server))
+		}
+	}
+	if !found {
+		return nil, nil, false
+	}
+	if len(config.Directories) > 1 {
+		sort.Slice(dirs, func(i, j int) bool { return dirs[i].Name < dirs[j].Name })
+		sort.SliceStable(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	}
+	sortMedia(files, config, s.cfg.Server)
+	orders.apply(files)
+	subtitles.attach(files)
+	if s.cfg.Server.StableIDs {
+		assignIDs(config.Name, files)
+	}
+
+	return dirs, files, true
+}
+
+// readdir lists dir on disk, or sub inside the archive for a zip category.
+func readDir(config CategoryConfig, dir, sub string) ([]fs.DirEntry, error) {
+	if !isArchive(config.Directory) {
+		return os.ReadDir(dir)
+	}
+	a, err := openArchive(config.Directory)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadDir(a.reader, archiveName(sub))
+}
+
+// breadcrumbs returns the trail from the category root, labelled title, down to sub.
+func breadcrumbs(title, sub string) []crumb {
+	crumbs := []crumb{{Name: title, Path: ""}}
+	if sub == "" {
+		return crumbs
+	}
+	var walked string
+	for _, part := range strings.Split(sub, "/") {
+		walked = path.Join(walked, part)
+		crumbs = append(crumbs, crumb{Name: part, Path: walked})
+	}
+	return crumbs
+}
+
+// resolveincategory joins a slash-separated path onto a category directory,
+// refusing anything that would escape the directory.
+func resolveInCategory(dir, sub string) (string, bool) {
+	full := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+sub)))
+	rel, err := filepath.Rel(dir, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return full, true
+}
+
+// html template for browsing a single directory of a category
+const browseTemplate = `
+<!DOCTYPE html>
+<html lang="en"{{if ne .Theme "auto"}} data-bs-theme="{{.Theme}}"{{end}}>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    {{if .Offline}}<link href="{{.BasePath}}/assets/bootstrap.min.css" rel="stylesheet">{{else}}<link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0-alpha3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-KK94CHFLLe+nY2dmCWGMq91rCGa5gtU4mk92HdvYe+M/SXH301p5ILy+dN9+nJOZ" crossorigin="anonymous">{{end}}
+		<title>{{.Title}} - {{.SiteTitle}}</title>
+    <style>
+        .cover {
+            max-height: 12rem;
+            max-width: 100%;
+            border-radius: 0.25rem;
+        }
+
+        .description {
+            white-space: pre-line;
+        }
+    </style>
+    {{template "icons"}}
+    {{template "theme" .}}
+</head>
+<body>
+<div class="container-fluid">
+    <div class="row">
+        <div class="col">
+            <h1><a href="{{.BasePath}}/">{{.SiteTitle}}</a></h1>
+            {{template "theme-switch"}}
+            <p>
+                {{range $i, $c := .Crumbs}}{{if $i}} / {{end}}<a href="{{$.BasePath}}/browse/{{$.Category}}/{{$c.Path}}{{if $c.Path}}/{{end}}">{{$c.Name}}</a>{{end}}
+            </p>
+            {{if .Cover}}<p><a href="{{.BasePath}}/{{.Cover}}" target="_blank"><img src="{{.BasePath}}/{{.Cover}}" alt="" class="cover"></a></p>{{end}}
+            {{if .Description}}<p class="description text-muted">{{.Description}}</p>{{end}}
+        </div>
+    </div>
+    <div class="row">
+        <div class="col">
+            <ul>
+                {{range .Dirs}}
+                <li><a href="{{$.BasePath}}/browse/{{$.Category}}/{{.Path}}/"><strong>{{.Name}}/</strong></a></li>
+                {{end}}
+                {{range .Files}}
+                <li>
+                    <i class="{{icon .Path}}" aria-hidden="true"></i><a href="{{$.BasePath}}/{{.Path}}" name="{{.Path}}" title="{{.Path}}" target="_blank">{{.DisplayName}}</a>
+                    {{if and $.Category (or (eq .Kind "audio") (eq .Kind "video"))}}<small><a href="{{$.BasePath}}/play/{{$.Category}}/{{.Path}}">play</a></small>{{end}}
+                    {{if and $.Transcode (eq .Kind "video")}}<small><a href="{{$.BasePath}}/transcode/{{.Path}}" target="_blank">mp4</a></small>{{end}}
+                    {{if .ID}}<small><a href="{{$.BasePath}}/id/{{.ID}}" title="permanent link">link</a></small>{{end}}
+                    {{if $.External}}<small><a href="{{$.External.Link .Path}}">open</a></small>{{end}}
+                    {{if .Duration}}<small class="text-muted">{{duration .Duration}}</small>{{end}}
+                    {{range .Subtitles}}<small><a href="{{$.BasePath}}/subtitles/{{.Path}}" target="_blank">cc{{if .Language}} {{.Language}}{{end}}</a></small>{{end}}
+                </li>
+                {{end}}
+            </ul>
+        </div>
+    </div>
+</div>
+</body>
+</html>
+`
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDirectoryRequests(t *testing.T) {
+	dir := testTree(t, "Albums/Blue/song.mp3", "Albums/.secret/hidden.mp3")
+
+	type want struct {
+		code     int
+		location string
+	}
+	tests := []struct {
+		mode    string
+		target  string
+		want    want
+		showing string
+	}{
+		{dirRequestRedirect, "/Albums/Blue", want{http.StatusMovedPermanently, "/browse/Music/Albums/Blue/"}, ""},
+		{dirRequestRedirect, "/Albums/Blue/", want{http.StatusMovedPermanently, "/browse/Music/Albums/Blue/"}, ""},
+		{dirRequestRedirect, "/Albums/Blue?sort=name", want{http.StatusMovedPermanently, "/browse/Music/Albums/Blue/?sort=name"}, ""},
+		{dirRequestRedirect, "/Albums/.secret", want{http.StatusNotFound, ""}, ""},
+		{dirRequestRedirect, "/Albums/.secret/", want{http.StatusNotFound, ""}, ""},
+
+		{dirRequestIndex, "/Albums/Blue", want{http.StatusMovedPermanently, "/Albums/Blue/"}, ""},
+		{dirRequestIndex, "/Albums/Blue/", want{http.StatusOK, ""}, "song.mp3"},
+		{dirRequestIndex, "/Albums/", want{http.StatusOK, ""}, "Blue"},
+		{dirRequestIndex, "/Albums/.secret", want{http.StatusNotFound, ""}, ""},
+		{dirRequestIndex, "/Albums/.secret/", want{http.StatusNotFound, ""}, ""},
+
+		{dirRequestOff, "/Albums/Blue", want{http.StatusNotFound, ""}, ""},
+		{dirRequestOff, "/Albums/Blue/", want{http.StatusNotFound, ""}, ""},
+		{dirRequestOff, "/Albums/.secret", want{http.StatusNotFound, ""}, ""},
+		{dirRequestOff, "/Albums/.secret/", want{http.StatusNotFound, ""}, ""},
+	}
+	handlers := map[string]http.Handler{}
+	for _, mode := range []string{dirRequestRedirect, dirRequestIndex, dirRequestOff} {
+		handlers[mode] = testServer(t, "[Server]\nDirectoryRequests="+mode+"\n[Music]\nDirectory="+dir+"\nFileTypes=.mp3\n").routes()
+	}
+	for _, tt := range tests {
+		w := request(handlers[tt.mode], http.MethodGet, tt.target)
+		got := want{w.Code, w.Header().Get("Location")}
+		if got != tt.want {
+			t.Errorf("%s: GET %s = %d %q, want %d %q", tt.mode, tt.target, got.code, got.location, tt.want.code, tt.want.location)
+		}
+		if !strings.Contains(w.Body.String(), tt.showing) {
+			t.Errorf("%s: GET %s doesn't show %s:\n%s", tt.mode, tt.target, tt.showing, w.Body)
+		}
+		if strings.Contains(w.Body.String(), "hidden.mp3") {
+			t.Errorf("%s: GET %s shows the hidden folder's file", tt.mode, tt.target)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checksums remembers every file's sha-256 until the file changes, so
+// verifying a category again only reads what's new.
+var checksums = newFileCache[string]("checksums")
+
+// filechecksum returns the hex sha-256 of a file in a category, from the cache
+// when the file hasn't changed since it was last hashed.
+func fileChecksum(config CategoryConfig, relPath string) (string, error) {
+	var key string
+	var info fs.FileInfo
+	if isArchive(config.Directory) {
+		a, err := openArchive(config.Directory)
+		if err != nil {
+			return "", err
+		}
+		member, ok := a.member(relPath)
+		if !ok {
+			return "", fs.ErrNotExist
+		}
+		key, info = config.Directory+"/"+member.Name, member.FileInfo()
+	} else {
+		key = filepath.Join(config.partWith(relPath).Directory, filepath.FromSlash(relPath))
+		var err error
+		if info, err = os.Stat(key); err != nil {
+			return "", err
+		}
+	}
+
+	// a file that can't be read caches as empty, until it changes
+	var readErr error
+	sum := checksums.get(key, info, func(string) string {
+		var h string
+		h, readErr = hashCategoryFile(config, relPath)
+		return h
+	})
+	if readErr != nil {
+		return "", readErr
+	}
+	if sum == "" {
+		return "", fs.ErrInvalid
+	}
+	return sum, nil
+}
+
+// hashcategoryfile reads a whole file from a category and returns its hex sha-256.
+func hashCategoryFile(config CategoryConfig, relPath string) (string, error) {
+	f, err := openCategoryFile(config, relPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksumline formats a sha256sum line. like gnu sha256sum, a name with a
+// backslash or a newline is escaped and the line marked with a leading one.
+func checksumLine(sum, name string) string {
+	if !strings.ContainsAny(name, "\\\n") {
+		return sum + "  " + name + "\n"
+	}
+	name = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name)
+	return "\\" + sum + "  " + name + "\n"
+}
+
+// handlechecksums writes a category's checksums at /checksums/{category}.txt,
+// in the format sha256sum -c reads, with paths relative to the category's
+// folder. each line is sent as soon as its file is hashed, so a big category
+// starts arriving right away and nothing is held in memory.
+func (s *server) handleChecksums(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/checksums/"), ".txt")
+	if !ok {
+		s.notFound(w, r)
+		return
+	}
+
+	// a collection's files belong to other categories, which have their own list
+	config, ok := s.category(name)
+	if !ok || isCollection(config) {
+		s.notFound(w, r)
+		return
+	}
+	if !s.authorize(w, r, config) {
+		return
+	}
+
+	ctx, cancel := walkContext(r.Context(), s.cfg.Server)
+	group, err := walkCategory(ctx, config, s.cfg.Server)
+	cancel()
+	if err != nil {
+		message, status := libraryError(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// hashing reads every file in full, so it takes a stream slot like a download
+	release, ok := s.startStream(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+	withoutDeadline(w)
+
+	flusher := http.NewResponseController(w)
+	for _, file := range group.Files {
+		if r.Context().Err() != nil {
+			return
+		}
+		sum, err := fileChecksum(config, file.Path)
+		if err != nil {
+			log.Printf("Error hashing %s: %v", file.Path, err)
+			continue
+		}
+		if _, err := io.WriteString(w, checksumLine(sum, file.Path)); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// iscollection reports whether a category is a view over other categories,
+// listing their files through From instead of walking a Directory of its own.
+func isCollection(config CategoryConfig) bool {
+	return len(config.From) > 0
+}
+
+// splitsource splits a From entry like Music/BestOf into the category name and
+// the slash-separated folder inside it, which is empty for the whole category.
+func splitSource(entry string) (string, string) {
+	name, sub, _ := strings.Cut(strings.Trim(entry, "/"), "/")
+	sub = strings.Trim(path.Clean("/"+sub), "/")
+	return name, sub
+}
+
+// resolvecollections checks every From entry against the loaded categories
+// and records the categories each collection draws on. a collection can't
+// have a Directory, draw on another collection, or draw on a private
+// category, whose files would otherwise show up without its login.
+func (b *configBuilder) resolveCollections() error {
+	byName := make(map[string]CategoryConfig, len(b.cfg.Categories))
+	for _, config := range b.cfg.Categories {
+		byName[config.Name] = config
+	}
+
+	for i, config := range b.cfg.Categories {
+		if !isCollection(config) {
+			continue
+		}
+		if config.Directory != "" {
+			return fmt.Errorf("category %s: From and Directory can't be used together", config.Name)
+		}
+
+		seen := make(map[string]bool)
+		for _, entry := range config.From {
+			name, _ := splitSource(entry)
+			source, ok := byName[name]
+			switch {
+			case !ok:
+				return fmt.Errorf("category %s: From names %s, which is not a category", config.Name, name)
+			case isCollection(source):
+				return fmt.Errorf("category %s: From names %s, which is itself a collection", config.Name, name)
+			case source.private():
+				return fmt.Errorf("category %s: From names %s, which is private", config.Name, name)
+			}
+			if !seen[name] {
+				seen[name] = true
+				b.cfg.Categories[i].sources = append(b.cfg.Categories[i].sources, source)
+			}
+		}
+	}
+	return nil
+}
+
+// collect builds a collection's group from the files of the folders in From.
+// walked returns a source's group when the same walk already has it, so a
+// listing walks every category once; any other source is walked here.
+func collect(ctx context.Context, config CategoryConfig, server ServerConfig, walked func(name string) (MediaGroup, bool, error)) (MediaGroup, error) {
+	sources := make(map[string]MediaGroup, len(config.sources))
+	for _, source := range config.sources {
+
+		// a source outside its visibility window adds nothing until it's back
+		if !source.visible() {
+			continue
+		}
+		var group MediaGroup
+		var ok bool
+		var err error
+		if walked != nil {
+			group, ok, err = walked(source.Name)
+		}
+		if !ok {
+			group, err = walkCategory(ctx, source, server)
+		}
+		if err != nil {
+			return MediaGroup{}, err
+		}
+		sources[source.Name] = group
+	}
+
+	// a file named by two entries, like Music and Music/BestOf, is listed once
+	group := MediaGroup{Name: config.Name, Title: config.Title, Files: []MediaFile{}}
+	seen := make(map[string]bool)
+	for _, entry := range config.From {
+		name, sub := splitSource(entry)
+		for _, file := range sources[name].Files {
+			if sub != "" && file.Path != sub && !strings.HasPrefix(file.Path, sub+"/") {
+				continue
+			}
+			if len(config.FileTypes) > 0 && !isAllowedFileType(file.Path, config.FileTypes) {
+				continue
+			}
+			if key := name + "/" + file.Path; !seen[key] {
+				seen[key] = true
+				group.Files = append(group.Files, file)
+			}
+		}
+	}
+	sortMedia(group.Files, config, server)
+	return group, nil
+}
@@ -0,0 +1,1156 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serversection is the reserved section name holding server-wide settings.
+const serverSection = "Server"
+
+// stdinconfig is the config file name that reads from standard input.
+const stdinConfig = "-"
+
+// defaultconfigs are the config files looked for in the working directory
+// without -config, in this order. the first that exists is used, and config.cfg
+// when none do, so the error names the original format.
+var defaultConfigs = []string{"config.cfg", "config.toml", "config.yaml", "config.yml"}
+
+// configpath returns the config file to read: the one named by -config, even
+// when it doesn't exist, or else the default.
+func configPath(named string) string {
+	if named != "" {
+		return named
+	}
+	return defaultConfig()
+}
+
+// defaultconfig returns the config file to read when -config isn't given.
+func defaultConfig() string {
+	for _, name := range defaultConfigs {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return defaultConfigs[0]
+}
+
+// includekey names another config file to load in place, in any format.
+const includeKey = "Include"
+
+// config represents the fully parsed configuration file.
+type Config struct {
+	Server     ServerConfig
+	Categories []CategoryConfig
+}
+
+// serverconfig represents the server-wide settings from the [Server] section.
+type ServerConfig struct {
+	Listen               string
+	TLSCert              string
+	TLSKey               string
+	SiteTitle            string
+	BasePath             string
+	Metrics              bool
+	WalkConcurrency      int
+	RecentCount          int
+	MaxZipSize           int64
+	ShowHidden           bool
+	Durations            bool
+	Tags                 bool
+	CustomCSS            string
+	Offline              bool
+	Subtitles            bool
+	HideEmpty            bool
+	FeedCount            int
+	WalkTimeout          time.Duration
+	IndexInterval        time.Duration
+	Watch                bool
+	WatchConfig          bool
+	AllowOrigin          []string
+	Headers              http.Header
+	MIMETypes            map[string]string
+	Icons                map[string]string
+	Theme                string
+	LazyLoad             bool
+	BufferListing        bool
+	DirectoryRequests    string
+	AdminUser            string
+	AdminPass            string
+	AuthUser             string
+	AuthHash             string
+	ExternalScheme       string
+	StableIDs            bool
+	MaxFiles             int
+	MaxConcurrentStreams int
+	AccessLogFile        string
+	PlaylistFile         string
+	Database             string
+	AccessLogMaxSize     int64
+	SortBy               string
+	Reverse              bool
+	CoverNames           []string
+	Transcode            bool
+	FFmpeg               string
+	HLS                  bool
+	HLSCacheDir          string
+	HLSCacheSize         int64
+	Sprites              bool
+	Thumbnails           bool
+	Checksums            bool
+	Kiosk                bool
+	KioskCategory        string
+
+	// http server timeouts, 0 turns one off
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// how long a shutdown waits for requests in flight, 0 waits for them all
+	ShutdownTimeout time.Duration
+}
+
+// categoryconfig represents the configuration for a media category.
+// the json tags shape /admin/config, which never includes the password.
+type CategoryConfig struct {
+	Name             string   `json:"name"`
+	Title            string   `json:"title,omitempty"`
+	Order            int      `json:"order,omitempty"`
+	Directory        string   `json:"directory"`
+	Directories      []string `json:"directories,omitempty"`
+	FileTypes        []string `json:"file_types"`
+	MaxDepth         int      `json:"max_depth,omitempty"`
+	MaxFiles         int      `json:"max_files,omitempty"`
+	SortBy           string   `json:"sort_by,omitempty"`
+	Reverse          bool     `json:"reverse,omitempty"`
+	StayOnFilesystem bool     `json:"stay_on_filesystem,omitempty"`
+	AuthUser         string   `json:"auth_user,omitempty"`
+	AuthPass         string   `json:"-"`
+	From             []string `json:"from,omitempty"`
+	VisibleFrom      string   `json:"visible_from,omitempty"`
+	VisibleUntil     string   `json:"visible_until,omitempty"`
+	TranscodeFormats []string `json:"transcode_formats,omitempty"`
+
+	// the categories From draws on, found once the whole config is loaded
+	sources []CategoryConfig
+}
+
+// configvalue is a single value from any config format, either a scalar or a list.
+type configValue struct {
+	raw    string
+	list   []string
+	isList bool
+}
+
+// scalarvalue wraps a plain string value.
+func scalarValue(s string) configValue {
+	return configValue{raw: s}
+}
+
+// listvalue wraps a list of string values.
+func listValue(items []string) configValue {
+	return configValue{list: items, isList: true}
+}
+
+// strings returns the value as a list, splitting scalars on commas.
+func (v configValue) strings() []string {
+	items := v.list
+	if !v.isList {
+		items = strings.Split(v.raw, ",")
+	}
+
+	// trim spaces from each item
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		out = append(out, strings.TrimSpace(item))
+	}
+	return out
+}
+
+// scalar returns the value as a single string, rejecting lists.
+func (v configValue) scalar(key string) (string, error) {
+	if v.isList {
+		return "", fmt.Errorf("invalid value for %s: expected a single value, got a list", key)
+	}
+	return v.raw, nil
+}
+
+// configbuilder accumulates sections and keys from any config format into a config.
+type configBuilder struct {
+	cfg *Config
+
+	// current is the index of the active category, -1 means no category is active
+	current int
+
+	// inserver tracks whether keys belong to the [Server] section
+	inServer bool
+
+	// dir is the directory of the file being parsed, for relative includes
+	dir string
+
+	// loading holds the files currently being parsed, by absolute path, so an
+	// include cycle is caught; loaded holds the files already merged in
+	loading map[string]bool
+	loaded  map[string]bool
+}
+
+// defaultsitetitle names the pages and the feed when SiteTitle isn't set.
+const defaultSiteTitle = "Chill Media Player"
+
+// newconfigbuilder creates a builder with no active section.
+func newConfigBuilder() *configBuilder {
+	return &configBuilder{
+		cfg: &Config{Server: ServerConfig{
+			SiteTitle:         defaultSiteTitle,
+			DirectoryRequests: dirRequestRedirect,
+			AccessLogMaxSize:  defaultAccessLogMaxSize,
+			HLSCacheSize:      defaultHLSCacheSize,
+			ReadHeaderTimeout: defaultReadHeaderTimeout,
+			IdleTimeout:       defaultIdleTimeout,
+			ShutdownTimeout:   defaultShutdownTimeout,
+		}},
+		current: -1,
+		loading: make(map[string]bool),
+		loaded:  make(map[string]bool),
+	}
+}
+
+// load parses a config file into the builder, picking the format from the
+// extension: .toml, .yaml/.yml, or the ini-style .cfg. a file name of - reads
+// the ini-style format from standard input.
+func (b *configBuilder) load(configFile string) error {
+
+	// stdin has no path, so its includes are relative to the working directory
+	abs := stdinConfig
+	dir := "."
+	if configFile != stdinConfig {
+		var err error
+		abs, err = filepath.Abs(configFile)
+		if err != nil {
+			return err
+		}
+		dir = filepath.Dir(abs)
+	}
+
+	// a file that is still being parsed further up the chain includes itself
+	if b.loading[abs] {
+		return fmt.Errorf("include cycle: %s is already being loaded", configFile)
+	}
+
+	// a file shared by several includes is only merged once
+	if b.loaded[abs] {
+		return nil
+	}
+
+	// open the configuration file
+	var r io.Reader = os.Stdin
+	if configFile != stdinConfig {
+		file, err := os.Open(configFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	// the included file starts with no active section, and the including file
+	// carries on in the section it was in
+	prevDir, current, inServer := b.dir, b.current, b.inServer
+	b.dir, b.current, b.inServer = dir, -1, false
+	b.loading[abs] = true
+	defer func() {
+		b.dir, b.current, b.inServer = prevDir, current, inServer
+		delete(b.loading, abs)
+		b.loaded[abs] = true
+	}()
+
+	var err error
+	switch strings.ToLower(filepath.Ext(configFile)) {
+	case ".toml":
+		err = parseTOML(r, b)
+	case ".yaml", ".yml":
+		err = parseYAML(r, b)
+	default:
+		err = parseINI(r, b)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", configFile, err)
+	}
+	return nil
+}
+
+// include loads every file named by an Include key, relative to the file that
+// names it. categories are appended and later server settings override earlier ones.
+func (b *configBuilder) include(value configValue) error {
+	for _, name := range value.strings() {
+		if name == "" {
+			continue
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(b.dir, name)
+		}
+		if err := b.load(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// section starts a new section, either the server section or a new category.
+func (b *configBuilder) section(name string) {
+
+	// the server section holds settings rather than a category
+	if strings.EqualFold(name, serverSection) {
+		b.inServer = true
+		b.current = -1
+		return
+	}
+
+	// create a new categoryconfig for the category
+	b.inServer = false
+	b.cfg.Categories = append(b.cfg.Categories, CategoryConfig{Name: name})
+
+	// update the current category index
+	b.current = len(b.cfg.Categories) - 1
+}
+
+// set applies a key-value pair to the active section.
+func (b *configBuilder) set(key string, value configValue) error {
+
+	// includes are allowed anywhere, even before the first section
+	if key == includeKey {
+		return b.include(value)
+	}
+
+	// server settings are handled separately from category keys
+	if b.inServer {
+		return b.cfg.Server.set(key, value)
+	}
+
+	// ignore keys that appear before any section
+	if b.current < 0 {
+		return nil
+	}
+
+	return b.cfg.Categories[b.current].set(key, value)
+}
+
+// expandglobs finds the directories of each category whose Directory is a
+// glob, like /archive/20*, and keeps them in Directories, so /archive/2021 and
+// /archive/2022 are listed and served together as one category. Directory
+// keeps the pattern. a pattern matching nothing leaves Directories empty, so
+// the walk and -dry-run report it like any missing directory.
+func (b *configBuilder) expandGlobs() error {
+	for i, config := range b.cfg.Categories {
+		if !isGlob(config.Directory) {
+			continue
+		}
+		matches, err := filepath.Glob(config.Directory)
+		if err != nil {
+			return fmt.Errorf("category %s: invalid Directory pattern %q", config.Name, config.Directory)
+		}
+		var dirs []string
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				dirs = append(dirs, match)
+			}
+		}
+		b.cfg.Categories[i].Directories = dirs
+	}
+	return nil
+}
+
+// isglob reports whether a Directory value holds glob metacharacters.
+func isGlob(dir string) bool {
+	return strings.ContainsAny(dir, "*?[")
+}
+
+// roots returns the directories a category lists: the ones its Directory glob
+// matched, or else Directory itself.
+func (c CategoryConfig) roots() []string {
+	if len(c.Directories) > 0 {
+		return c.Directories
+	}
+	return []string{c.Directory}
+}
+
+// parts returns the category once for each of its roots, with that directory
+// as its Directory, for code that works on one directory at a time. a path is
+// looked for in the parts in turn, so the first directory with it wins, the
+// same way the first category with a path serves it.
+func (c CategoryConfig) parts() []CategoryConfig {
+	if len(c.Directories) == 0 {
+		return []CategoryConfig{c}
+	}
+	parts := make([]CategoryConfig, 0, len(c.Directories))
+	for _, dir := range c.Directories {
+		part := c
+		part.Directory = dir
+		part.Directories = nil
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// partwith returns the part of the category that has relpath, a file or folder
+// given as a slash-separated path, or the first part when none has it.
+func (c CategoryConfig) partWith(relPath string) CategoryConfig {
+	parts := c.parts()
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	for _, part := range parts {
+		if dir, ok := resolveInCategory(part.Directory, relPath); ok {
+			if _, err := os.Stat(dir); err == nil {
+				return part
+			}
+		}
+	}
+	return parts[0]
+}
+
+// loadmediadirectories returns only the media categories from the config file.
+func LoadMediaDirectories(configFile string) ([]CategoryConfig, error) {
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Categories, nil
+}
+
+// loadconfig parses the config file, and any files it includes, into server
+// settings and media categories.
+func LoadConfig(configFile string) (*Config, error) {
+	b := newConfigBuilder()
+	if err := b.load(configFile); err != nil {
+		return nil, err
+	}
+	if err := b.expandGlobs(); err != nil {
+		return nil, err
+	}
+	if err := b.resolveCollections(); err != nil {
+		return nil, err
+	}
+
+	// return the populated configuration
+	return b.cfg, nil
+}
+
+// parseconfig parses an ini-style config from r without touching the file
+// system, except for files it includes, which are relative to the working directory.
+func ParseConfig(r io.Reader) (*Config, error) {
+	b := newConfigBuilder()
+	b.dir = "."
+	if err := parseINI(r, b); err != nil {
+		return nil, err
+	}
+	if err := b.expandGlobs(); err != nil {
+		return nil, err
+	}
+	if err := b.resolveCollections(); err != nil {
+		return nil, err
+	}
+	return b.cfg, nil
+}
+
+// parsemediaconfig returns only the media categories from an ini-style config in r.
+func ParseMediaConfig(r io.Reader) ([]CategoryConfig, error) {
+	cfg, err := ParseConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Categories, nil
+}
+
+// parseini reads the original ini-style config format.
+func parseINI(r io.Reader, b *configBuilder) error {
+
+	// create a scanner to read the file line by line
+	scanner := bufio.NewScanner(r)
+
+	// iterate over each line in the file
+	for scanner.Scan() {
+		line := scanner.Text()
+		line = strings.TrimSpace(stripINIComment(line))
+
+		// skip empty lines and lines starting with '#' or ';' (comments)
+		if len(line) == 0 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		// check if the line represents a new section
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			b.section(line[1 : len(line)-1])
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		// extract the key and value from the line
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		// quotes let a value keep a '#' or ';' after a space
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+
+		if err := b.set(key, scalarValue(value)); err != nil {
+			return err
+		}
+	}
+
+	// check for any scanner errors
+	return scanner.Err()
+}
+
+// stripinicomment removes a trailing comment, which starts at a '#' or ';' that
+// follows whitespace and isn't inside double quotes. values such as /music/c#
+// or a;b keep their characters.
+func stripINIComment(line string) string {
+	inQuote := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '"':
+			inQuote = !inQuote
+		case (c == '#' || c == ';') && !inQuote && i > 0 && (line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// set applies a single key-value pair from the [Server] section.
+func (s *ServerConfig) set(key string, value configValue) error {
+	switch key {
+	case "Listen":
+		addr, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		s.Listen = addr
+	case "TLSCert", "TLSKey":
+		path, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		if key == "TLSCert" {
+			s.TLSCert = path
+		} else {
+			s.TLSKey = path
+		}
+	case "BasePath":
+		base, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		s.BasePath = normalizeBasePath(base)
+	case "Metrics":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.Metrics = enabled
+	case "WalkConcurrency":
+		n, err := parseInt(key, value)
+		if err != nil {
+			return err
+		}
+		s.WalkConcurrency = n
+	case "RecentCount":
+		n, err := parseInt(key, value)
+		if err != nil {
+			return err
+		}
+		s.RecentCount = n
+	case "MaxZipSize":
+		n, err := parseSize(key, value)
+		if err != nil {
+			return err
+		}
+		s.MaxZipSize = n
+	case "ShowHidden":
+		show, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.ShowHidden = show
+	case "Durations":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.Durations = enabled
+	case "Tags":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.Tags = enabled
+	case "SiteTitle":
+		title, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+
+		// an empty title would leave the pages without a heading, so keep the default
+		if title = strings.TrimSpace(title); title != "" {
+			s.SiteTitle = title
+		}
+	case "CustomCSS":
+		path, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		s.CustomCSS = path
+	case "Offline":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.Offline = enabled
+	case "Subtitles":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.Subtitles = enabled
+	case "HideEmpty":
+		hide, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.HideEmpty = hide
+	case "FeedCount":
+		n, err := parseInt(key, value)
+		if err != nil {
+			return err
+		}
+		s.FeedCount = n
+	case "WalkTimeout":
+		d, err := parseDuration(key, value)
+		if err != nil {
+			return err
+		}
+		s.WalkTimeout = d
+	case "IndexInterval":
+		d, err := parseDuration(key, value)
+		if err != nil {
+			return err
+		}
+		s.IndexInterval = d
+	case "Watch":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.Watch = enabled
+	case "WatchConfig":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.WatchConfig = enabled
+	case "ReadHeaderTimeout", "ReadTimeout", "WriteTimeout", "IdleTimeout", "ShutdownTimeout":
+		d, err := parseDuration(key, value)
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "ReadHeaderTimeout":
+			s.ReadHeaderTimeout = d
+		case "ReadTimeout":
+			s.ReadTimeout = d
+		case "WriteTimeout":
+			s.WriteTimeout = d
+		case "ShutdownTimeout":
+			s.ShutdownTimeout = d
+		default:
+			s.IdleTimeout = d
+		}
+	case "Theme":
+		theme, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		theme = strings.ToLower(strings.TrimSpace(theme))
+		if !isValidTheme(theme) {
+			return fmt.Errorf("invalid value for %s: %q", key, theme)
+		}
+		s.Theme = theme
+	case "LazyLoad":
+		lazy, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.LazyLoad = lazy
+	case "AdminUser":
+		user, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		s.AdminUser = user
+	case "AdminPass":
+		pass, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		s.AdminPass = pass
+	case "AuthUser":
+		user, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		s.AuthUser = user
+	case "AuthHash":
+		hash, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+
+		// a hash that can't be checked would lock everyone out, so refuse it now
+		if _, err := parseBcrypt(hash); err != nil {
+			return fmt.Errorf("invalid value for %s: %v", key, err)
+		}
+		s.AuthHash = hash
+	case "Transcode":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.Transcode = enabled
+	case "HLS":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.HLS = enabled
+	case "HLSCacheDir":
+		dir, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		s.HLSCacheDir = strings.TrimSpace(dir)
+	case "HLSCacheSize":
+		n, err := parseSize(key, value)
+		if err != nil {
+			return err
+		}
+		s.HLSCacheSize = n
+	case "FFmpeg":
+		path, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		s.FFmpeg = strings.TrimSpace(path)
+	case "Sprites":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.Sprites = enabled
+	case "Thumbnails":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.Thumbnails = enabled
+	case "DirectoryRequests":
+		mode, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		mode = strings.ToLower(strings.TrimSpace(mode))
+		if !isValidDirRequests(mode) {
+			return fmt.Errorf("invalid value for %s: %q", key, mode)
+		}
+		s.DirectoryRequests = mode
+	case "BufferListing":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.BufferListing = enabled
+	case "Checksums":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.Checksums = enabled
+	case "Kiosk":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.Kiosk = enabled
+	case "KioskCategory":
+		name, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		s.KioskCategory = strings.TrimSpace(name)
+	case "MaxFiles":
+		n, err := parseInt(key, value)
+		if err != nil {
+			return err
+		}
+		s.MaxFiles = n
+	case "AccessLogFile":
+		path, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		s.AccessLogFile = strings.TrimSpace(path)
+	case "Database":
+		path, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		s.Database = strings.TrimSpace(path)
+	case "PlaylistFile":
+		path, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		s.PlaylistFile = strings.TrimSpace(path)
+	case "AccessLogMaxSize":
+		n, err := parseSize(key, value)
+		if err != nil {
+			return err
+		}
+		s.AccessLogMaxSize = n
+	case "MaxConcurrentStreams":
+		n, err := parseInt(key, value)
+		if err != nil {
+			return err
+		}
+		s.MaxConcurrentStreams = n
+	case "SortBy":
+		by, err := parseSortBy(key, value)
+		if err != nil {
+			return err
+		}
+		s.SortBy = by
+	case "Reverse":
+		reverse, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.Reverse = reverse
+	case "StableIDs":
+		enabled, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		s.StableIDs = enabled
+	case "ExternalScheme":
+		scheme, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		s.ExternalScheme = scheme
+	case "CoverNames":
+
+		// an empty value turns covers off, which is different from leaving it unset
+		s.CoverNames = []string{}
+		for _, name := range value.strings() {
+			if name != "" {
+				s.CoverNames = append(s.CoverNames, name)
+			}
+		}
+	case "AllowOrigin":
+		s.AllowOrigin = nil
+		for _, origin := range value.strings() {
+			if origin != "" {
+				s.AllowOrigin = append(s.AllowOrigin, origin)
+			}
+		}
+	default:
+
+		// Header.Name keys add a response header, a later one replacing an earlier one
+		if name, ok := strings.CutPrefix(key, headerKeyPrefix); ok && name != "" {
+			header, err := value.scalar(key)
+			if err != nil {
+				return err
+			}
+			if s.Headers == nil {
+				s.Headers = make(http.Header)
+			}
+			s.Headers.Set(name, header)
+		}
+
+		// MIME.ext keys add or replace the type an extension is served with
+		if ext, ok := strings.CutPrefix(key, mimeKeyPrefix); ok && ext != "" {
+			mediaType, err := value.scalar(key)
+			if err != nil {
+				return err
+			}
+			mediaType = strings.TrimSpace(mediaType)
+			if parsed, _, err := mime.ParseMediaType(mediaType); err != nil || !strings.Contains(parsed, "/") {
+				return fmt.Errorf("invalid value for %s: %q", key, mediaType)
+			}
+			if s.MIMETypes == nil {
+				s.MIMETypes = make(map[string]string)
+			}
+			s.MIMETypes[normalizeFileType("."+strings.TrimPrefix(ext, "."))] = mediaType
+		}
+
+		// Icon.ext keys pick the icon an extension is listed with
+		if ext, ok := strings.CutPrefix(key, iconKeyPrefix); ok && ext != "" {
+			name, err := parseIconName(key, value)
+			if err != nil {
+				return err
+			}
+			if s.Icons == nil {
+				s.Icons = make(map[string]string)
+			}
+			s.Icons[normalizeFileType("."+strings.TrimPrefix(ext, "."))] = name
+		}
+	}
+	return nil
+}
+
+// set applies a single key-value pair to a category.
+func (c *CategoryConfig) set(key string, value configValue) error {
+
+	// process the key-value pair based on the key
+	switch key {
+	case "Directory":
+
+		// set the directory for the current category
+		dir, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		c.Directory = dir
+	case "Title":
+
+		// set the heading shown for the category instead of its name
+		title, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		c.Title = title
+	case "FileTypes":
+
+		// set the normalized file types for the current category
+		c.FileTypes = normalizeFileTypes(value.strings())
+	case "From":
+
+		// list folders of other categories, like Music/BestOf, instead of a directory
+		c.From = nil
+		for _, entry := range value.strings() {
+			if entry != "" {
+				c.From = append(c.From, entry)
+			}
+		}
+	case "Order":
+
+		// move the category up or down the listing, lower first and negative allowed
+		v, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %q", key, v)
+		}
+		c.Order = n
+	case "VisibleFrom", "VisibleUntil":
+
+		// only show the category between these local times of day
+		t, err := parseTimeOfDay(key, value)
+		if err != nil {
+			return err
+		}
+		if key == "VisibleFrom" {
+			c.VisibleFrom = t
+		} else {
+			c.VisibleUntil = t
+		}
+	case "MaxDepth":
+
+		// limit how many directory levels below the root are walked
+		n, err := parseInt(key, value)
+		if err != nil {
+			return err
+		}
+		c.MaxDepth = n
+	case "MaxFiles":
+
+		// stop listing the category after this many files
+		n, err := parseInt(key, value)
+		if err != nil {
+			return err
+		}
+		c.MaxFiles = n
+	case "SortBy":
+
+		// set the order the category's files are listed in
+		by, err := parseSortBy(key, value)
+		if err != nil {
+			return err
+		}
+		c.SortBy = by
+	case "Reverse":
+
+		// list the category's files in the opposite order
+		reverse, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		c.Reverse = reverse
+	case "TranscodeFormats":
+
+		// limit what /transcode/ may convert the category's files to
+		formats, err := parseTranscodeFormats(key, value)
+		if err != nil {
+			return err
+		}
+		c.TranscodeFormats = formats
+	case "StayOnFilesystem":
+
+		// skip directories that are mount points of other filesystems
+		stay, err := parseBool(key, value)
+		if err != nil {
+			return err
+		}
+		c.StayOnFilesystem = stay
+	case "AuthUser":
+
+		// require a username and password for the category
+		user, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		c.AuthUser = user
+	case "AuthPass":
+		pass, err := value.scalar(key)
+		if err != nil {
+			return err
+		}
+		c.AuthPass = pass
+	}
+	return nil
+}
+
+// parsebool parses a boolean config value, naming the key on failure.
+func parseBool(key string, value configValue) (bool, error) {
+	s, err := value.scalar(key)
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid value for %s: %q", key, s)
+	}
+	return b, nil
+}
+
+// parseint parses a non-negative integer config value, naming the key on failure.
+func parseInt(key string, value configValue) (int, error) {
+	s, err := value.scalar(key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %q", key, s)
+	}
+	return n, nil
+}
+
+// parseduration parses a non-negative duration such as 30s or 2m, naming the key
+// on failure.
+func parseDuration(key string, value configValue) (time.Duration, error) {
+	s, err := value.scalar(key)
+	if err != nil {
+		return 0, err
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %q", key, s)
+	}
+	return d, nil
+}
+
+// parsesortby reads a SortBy value, naming the key on failure.
+func parseSortBy(key string, value configValue) (string, error) {
+	by, err := value.scalar(key)
+	if err != nil {
+		return "", err
+	}
+	by = strings.ToLower(strings.TrimSpace(by))
+	if !isValidSort(by) {
+		return "", fmt.Errorf("invalid value for %s: %q", key, by)
+	}
+	return by, nil
+}
+
+// parsesize parses a byte count with an optional K, M, G or T suffix, naming the
+// key on failure.
+func parseSize(key string, value configValue) (int64, error) {
+	s, err := value.scalar(key)
+	if err != nil {
+		return 0, err
+	}
+
+	// pick the multiplier from the suffix, if any
+	num := strings.ToUpper(strings.TrimSpace(s))
+	num = strings.TrimSuffix(num, "B")
+	multiplier := int64(1)
+	for i, suffix := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(num, suffix) {
+			num = strings.TrimSuffix(num, suffix)
+			multiplier = int64(1) << (10 * (i + 1))
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %q", key, s)
+	}
+	return n * multiplier, nil
+}
+
+// normalizebasepath gives a base path a leading slash and no trailing slash, so
+// "media/", "/media" and "/media/" all become "/media" and "/" becomes "".
+func normalizeBasePath(base string) string {
+	base = strings.Trim(strings.TrimSpace(base), "/")
+	if base == "" {
+		return ""
+	}
+	return "/" + base
+}
+
+// normalizefiletypes lowercases each extension, adds a missing leading dot and
+// drops empty entries, so "MP3, .Flac," becomes [".mp3", ".flac"].
+func normalizeFileTypes(fileTypes []string) []string {
+	out := make([]string, 0, len(fileTypes))
+	for _, fileType := range fileTypes {
+		fileType = normalizeFileType(fileType)
+
+		// a lone dot would match names ending in one, like "notes."
+		if fileType == "" || fileType == "." {
+			continue
+		}
+		if !strings.HasPrefix(fileType, ".") {
+			fileType = "." + fileType
+		}
+		out = append(out, fileType)
+	}
+	return out
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// category is the part of a parsed category the parser tests compare.
+type category struct {
+	Name      string
+	Directory string
+	FileTypes []string
+}
+
+// categoriesOf cuts parsed categories down to what the tests compare.
+func categoriesOf(configs []CategoryConfig) []category {
+	out := []category{}
+	for _, config := range configs {
+		out = append(out, category{config.Name, config.Directory, config.FileTypes})
+	}
+	return out
+}
+
+func TestParseMediaConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    []category
+		wantErr bool
+	}{
+		{
+			name:   "empty",
+			config: "",
+			want:   []category{},
+		},
+		{
+			name:   "one category",
+			config: "[Music]\nDirectory=/music\nFileTypes=.mp3,.flac\n",
+			want:   []category{{"Music", "/music", []string{".mp3", ".flac"}}},
+		},
+		{
+			name:   "comments",
+			config: "# a comment\n[Music]\n# Directory=/elsewhere\nDirectory=/music\n  # indented\nFileTypes=.mp3\n",
+			want:   []category{{"Music", "/music", []string{".mp3"}}},
+		},
+		{
+			name:   "blank lines",
+			config: "\n\n[Music]\n\n   \nDirectory=/music\n\t\nFileTypes=.mp3\n\n",
+			want:   []category{{"Music", "/music", []string{".mp3"}}},
+		},
+		{
+			name:   "section headers",
+			config: "[Music]\nDirectory=/music\nFileTypes=.mp3\n[Films]\nDirectory=/films\nFileTypes=.mkv\n",
+			want: []category{
+				{"Music", "/music", []string{".mp3"}},
+				{"Films", "/films", []string{".mkv"}},
+			},
+		},
+		{
+			name:   "server section is not a category",
+			config: "[Server]\nSiteTitle=Den\n[Music]\nDirectory=/music\nFileTypes=.mp3\n",
+			want:   []category{{"Music", "/music", []string{".mp3"}}},
+		},
+		{
+			name:   "keys before any section are ignored",
+			config: "Directory=/nowhere\n[Music]\nDirectory=/music\nFileTypes=.mp3\n",
+			want:   []category{{"Music", "/music", []string{".mp3"}}},
+		},
+		{
+			name:   "directory with spaces around it",
+			config: "[Music]\nDirectory =   /my music/albums  \nFileTypes=.mp3\n",
+			want:   []category{{"Music", "/my music/albums", []string{".mp3"}}},
+		},
+		{
+			name:   "quoted directory",
+			config: "[Music]\nDirectory=\"/music # best\"\nFileTypes=.mp3\n",
+			want:   []category{{"Music", "/music # best", []string{".mp3"}}},
+		},
+		{
+			name:   "file types with spaces",
+			config: "[Music]\nDirectory=/music\nFileTypes = .mp3 , .FLAC,  ogg ,\n",
+			want:   []category{{"Music", "/music", []string{".mp3", ".flac", ".ogg"}}},
+		},
+		{
+			name:   "malformed lines are skipped",
+			config: "[Music]\nthis line has no equals sign\n[unclosed\nDirectory=/music\n=\nFileTypes=.mp3\n",
+			want:   []category{{"Music", "/music", []string{".mp3"}}},
+		},
+		{
+			name:   "full-line comments in both styles",
+			config: "# hash\n; semicolon\n[Music]\n;Directory=/elsewhere\n#Directory=/elsewhere\nDirectory=/music\n\t; indented\nFileTypes=.mp3\n",
+			want:   []category{{"Music", "/music", []string{".mp3"}}},
+		},
+		{
+			name:   "inline comments in both styles",
+			config: "[Music] ; the good stuff\nDirectory=/music # on the nas\nFileTypes = .mp3 ; audio only\n",
+			want:   []category{{"Music", "/music", []string{".mp3"}}},
+		},
+		{
+			name:   "comment characters inside values",
+			config: "[C#]\nDirectory=/music/c#;live\nFileTypes=.mp3\n[Quoted]\nDirectory=\"/music/a ;b #c\" # comment\nFileTypes=.mp3\n",
+			want: []category{
+				{"C#", "/music/c#;live", []string{".mp3"}},
+				{"Quoted", "/music/a ;b #c", []string{".mp3"}},
+			},
+		},
+		{
+			name:    "malformed value",
+			config:  "[Music]\nDirectory=/music\nMaxDepth=deep\n",
+			wantErr: true,
+		},
+		{
+			name:    "malformed server value",
+			config:  "[Server]\nMetrics=maybe\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs, err := ParseMediaConfig(strings.NewReader(tt.config))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseMediaConfig succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMediaConfig: %v", err)
+			}
+			if got := categoriesOf(configs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripINIComment(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"Directory=/music", "Directory=/music"},
+		{"Directory=/music # on the nas", "Directory=/music "},
+		{"Directory=/music\t; on the nas", "Directory=/music\t"},
+		{"Directory=/music/c#", "Directory=/music/c#"},
+		{"Directory=/music/c;d", "Directory=/music/c;d"},
+		{"Directory=/music#1 ;comment", "Directory=/music#1 "},
+		{`Directory="/music # not a comment"`, `Directory="/music # not a comment"`},
+		{`Directory="/a ; b" ; comment`, `Directory="/a ; b" `},
+		// whole-line comments are left for the parser, which skips the line
+		{"# a whole line", "# a whole line"},
+		{"; a whole line", "; a whole line"},
+	}
+	for _, tt := range tests {
+		if got := stripINIComment(tt.line); got != tt.want {
+			t.Errorf("stripINIComment(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestDirectoryGlob(t *testing.T) {
+	root := testTree(t, "2021/live/a.mp3", "2021/01.mp3", "2022/b.mp3", "2022/01.mp3", "2022/live/c.mp3", "other/x.mp3", "2023.mp3")
+	join := func(names ...string) []string {
+		var dirs []string
+		for _, name := range names {
+			dirs = append(dirs, filepath.Join(root, name))
+		}
+		return dirs
+	}
+	configs, err := ParseMediaConfig(strings.NewReader("[Archive]\nDirectory=" + filepath.Join(root, "20*") + "\nFileTypes=.mp3\n" +
+		"[Other]\nDirectory=" + filepath.Join(root, "other") + "\nFileTypes=.mp3\n" +
+		"[Nothing]\nDirectory=" + filepath.Join(root, "19*") + "\nFileTypes=.mp3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the glob is one category with the folders it matched, files aren't matched
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"Archive", join("2021", "2022")},
+		{"Other", nil},
+		{"Nothing", nil},
+	}
+	if len(configs) != len(tests) {
+		t.Fatalf("got %d categories, want %d: %+v", len(configs), len(tests), configs)
+	}
+	for i, tt := range tests {
+		if configs[i].Name != tt.name || !reflect.DeepEqual(configs[i].Directories, tt.want) {
+			t.Errorf("category %d = %s with %v, want %s with %v", i, configs[i].Name, configs[i].Directories, tt.name, tt.want)
+		}
+	}
+
+	// the folders are listed, browsed and served as one, with the first
+	// folder's 01.mp3 hiding the second's
+	h := testServer(t, "[Archive]\nDirectory="+filepath.Join(root, "20*")+"\nFileTypes=.mp3\n").routes()
+	want := []listed{{"Archive", "01.mp3"}, {"Archive", "live/a.mp3"}, {"Archive", "b.mp3"}, {"Archive", "live/c.mp3"}}
+	if got := filesOf(getMedia(t, h, nil).Groups); !reflect.DeepEqual(got, want) {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+	for target, body := range map[string]string{"/01.mp3": "2021/01.mp3", "/b.mp3": "2022/b.mp3", "/live/c.mp3": "2022/live/c.mp3"} {
+		if w := request(h, http.MethodGet, target); w.Code != http.StatusOK || w.Body.String() != body {
+			t.Errorf("GET %s = %d %q, want %q", target, w.Code, w.Body, body)
+		}
+	}
+	if w := request(h, http.MethodGet, "/x.mp3"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /x.mp3 = %d, the unmatched folder shouldn't serve", w.Code)
+	}
+	for target, names := range map[string][]string{"/browse/Archive/": {"live", "01.mp3", "b.mp3"}, "/browse/Archive/live/": {"a.mp3", "c.mp3"}} {
+		body := request(h, http.MethodGet, target).Body.String()
+		for _, name := range names {
+			if !strings.Contains(body, name) {
+				t.Errorf("GET %s doesn't show %s:\n%s", target, name, body)
+			}
+		}
+	}
+	w := request(h, http.MethodGet, "/download/Archive.zip")
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("GET /download/Archive.zip = %d, not a zip: %v", w.Code, err)
+	}
+	if len(zr.File) != len(want) {
+		t.Errorf("the zip has %d files, want %d", len(zr.File), len(want))
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	// with none there, the error names the original format
+	if got := configPath(""); got != "config.cfg" {
+		t.Errorf("with no config, configPath = %s, want config.cfg", got)
+	}
+
+	// each format is only used while the ones before it are missing
+	for _, name := range []string{"config.yml", "config.yaml", "config.toml", "config.cfg"} {
+		if err := os.WriteFile(name, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if got := configPath(""); got != name {
+			t.Errorf("after adding %s, configPath = %s", name, got)
+		}
+	}
+
+	// a named config is used even when it's missing and a default is there
+	if got := configPath("other.cfg"); got != "other.cfg" {
+		t.Errorf("configPath(other.cfg) = %s", got)
+	}
+	flags := configFlags{path: configPath("other.cfg"), named: true}
+	if _, err := flags.load([]string{"CHILL_DIRECTORIES=Music=/music"}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("loading a missing named config = %v, want it not found", err)
+	}
+}
+
+func TestDirectoryGlobMaxFiles(t *testing.T) {
+	captureLog(t)
+	root := testTree(t, "2021/a.mp3", "2021/b.mp3", "2022/a.mp3", "2022/b.mp3")
+	config := "[Archive]\nDirectory=" + filepath.Join(root, "20*") + "\nFileTypes=.mp3\nMaxFiles=2\n"
+
+	// the second folder only repeats the first, so nothing was left out
+	groups := getMedia(t, testServer(t, config).routes(), nil).Groups
+	if len(groups) != 1 || groups[0].Truncated || len(groups[0].Files) != 2 {
+		t.Fatalf("got %+v, want the two files, not truncated", groups)
+	}
+
+	// a file of its own past the limit is left out
+	testTreeAt(t, root, "2022/c.mp3")
+	groups = getMedia(t, testServer(t, config).routes(), nil).Groups
+	if len(groups) != 1 || !groups[0].Truncated || len(groups[0].Files) != 2 {
+		t.Errorf("got %+v, want two files, truncated", groups)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parsetoml reads the subset of toml that maps onto the config: one table per
+// section, with string, integer, boolean and string-array values.
+//
+//	[Server]
+//	Metrics = true
+//
+//	[Audiobooks]
+//	Directory = "/Users/dh/Audiobooks"
+//	FileTypes = [".mp3", ".flac"]
+func parseTOML(r io.Reader, b *configBuilder) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(stripTOMLComment(scanner.Text()))
+
+		// skip empty lines and comments
+		if line == "" {
+			continue
+		}
+
+		// arrays of tables have no equivalent in the config
+		if strings.HasPrefix(line, "[[") {
+			return fmt.Errorf("line %d: arrays of tables are not supported", lineNo)
+		}
+
+		// a table header starts a new section
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return fmt.Errorf("line %d: unterminated table header", lineNo)
+			}
+			name, err := parseTOMLKey(strings.TrimSpace(line[1 : len(line)-1]))
+			if err != nil {
+				return fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			b.section(name)
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key, err := parseTOMLKey(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		raw := strings.TrimSpace(parts[1])
+
+		// arrays may span several lines, so keep reading until the brackets close
+		for strings.HasPrefix(raw, "[") && !strings.HasSuffix(raw, "]") {
+			if !scanner.Scan() {
+				return fmt.Errorf("line %d: unterminated array", lineNo)
+			}
+			lineNo++
+			raw += " " + strings.TrimSpace(stripTOMLComment(scanner.Text()))
+		}
+
+		value, err := parseTOMLValue(raw)
+		if err != nil {
+			return fmt.Errorf("line %d: %s: %w", lineNo, key, err)
+		}
+		if err := b.set(key, value); err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// parsetomlkey returns a bare or quoted toml key.
+func parseTOMLKey(s string) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("empty key")
+	}
+	if s[0] == '"' || s[0] == '\'' {
+		return parseTOMLString(s)
+	}
+	if strings.ContainsAny(s, " \t.\"'") {
+		return "", fmt.Errorf("unsupported key %q", s)
+	}
+	return s, nil
+}
+
+// parsetomlvalue converts a toml value into a configvalue.
+func parseTOMLValue(s string) (configValue, error) {
+	if s == "" {
+		return configValue{}, fmt.Errorf("missing value")
+	}
+
+	switch {
+	case s[0] == '[':
+		items, err := parseTOMLArray(s)
+		if err != nil {
+			return configValue{}, err
+		}
+		return listValue(items), nil
+	case s[0] == '"' || s[0] == '\'':
+		str, err := parseTOMLString(s)
+		if err != nil {
+			return configValue{}, err
+		}
+		return scalarValue(str), nil
+	case s == "true" || s == "false":
+		return scalarValue(s), nil
+	}
+
+	// anything else must be an integer
+	if _, err := strconv.ParseInt(strings.ReplaceAll(s, "_", ""), 10, 64); err != nil {
+		return configValue{}, fmt.Errorf("unsupported value %q", s)
+	}
+	return scalarValue(strings.ReplaceAll(s, "_", "")), nil
+}
+
+// parsetomlarray splits a single-line toml array of strings into its items.
+func parseTOMLArray(s string) ([]string, error) {
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	items := []string{}
+
+	for inner != "" {
+		if inner[0] != '"' && inner[0] != '\'' {
+			return nil, fmt.Errorf("arrays may only contain strings")
+		}
+
+		// find the end of the quoted item
+		end := closingQuote(inner)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string in array")
+		}
+		item, err := parseTOMLString(inner[:end+1])
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		// move past the separator, allowing a trailing comma
+		inner = strings.TrimSpace(inner[end+1:])
+		if strings.HasPrefix(inner, ",") {
+			inner = strings.TrimSpace(inner[1:])
+		} else if inner != "" {
+			return nil, fmt.Errorf("expected a comma between array items")
+		}
+	}
+
+	return items, nil
+}
+
+// parsetomlstring unquotes a basic ("...") or literal ('...') toml string.
+func parseTOMLString(s string) (string, error) {
+	end := closingQuote(s)
+	if end < 0 {
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	if end != len(s)-1 {
+		return "", fmt.Errorf("unexpected %s after string", s[end+1:])
+	}
+
+	// literal strings have no escapes
+	if s[0] == '\'' {
+		return s[1:end], nil
+	}
+	return unescape(s[1:end], tomlEscapes, tomlHexEscapes)
+}
+
+// tomlescapes are what toml's escapes in basic strings stand for, and
+// tomlhexescapes the escapes followed by a unicode code point in hex, with
+// how many digits it takes.
+var (
+	tomlEscapes    = map[byte]string{'b': "\b", 't': "\t", 'n': "\n", 'f': "\f", 'r': "\r", '"': "\"", '\\': "\\"}
+	tomlHexEscapes = map[byte]int{'u': 4, 'U': 8}
+)
+
+// unescape replaces the backslash escapes in the inside of a quoted string,
+// by the rules of the format given by escapes and hexescapes. any other
+// escape is an error, rather than being read by some other language's rules.
+func unescape(s string, escapes map[byte]string, hexEscapes map[byte]int) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			return "", fmt.Errorf("unterminated escape in %q", s)
+		}
+		i++
+		if replacement, ok := escapes[s[i]]; ok {
+			b.WriteString(replacement)
+			continue
+		}
+		digits, ok := hexEscapes[s[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported escape \\%c in %q", s[i], s)
+		}
+		if i+digits >= len(s) {
+			return "", fmt.Errorf("short escape \\%s in %q", s[i:], s)
+		}
+		code, err := strconv.ParseUint(s[i+1:i+1+digits], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return "", fmt.Errorf("invalid escape \\%s in %q", s[i:i+1+digits], s)
+		}
+		b.WriteRune(rune(code))
+		i += digits
+	}
+	return b.String(), nil
+}
+
+// closingquote returns the index of the quote that closes the string starting at s[0].
+func closingQuote(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		if quote == '"' && s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+// striptomlcomment removes a trailing # comment that isn't inside a string.
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == 0 && c == '#':
+			return line[:i]
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case c == quote:
+			quote = 0
+		}
+	}
+	return line
+}
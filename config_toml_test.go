@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// loadAs writes config to a file named for the format and loads it.
+func loadAs(t *testing.T, name, config string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path)
+}
+
+// readmeConfig is the example the readme gives in every format, in the
+// original one.
+const readmeConfig = "[Server]\nMetrics=true\n[Audiobooks]\nDirectory=/Users/dh/Audiobooks\nFileTypes=.mp3,.m3u,.opus\n"
+
+func TestParseTOML(t *testing.T) {
+
+	// the readme's example is the same config as the .cfg
+	want, err := loadAs(t, "config.cfg", readmeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadAs(t, "config.toml", "[Server]\nMetrics = true\n\n[Audiobooks]\nDirectory = \"/Users/dh/Audiobooks\"\nFileTypes = [\".mp3\", \".m3u\", \".opus\"]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("the readme's toml = %+v, want %+v", got, want)
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		want   []category
+	}{
+		{"inline array", "[Music]\nDirectory = \"/music\"\nFileTypes = ['.mp3', \".flac\",]\n", []category{{"Music", "/music", []string{".mp3", ".flac"}}}},
+		{"multi-line array", "[Music]\nDirectory = \"/music\"\nFileTypes = [\n  \".mp3\", # lossy\n  \".flac\",\n\n]\n", []category{{"Music", "/music", []string{".mp3", ".flac"}}}},
+		{"comments after values", "[Music] # the good stuff\nDirectory = \"/music\" # on the nas\nFileTypes = [\".mp3\"] # audio\n", []category{{"Music", "/music", []string{".mp3"}}}},
+		{"comment characters in strings", "[\"C# Music\"]\nDirectory = \"/music/c#\"\nFileTypes = ['.mp3 # no']\n[Live]\nDirectory = '/live # set'\n", []category{{"C# Music", "/music/c#", []string{".mp3 # no"}}, {"Live", "/live # set", nil}}},
+		{"literal strings keep backslashes", "[Music]\nDirectory = 'C:\\Music\\new'\n", []category{{"Music", `C:\Music\new`, nil}}},
+		{"basic string escapes", "[Music]\nDirectory = \"C:\\\\Music\\\\caf\\u00e9 \\\"live\\\" \\U0001F3B5\"\n", []category{{"Music", `C:\Music\café "live" 🎵`, nil}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadAs(t, "config.toml", tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := categoriesOf(cfg.Categories); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"text after a string", "[Music]\nDirectory = \"/a\" \"/b\"\n"},
+		{"text after a literal string", "[Music]\nDirectory = '/a' '/b'\n"},
+		{"unterminated string", "[Music]\nDirectory = \"/music\n"},
+		{"go escape", "[Music]\nDirectory = \"\\x41\"\n"},
+		{"bell escape", "[Music]\nDirectory = \"\\a\"\n"},
+		{"unknown escape", "[Music]\nDirectory = \"\\q\"\n"},
+		{"short unicode escape", "[Music]\nDirectory = \"\\u12\"\n"},
+		{"surrogate escape", "[Music]\nDirectory = \"\\uD800\"\n"},
+		{"backquoted string", "[Music]\nDirectory = `/music`\n"},
+		{"bare string", "[Music]\nDirectory = /music\n"},
+		{"unterminated table", "[Music\nDirectory = \"/music\"\n"},
+		{"array of tables", "[[Music]]\nDirectory = \"/music\"\n"},
+		{"no equals sign", "[Server]\nMetrics\n"},
+		{"dotted key", "[Server]\nTLS.Cert = \"a\"\n"},
+		{"unterminated array", "[Music]\nFileTypes = [\".mp3\",\n"},
+		{"array without commas", "[Music]\nFileTypes = [\".mp3\" \".flac\"]\n"},
+		{"array of numbers", "[Music]\nFileTypes = [1, 2]\n"},
+		{"bad boolean", "[Server]\nMetrics = maybe\n"},
+		{"missing value", "[Server]\nMetrics =\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadAs(t, "config.toml", tt.config)
+			if err == nil {
+				t.Errorf("loaded %+v, want an error", categoriesOf(cfg.Categories))
+			} else if !strings.Contains(err.Error(), "config.toml") {
+				t.Errorf("the error doesn't name the file: %v", err)
+			}
+		})
+	}
+}
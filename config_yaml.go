@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// parseyaml reads the subset of yaml that maps onto the config: a top-level
+// mapping of section names to mappings of scalars, flow lists or block lists.
+//
+//	Server:
+//	  Metrics: true
+//
+//	Audiobooks:
+//	  Directory: /Users/dh/Audiobooks
+//	  FileTypes: [.mp3, .flac]
+func parseYAML(r io.Reader, b *configBuilder) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+
+	// a key with no inline value collects the block list items below it
+	pendingKey := ""
+	pendingLine := 0
+	var pendingItems []string
+	inSection := false
+
+	// flush applies the pending block list, if any
+	flush := func() error {
+		if pendingKey == "" {
+			return nil
+		}
+		key := pendingKey
+		pendingKey = ""
+		if pendingItems == nil {
+			return fmt.Errorf("line %d: %s has no value", pendingLine, key)
+		}
+		items := pendingItems
+		pendingItems = nil
+		if err := b.set(key, listValue(items)); err != nil {
+			return fmt.Errorf("line %d: %w", pendingLine, err)
+		}
+		return nil
+	}
+
+	for scanner.Scan() {
+		lineNo++
+		raw := stripYAMLComment(scanner.Text())
+
+		// skip empty lines, comments and document markers
+		trimmed := strings.TrimSpace(raw)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.Contains(raw, "\t") {
+			return fmt.Errorf("line %d: tabs are not allowed for indentation", lineNo)
+		}
+		indent := len(raw) - len(strings.TrimLeft(raw, " "))
+
+		// block list items belong to the pending key
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if pendingKey == "" {
+				return fmt.Errorf("line %d: list item without a key", lineNo)
+			}
+			item, err := parseYAMLScalar(strings.TrimSpace(strings.TrimPrefix(trimmed, "-")))
+			if err != nil {
+				return fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			pendingItems = append(pendingItems, item)
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return fmt.Errorf("line %d: expected key: value", lineNo)
+		}
+		key, err := parseYAMLScalar(strings.TrimSpace(key))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		value = strings.TrimSpace(value)
+
+		// unindented keys start a new section
+		if indent == 0 {
+			if value != "" {
+				return fmt.Errorf("line %d: top-level key %s must be a section", lineNo, key)
+			}
+			b.section(key)
+			inSection = true
+			continue
+		}
+		if !inSection {
+			return fmt.Errorf("line %d: indented key outside of a section", lineNo)
+		}
+
+		// a key without a value is followed by a block list
+		if value == "" {
+			pendingKey = key
+			pendingLine = lineNo
+			continue
+		}
+
+		// flow lists are written inline in brackets
+		if strings.HasPrefix(value, "[") {
+			if !strings.HasSuffix(value, "]") {
+				return fmt.Errorf("line %d: unterminated list", lineNo)
+			}
+			items := []string{}
+			for _, part := range splitYAMLFlow(value[1 : len(value)-1]) {
+				item, err := parseYAMLScalar(part)
+				if err != nil {
+					return fmt.Errorf("line %d: %w", lineNo, err)
+				}
+				items = append(items, item)
+			}
+			if err := b.set(key, listValue(items)); err != nil {
+				return fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			continue
+		}
+
+		scalar, err := parseYAMLScalar(value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if err := b.set(key, scalarValue(scalar)); err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// parseyamlscalar unquotes a plain, single-quoted or double-quoted yaml scalar.
+func parseYAMLScalar(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	switch s[0] {
+	case '"':
+		end := closingQuote(s)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		if end != len(s)-1 {
+			return "", fmt.Errorf("unexpected %s after string", s[end+1:])
+		}
+		return unescape(s[1:end], yamlEscapes, yamlHexEscapes)
+	case '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	return s, nil
+}
+
+// yamlescapes are what yaml's escapes in double-quoted scalars stand for, and
+// yamlhexescapes those followed by a code point in hex.
+var (
+	yamlEscapes = map[byte]string{
+		'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n", 'v': "\v", 'f': "\f", 'r': "\r",
+		'e': "\x1b", ' ': " ", '"': "\"", '/': "/", '\\': "\\", 'N': "\u0085", '_': "\u00a0", 'L': "\u2028", 'P': "\u2029",
+	}
+	yamlHexEscapes = map[byte]int{'x': 2, 'u': 4, 'U': 8}
+)
+
+// splityamlflow splits the inside of a flow list on commas outside of quotes.
+func splitYAMLFlow(s string) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == 0 && (c == '"' || c == '\'') && opensYAMLQuote(s, i):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case c == quote:
+			quote = 0
+		case quote == 0 && c == ',':
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		parts = append(parts, last)
+	}
+	return parts
+}
+
+// stripyamlcomment removes a # comment that starts a line or follows whitespace
+// outside of a quoted string.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == 0 && c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		case quote == 0 && (c == '"' || c == '\'') && opensYAMLQuote(line, i):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case c == quote:
+			quote = 0
+		}
+	}
+	return line
+}
+
+// opensyamlquote reports whether the quote at s[i] starts a quoted scalar rather
+// than being an apostrophe inside a plain one.
+func opensYAMLQuote(s string, i int) bool {
+	if i == 0 {
+		return true
+	}
+	switch s[i-1] {
+	case ' ', '[', ',', ':':
+		return true
+	}
+	return false
+}
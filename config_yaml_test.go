@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+
+	// the readme's example is the same config as the .cfg
+	want, err := loadAs(t, "config.cfg", readmeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readme := "Server:\n  Metrics: true\n\nAudiobooks:\n  Directory: /Users/dh/Audiobooks\n  FileTypes:\n    - .mp3\n    - .m3u\n    - .opus\n"
+	for _, name := range []string{"config.yaml", "config.yml"} {
+		got, err := loadAs(t, name, readme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("the readme's yaml as %s = %+v, want %+v", name, got, want)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		want   []category
+	}{
+		{"flow list", "---\nMusic:\n  Directory: /music\n  FileTypes: [.mp3, '.flac', \".ogg\"]\n", []category{{"Music", "/music", []string{".mp3", ".flac", ".ogg"}}}},
+		{"block list", "Music:\n  Directory: /music\n  FileTypes:\n  - .mp3\n  # lossless\n  - '.flac'\n", []category{{"Music", "/music", []string{".mp3", ".flac"}}}},
+		{"comments after values", "# my library\nMusic: # the good stuff\n  Directory: /music # on the nas\n  FileTypes: [.mp3] # audio\n", []category{{"Music", "/music", []string{".mp3"}}}},
+		{"comment characters in values", "C# Music:\n  Directory: /music/c#1\n  FileTypes: ['.mp3 # no']\nLive:\n  Directory: \"/live # set\"\n", []category{{"C# Music", "/music/c#1", []string{".mp3 # no"}}, {"Live", "/live # set", nil}}},
+		{"single quotes", "Music:\n  Directory: '/music/rock ''n'' roll'\n", []category{{"Music", "/music/rock 'n' roll", nil}}},
+		{"double-quoted escapes", "Music:\n  Directory: \"C:\\\\Music\\\\caf\\xe9 \\\"live\\\" \\u00e9\"\n", []category{{"Music", `C:\Music\café "live" é`, nil}}},
+		{"apostrophes in plain values", "Music:\n  Directory: /music/don't stop\n", []category{{"Music", "/music/don't stop", nil}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadAs(t, "config.yaml", tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := categoriesOf(cfg.Categories); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"tab indentation", "Music:\n\tDirectory: /music\n"},
+		{"top-level value", "Music: /music\n"},
+		{"key outside a section", "  Directory: /music\n"},
+		{"no colon", "Server:\n  Metrics true\n"},
+		{"list item without a key", "Music:\n  - .mp3\n"},
+		{"key without a value", "Music:\n  Directory:\n  FileTypes: [.mp3]\n"},
+		{"unterminated flow list", "Music:\n  FileTypes: [.mp3, .flac\n"},
+		{"unterminated string", "Music:\n  Directory: \"/music\n"},
+		{"text after a string", "Music:\n  Directory: \"/a\" b\n"},
+		{"unterminated single quotes", "Music:\n  Directory: '/music\n"},
+		{"unknown escape", "Music:\n  Directory: \"\\q\"\n"},
+		{"go octal escape", "Music:\n  Directory: \"\\101\"\n"},
+		{"bad boolean", "Server:\n  Metrics: maybe\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadAs(t, "config.yaml", tt.config)
+			if err == nil {
+				t.Errorf("loaded %+v, want an error", categoriesOf(cfg.Categories))
+			} else if !strings.Contains(err.Error(), "config.yaml") {
+				t.Errorf("the error doesn't name the file: %v", err)
+			}
+		})
+	}
+}
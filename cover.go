@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// defaultcovernames are the cover images looked for when CoverNames is unset,
+// in order of preference.
+var defaultCoverNames = []string{"cover.jpg", "folder.jpg", "poster.jpg", "cover.png", "folder.png", "poster.png"}
+
+// covernames returns the file names taken as a directory's cover image.
+func coverNames(server ServerConfig) []string {
+	if server.CoverNames == nil {
+		return defaultCoverNames
+	}
+	return server.CoverNames
+}
+
+// findcover returns the slash-separated path of the cover image in sub, a
+// directory inside the category, or an empty string when it has none.
+func findCover(config CategoryConfig, server ServerConfig, sub string) string {
+	names := coverNames(server)
+	if len(names) == 0 {
+		return ""
+	}
+	config = config.partWith(sub)
+	dir, ok := resolveInCategory(config.Directory, sub)
+	if !ok {
+		return ""
+	}
+	entries, err := readDir(config, dir, sub)
+	if err != nil {
+		return ""
+	}
+
+	// the names are matched regardless of case, since Cover.JPG is just as common
+	for _, name := range names {
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && strings.EqualFold(entry.Name(), name) {
+				return path.Join(sub, entry.Name())
+			}
+		}
+	}
+	return ""
+}
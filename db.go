@@ -0,0 +1,520 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// librarydbversion changes whenever the walk tables change shape, and a
+// database of another version has them made afresh rather than misread. it's
+// kept in sqlite's user_version.
+const libraryDBVersion = 1
+
+// dbsaveinterval is how often the changes are written to the database.
+const dbSaveInterval = 30 * time.Second
+
+// libraryschema makes the tables of an empty database. settings holds the
+// Tags and Durations the walks were made with.
+const librarySchema = `
+CREATE TABLE IF NOT EXISTS settings (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS walks (
+	category    TEXT PRIMARY KEY,
+	directory   TEXT NOT NULL,
+	directories TEXT NOT NULL,
+	title       TEXT NOT NULL,
+	truncated   INTEGER NOT NULL,
+	cover       TEXT NOT NULL,
+	description TEXT NOT NULL,
+	offline     INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS files (
+	category     TEXT NOT NULL REFERENCES walks (category) ON DELETE CASCADE,
+	position     INTEGER NOT NULL,
+	id           TEXT NOT NULL,
+	name         TEXT NOT NULL,
+	path         TEXT NOT NULL,
+	size         INTEGER NOT NULL,
+	mod_time     INTEGER NOT NULL,
+	duration     INTEGER NOT NULL,
+	title        TEXT NOT NULL,
+	artist       TEXT NOT NULL,
+	album_artist TEXT NOT NULL,
+	album        TEXT NOT NULL,
+	track        INTEGER NOT NULL,
+	subtitles    TEXT NOT NULL,
+	PRIMARY KEY (category, position)
+);
+CREATE TABLE IF NOT EXISTS plays (
+	path        TEXT PRIMARY KEY,
+	count       INTEGER NOT NULL,
+	last_played INTEGER NOT NULL
+);
+`
+
+// librarydb keeps the last walk of every category, with what its files' tags
+// and lengths were, and how often each file was played, in the sqlite
+// database given by -db or Database. on startup the walks fill the library
+// index and the tag and duration caches, so the listing is there at once and
+// only files that changed are read again. it's all held in memory as well,
+// and what changed is written every dbsaveinterval.
+type libraryDB struct {
+	db *sql.DB
+
+	mu   sync.Mutex
+	data libraryData
+
+	// what changed since the last flush: every walk when reset, the walks
+	// of changedwalks, and the plays of changedplays
+	reset        bool
+	changedWalks map[string]bool
+	changedPlays map[string]bool
+}
+
+// librarydata is what the database holds.
+type libraryData struct {
+	// the walks were made with these settings, and are only used with the same
+	Tags      bool
+	Durations bool
+
+	// walks is the last walk of each category, by name
+	Walks map[string]storedWalk
+
+	// plays counts how often each file was played, by its path
+	Plays map[string]playRecord
+}
+
+// storedwalk is a category's walk, with the directory it was walked from so a
+// category pointed somewhere else isn't filled with the old files.
+type storedWalk struct {
+	Directory   string
+	Directories []string
+	Group       MediaGroup
+}
+
+// walked reports whether the walk was of the category's directories, those a
+// glob matched as well as the Directory itself.
+func (walk storedWalk) walked(config CategoryConfig) bool {
+	return walk.Directory == config.Directory && reflect.DeepEqual(walk.Directories, config.Directories)
+}
+
+// playrecord is how often a file was played and when it was last.
+type playRecord struct {
+	Count int
+	Last  time.Time
+}
+
+// database is the library database, nil without -db or Database.
+var database *libraryDB
+
+// openlibrarydb opens the database at path, creating it when it doesn't exist
+// yet, and reads it in. walks made with other Tags or Durations settings, or
+// by another version, are dropped, but play counts are kept.
+func openLibraryDB(path string, server ServerConfig) (*libraryDB, error) {
+	if _, err := os.Stat(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	sqlDB, err := sql.Open("sqlite", "file:"+filepath.ToSlash(path)+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// every write is a transaction of its own, so one connection does
+	sqlDB.SetMaxOpenConns(1)
+	db := &libraryDB{db: sqlDB, changedWalks: make(map[string]bool), changedPlays: make(map[string]bool)}
+	if err := db.load(); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	db.configure(server)
+	return db, nil
+}
+
+// load makes the tables when they're missing or of another version, and reads
+// what they hold.
+func (db *libraryDB) load() error {
+	var version int
+	if err := db.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version != libraryDBVersion {
+		if _, err := db.db.Exec("DROP TABLE IF EXISTS files; DROP TABLE IF EXISTS walks; DROP TABLE IF EXISTS settings"); err != nil {
+			return err
+		}
+	}
+	if _, err := db.db.Exec(librarySchema); err != nil {
+		return err
+	}
+	if _, err := db.db.Exec(fmt.Sprintf("PRAGMA user_version = %d", libraryDBVersion)); err != nil {
+		return err
+	}
+
+	db.data.Plays = make(map[string]playRecord)
+	rows, err := db.db.Query("SELECT path, count, last_played FROM plays")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path string
+		var record playRecord
+		var last int64
+		if err := rows.Scan(&path, &record.Count, &last); err != nil {
+			return err
+		}
+		record.Last = time.Unix(0, last).UTC()
+		db.data.Plays[path] = record
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// without the settings the walks were made with, they can't be trusted
+	settings := make(map[string]string)
+	rows, err = db.db.Query("SELECT key, value FROM settings")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		settings[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if settings["tags"] == "" || settings["durations"] == "" {
+		return nil
+	}
+	db.data.Tags, db.data.Durations = settings["tags"] == "true", settings["durations"] == "true"
+	db.data.Walks, err = db.loadWalks()
+	return err
+}
+
+// loadwalks reads every stored walk with its files, in the order they were
+// listed.
+func (db *libraryDB) loadWalks() (map[string]storedWalk, error) {
+	walks := make(map[string]storedWalk)
+	rows, err := db.db.Query("SELECT category, directory, directories, title, truncated, cover, description, offline FROM walks")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var walk storedWalk
+		var directories string
+		group := &walk.Group
+		if err := rows.Scan(&group.Name, &walk.Directory, &directories, &group.Title, &group.Truncated, &group.Cover, &group.Description, &group.Offline); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(directories), &walk.Directories); err != nil {
+			return nil, err
+		}
+		group.Directory = walk.Directory
+		walks[group.Name] = walk
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.db.Query(`SELECT category, id, name, path, size, mod_time, duration, title, artist, album_artist, album, track, subtitles
+		FROM files ORDER BY category, position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category, subtitles string
+		var modTime, duration int64
+		var f MediaFile
+		if err := rows.Scan(&category, &f.ID, &f.Name, &f.Path, &f.Size, &modTime, &duration, &f.Title, &f.Artist, &f.AlbumArtist, &f.Album, &f.Track, &subtitles); err != nil {
+			return nil, err
+		}
+		f.ModTime, f.Duration = time.Unix(0, modTime), time.Duration(duration)
+		if err := json.Unmarshal([]byte(subtitles), &f.Subtitles); err != nil {
+			return nil, err
+		}
+		walk := walks[category]
+		walk.Group.Files = append(walk.Group.Files, f)
+		walks[category] = walk
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for name, walk := range walks {
+		walk.Group.tally()
+		walks[name] = walk
+	}
+	return walks, nil
+}
+
+// configure drops the stored walks when they were made with other Tags or
+// Durations settings than server's, as when the config is reloaded with them
+// changed.
+func (db *libraryDB) configure(server ServerConfig) {
+	if db == nil {
+		return
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.data.Walks != nil && db.data.Tags == server.Tags && db.data.Durations == server.Durations {
+		return
+	}
+	db.reset = true
+	db.changedWalks = make(map[string]bool)
+	db.data.Tags, db.data.Durations = server.Tags, server.Durations
+	db.data.Walks = make(map[string]storedWalk)
+}
+
+// walks returns the stored walks of the categories that still point at the
+// same directory. collections aren't stored, they're put together from their
+// sources.
+func (db *libraryDB) walks(configs []CategoryConfig) map[string]MediaGroup {
+	groups := make(map[string]MediaGroup)
+	if db == nil {
+		return groups
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, config := range configs {
+		if walk, ok := db.data.Walks[config.Name]; ok && !isCollection(config) && walk.walked(config) {
+			groups[config.Name] = walk.Group
+		}
+	}
+	return groups
+}
+
+// seed fills the tag and duration caches from the stored walks, so the first
+// walk after a restart only reads the files that changed since.
+func (db *libraryDB) seed(configs []CategoryConfig, server ServerConfig) {
+	if !server.Tags && !server.Durations {
+		return
+	}
+	for _, config := range configs {
+		if isArchive(config.Directory) {
+			continue
+		}
+		group, ok := db.walks([]CategoryConfig{config})[config.Name]
+		if !ok {
+			continue
+		}
+		for _, file := range group.Files {
+			path := filepath.Join(config.partWith(file.Path).Directory, filepath.FromSlash(file.Path))
+			if server.Durations {
+				durations.put(path, file.Size, file.ModTime, file.Duration)
+			}
+			if server.Tags {
+				tags.put(path, file.Size, file.ModTime, audioTags{
+					Title:       file.Title,
+					Artist:      file.Artist,
+					AlbumArtist: file.AlbumArtist,
+					Album:       file.Album,
+					Track:       file.Track,
+				})
+			}
+		}
+	}
+}
+
+// record keeps a fresh walk of a category for the next startup.
+func (db *libraryDB) record(config CategoryConfig, group MediaGroup) {
+	if db == nil || isCollection(config) {
+		return
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	old, ok := db.data.Walks[config.Name]
+	if ok && old.walked(config) && sameFiles(old.Group, group) {
+		return
+	}
+	db.data.Walks[config.Name] = storedWalk{Directory: config.Directory, Directories: config.Directories, Group: group}
+	db.changedWalks[config.Name] = true
+}
+
+// played counts a play of the file at the url path.
+func (db *libraryDB) played(urlPath string) {
+	if db == nil {
+		return
+	}
+	key := strings.TrimPrefix(urlPath, "/")
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	record := db.data.Plays[key]
+	record.Count++
+	record.Last = time.Now().UTC()
+	db.data.Plays[key] = record
+	db.changedPlays[key] = true
+}
+
+// plays returns how often the file at path was played.
+func (db *libraryDB) plays(path string) playRecord {
+	if db == nil {
+		return playRecord{}
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.data.Plays[path]
+}
+
+// run writes the changes to the database every dbsaveinterval, until ctx is
+// done.
+func (db *libraryDB) run(ctx context.Context) {
+	ticker := time.NewTicker(dbSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := db.flush(); err != nil {
+				log.Println("Error saving the library database:", err)
+			}
+		}
+	}
+}
+
+// flush writes what changed to the database now, in one transaction.
+func (db *libraryDB) flush() error {
+	if db == nil {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.reset && len(db.changedWalks) == 0 && len(db.changedPlays) == 0 {
+		return nil
+	}
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if db.reset {
+		if _, err := tx.Exec("DELETE FROM walks"); err != nil {
+			return err
+		}
+		for key, value := range map[string]bool{"tags": db.data.Tags, "durations": db.data.Durations} {
+			if _, err := tx.Exec("INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)", key, fmt.Sprint(value)); err != nil {
+				return err
+			}
+		}
+	}
+	for name := range db.changedWalks {
+		if err := writeWalk(tx, name, db.data.Walks[name]); err != nil {
+			return err
+		}
+	}
+	for path := range db.changedPlays {
+		record := db.data.Plays[path]
+		if _, err := tx.Exec("INSERT OR REPLACE INTO plays (path, count, last_played) VALUES (?, ?, ?)", path, record.Count, record.Last.UnixNano()); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.reset = false
+	db.changedWalks = make(map[string]bool)
+	db.changedPlays = make(map[string]bool)
+	return nil
+}
+
+// writewalk replaces the stored walk of the named category, its files going
+// with the walk they were in.
+func writeWalk(tx *sql.Tx, name string, walk storedWalk) error {
+	if _, err := tx.Exec("DELETE FROM walks WHERE category = ?", name); err != nil {
+		return err
+	}
+	directories, err := json.Marshal(walk.Directories)
+	if err != nil {
+		return err
+	}
+	group := walk.Group
+	if _, err := tx.Exec("INSERT INTO walks (category, directory, directories, title, truncated, cover, description, offline) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		name, walk.Directory, string(directories), group.Title, group.Truncated, group.Cover, group.Description, group.Offline); err != nil {
+		return err
+	}
+	insert, err := tx.Prepare(`INSERT INTO files (category, position, id, name, path, size, mod_time, duration, title, artist, album_artist, album, track, subtitles)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for i, f := range group.Files {
+		subtitles, err := json.Marshal(f.Subtitles)
+		if err != nil {
+			return err
+		}
+		if _, err := insert.Exec(name, i, f.ID, f.Name, f.Path, f.Size, f.ModTime.UnixNano(), int64(f.Duration), f.Title, f.Artist, f.AlbumArtist, f.Album, f.Track, string(subtitles)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close flushes the database and closes it.
+func (db *libraryDB) close() error {
+	if db == nil {
+		return nil
+	}
+	err := db.flush()
+	if closeErr := db.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// isplay reports whether a request for a file is someone starting to play or
+// download it, rather than a player seeking or checking its headers.
+func isPlay(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	rng := r.Header.Get("Range")
+	return rng == "" || strings.HasPrefix(rng, "bytes=0-")
+}
+
+// writefileatomic writes data to a temporary file beside path and renames it
+// over path, so a crash mid-write never leaves half a file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// a temporary file is only readable by its owner, unlike the file it replaces
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
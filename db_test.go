@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLibraryDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.db")
+	server := ServerConfig{Tags: true, Durations: true}
+	music := CategoryConfig{Name: "Music", Directory: "/music"}
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	group := MediaGroup{Name: "Music", Directory: "/music", Cover: "cover.jpg", Files: []MediaFile{
+		{Name: "b.mp3", Path: "b.mp3", Size: 3, ModTime: modTime, Duration: 90 * time.Second, Title: "Bee", Artist: "Band", Album: "First", Track: 2},
+		{Name: "a.mkv", Path: "films/a.mkv", Size: 5, ModTime: modTime, Subtitles: []Subtitle{{Path: "films/a.en.srt", Language: "en"}}},
+	}}
+	group.tally()
+
+	db, err := openLibraryDB(path, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.record(music, group)
+	db.played("/b.mp3")
+	db.played("/b.mp3")
+	if err := db.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the walk comes back in the order it was listed, and the plays with it
+	db, err = openLibraryDB(path, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := db.walks([]CategoryConfig{music})["Music"]
+	if !ok {
+		t.Fatal("the walk wasn't kept")
+	}
+	for i := range got.Files {
+		if !got.Files[i].ModTime.Equal(modTime) {
+			t.Errorf("%s was modified %v, want %v", got.Files[i].Path, got.Files[i].ModTime, modTime)
+		}
+		got.Files[i].ModTime = modTime
+	}
+	if !reflect.DeepEqual(got, group) {
+		t.Errorf("reopened walk = %+v, want %+v", got, group)
+	}
+	if plays := db.plays("b.mp3"); plays.Count != 2 || time.Since(plays.Last) > time.Minute {
+		t.Errorf("plays of b.mp3 = %+v, want 2 just now", plays)
+	}
+
+	// a category pointed at another folder doesn't get the old files
+	if _, ok := db.walks([]CategoryConfig{{Name: "Music", Directory: "/elsewhere"}})["Music"]; ok {
+		t.Error("a category moved to /elsewhere got the walk of /music")
+	}
+	db.close()
+
+	// walks made with other settings are dropped, the plays stay
+	db, err = openLibraryDB(path, ServerConfig{Tags: false, Durations: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if walks := db.walks([]CategoryConfig{music}); len(walks) != 0 {
+		t.Errorf("walks made with Tags on were kept with it off: %v", walks)
+	}
+	if plays := db.plays("b.mp3"); plays.Count != 2 {
+		t.Errorf("plays of b.mp3 = %d after the walks were dropped, want 2", plays.Count)
+	}
+	db.close()
+	db, err = openLibraryDB(path, ServerConfig{Tags: false, Durations: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if walks := db.walks([]CategoryConfig{music}); len(walks) != 0 {
+		t.Errorf("the dropped walks came back: %v", walks)
+	}
+	db.close()
+
+	// it's a plain sqlite database, which other tools can read
+	sqlDB, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	var count int
+	if err := sqlDB.QueryRow("SELECT count FROM plays WHERE path = ?", "b.mp3").Scan(&count); err != nil || count != 2 {
+		t.Errorf("SELECT count FROM plays = %d, %v, want 2", count, err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"path"
+	"strings"
+)
+
+// descriptionnames are the files whose text is shown under a directory's
+// heading, in order of preference.
+var descriptionNames = []string{"description.txt", ".chillinfo"}
+
+// maxdescriptionsize caps how much of a description is read, it's a note
+// for the heading rather than a document.
+const maxDescriptionSize = 16 << 10
+
+// finddescription returns the text of the description file in sub, a
+// directory inside the category, or an empty string when it has none. the
+// text is shown as it is, never as html.
+func findDescription(config CategoryConfig, sub string) string {
+	config = config.partWith(sub)
+	dir, ok := resolveInCategory(config.Directory, sub)
+	if !ok {
+		return ""
+	}
+	entries, err := readDir(config, dir, sub)
+	if err != nil {
+		return ""
+	}
+
+	// like covers, the names are matched regardless of case
+	for _, name := range descriptionNames {
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || !strings.EqualFold(entry.Name(), name) {
+				continue
+			}
+			f, err := openCategoryFile(config, path.Join(sub, entry.Name()))
+			if err != nil {
+				return ""
+			}
+			text, err := io.ReadAll(io.LimitReader(f, maxDescriptionSize))
+			f.Close()
+			if err != nil {
+				return ""
+			}
+			return strings.TrimSpace(strings.ToValidUTF8(string(text), "\uFFFD"))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// deviceid is not available on this platform, so StayOnFilesystem has no effect.
+func deviceID(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// dirindex is a single directory level in the shape third-party players expect.
+type dirIndex struct {
+	Path  string          `json:"path"`
+	Dirs  []string        `json:"dirs"`
+	Files []dirIndexEntry `json:"files"`
+}
+
+// dirindexentry is a media file in a directory index.
+type dirIndexEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+	URL     string    `json:"url"`
+}
+
+// handledirindex returns the immediate contents of a directory inside a
+// category as json, at /index/{category}/{subpath}.
+func (s *server) handleDirIndex(w http.ResponseWriter, r *http.Request) {
+	name, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/index/"), "/")
+	config, ok := s.category(name)
+	if !ok {
+		writeJSONError(w, "no such category", http.StatusNotFound)
+		return
+	}
+	if !s.authorize(w, r, config) {
+		return
+	}
+	sub = strings.Trim(sub, "/")
+
+	dirs, files, ok := s.readCategoryDir(config, sub)
+	if !ok {
+		writeJSONError(w, "no such directory", http.StatusNotFound)
+		return
+	}
+
+	index := dirIndex{Path: "/" + sub, Dirs: []string{}, Files: []dirIndexEntry{}}
+	for _, dir := range dirs {
+		index.Dirs = append(index.Dirs, dir.Name)
+	}
+	for _, file := range files {
+
+		// the url is escaped so names with spaces or # work as links
+		link := url.URL{Path: s.cfg.Server.BasePath + "/" + file.Path}
+		index.Files = append(index.Files, dirIndexEntry{
+			Name:    file.Name,
+			Size:    file.Size,
+			ModTime: file.ModTime,
+			URL:     link.String(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, index)
+}
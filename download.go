@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// handledownload streams every file in a category as a zip archive at
+// /download/{category}.zip without buffering the archive in memory.
+func (s *server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/download/"), ".zip")
+	if !ok {
+		s.notFound(w, r)
+		return
+	}
+
+	// find the category by its section name. a collection's files live in
+	// other categories, so it has no zip of its own
+	config, ok := s.category(name)
+	if !ok || isCollection(config) {
+		s.notFound(w, r)
+		return
+	}
+	if !s.authorize(w, r, config) {
+		return
+	}
+
+	ctx, cancel := walkContext(r.Context(), s.cfg.Server)
+	group, err := walkCategory(ctx, config, s.cfg.Server)
+	cancel()
+	if err != nil {
+		message, status := libraryError(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	// refuse categories over the configured limit before anything is written
+	if limit := s.cfg.Server.MaxZipSize; limit > 0 {
+		var total int64
+		for _, file := range group.Files {
+			total += file.Size
+		}
+		if total > limit {
+			http.Error(w, fmt.Sprintf("%s is %d bytes, over the %d byte download limit", name, total, limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	release, ok := s.startStream(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+	w, done := s.track(w, r)
+	defer done()
+
+	withoutDeadline(w)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
+
+	// the archive's size isn't known until it's written, so don't build one for nothing
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	zw := zip.NewWriter(w)
+	for _, file := range group.Files {
+		if err := addZipFile(zw, config, file); err != nil {
+
+			// headers are already sent, so all that can be done is stop and log
+			log.Println("Error writing zip:", err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Println("Error writing zip:", err)
+	}
+}
+
+// addzipfile copies one media file into the archive under its relative path.
+func addZipFile(zw *zip.Writer, config CategoryConfig, file MediaFile) error {
+	f, err := openCategoryFile(config, file.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// media is already compressed, so store it as-is rather than deflating
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     filepath.ToSlash(file.Path),
+		Method:   zip.Store,
+		Modified: file.ModTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, f)
+	return err
+}
+
+// category returns the category with the given section name.
+func (s *server) category(name string) (CategoryConfig, bool) {
+	for _, config := range s.cfg.Categories {
+		if config.Name == name && config.visible() {
+			return config, true
+		}
+	}
+	return CategoryConfig{}, false
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// dryrun walks every category once and prints what would be served, returning
+// an error if any category can't be served as configured.
+func dryRun(cfg *Config, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CATEGORY\tDIRECTORY\tFILETYPES\tFILES")
+
+	var problems []string
+	for _, config := range cfg.Categories {
+		fileTypes := strings.Join(config.FileTypes, ",")
+
+		// a collection has no directory, so show where its files come from, and
+		// a glob shows the directories it matched
+		dir := config.Directory
+		if isCollection(config) {
+			dir = "from " + strings.Join(config.From, ",")
+		} else if len(config.Directories) > 0 {
+			dir = strings.Join(config.Directories, ",")
+		}
+
+		// a missing directory would only be logged by the walk, so check it first
+		if err := checkCategoryDirectory(config); err != nil {
+			problems = append(problems, err.Error())
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", config.Name, dir, fileTypes, "-")
+			continue
+		}
+
+		ctx, cancel := walkContext(context.Background(), cfg.Server)
+		group, err := walkCategory(ctx, config, cfg.Server)
+		cancel()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("category %s: %v", config.Name, err))
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", config.Name, dir, fileTypes, "-")
+			continue
+		}
+		count := fmt.Sprint(len(group.Files))
+		if group.Truncated {
+			count += " (truncated by MaxFiles)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", config.Name, dir, fileTypes, count)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// checkcategorydirectory reports a category whose directory is unset, missing or
+// not a directory. collections have none, their sources are checked on their own.
+func checkCategoryDirectory(config CategoryConfig) error {
+	if isCollection(config) {
+		return nil
+	}
+	if config.Directory == "" {
+		return fmt.Errorf("category %s: no Directory set", config.Name)
+	}
+	if isArchive(config.Directory) {
+		if _, err := openArchive(config.Directory); err != nil {
+			return fmt.Errorf("category %s: %v", config.Name, err)
+		}
+		return nil
+	}
+	for _, dir := range config.roots() {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("category %s: %v", config.Name, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("category %s: %s is not a directory", config.Name, dir)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// duplicatefile is a file in a duplicates report, with the category it's in.
+type duplicateFile struct {
+	Category string
+	MediaFile
+}
+
+// duplicategroup is a set of files that look like copies of each other.
+type duplicateGroup struct {
+	Size  int64
+	Hash  string
+	Files []duplicateFile
+}
+
+// wasted is the space the extra copies take up.
+func (g duplicateGroup) Wasted() int64 {
+	return g.Size * int64(len(g.Files)-1)
+}
+
+// duplicatesdata is what the duplicates template renders.
+type duplicatesData struct {
+	SiteTitle string
+	BasePath  string
+	Offline   bool
+	Theme     string
+	Hashed    bool
+	Groups    []duplicateGroup
+	Wasted    int64
+}
+
+// handleduplicates lists files of the same size across every category the
+// request may see, largest waste first. with ?hash=1 only files whose sha-256
+// also matches are listed, which reads every candidate in full.
+func (s *server) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	groups, err := buildMediaList(r.Context(), s.categories(r), s.cfg.Server)
+	if err != nil {
+		message, status := libraryError(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	dupes := sameSize(groups)
+	hashed := r.URL.Query().Get("hash") == "1"
+	if hashed {
+		withoutDeadline(w)
+		if dupes, err = s.sameHash(r.Context(), dupes); err != nil {
+			message, status := libraryError(err)
+			http.Error(w, message, status)
+			return
+		}
+	}
+	sort.SliceStable(dupes, func(i, j int) bool { return dupes[i].Wasted() > dupes[j].Wasted() })
+
+	data := duplicatesData{
+		SiteTitle: s.cfg.Server.SiteTitle,
+		BasePath:  s.cfg.Server.BasePath,
+		Offline:   s.cfg.Server.Offline,
+		Theme:     s.theme(w, r),
+		Hashed:    hashed,
+		Groups:    dupes,
+	}
+	for _, group := range dupes {
+		data.Wasted += group.Wasted()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.duplicatesTmpl.Execute(w, data); err != nil {
+		log.Println("Error executing template:", err)
+	}
+}
+
+// samesize groups the files of every category by size, keeping the sizes
+// shared by more than one file. empty files all match, so they're left out.
+func sameSize(groups []MediaGroup) []duplicateGroup {
+	bySize := make(map[int64][]duplicateFile)
+	var sizes []int64
+	for _, group := range groups {
+		for _, file := range group.Files {
+			if file.Size == 0 {
+				continue
+			}
+			if _, ok := bySize[file.Size]; !ok {
+				sizes = append(sizes, file.Size)
+			}
+			bySize[file.Size] = append(bySize[file.Size], duplicateFile{Category: group.Name, MediaFile: file})
+		}
+	}
+
+	var dupes []duplicateGroup
+	for _, size := range sizes {
+		if files := bySize[size]; len(files) > 1 {
+			dupes = append(dupes, duplicateGroup{Size: size, Files: files})
+		}
+	}
+	return dupes
+}
+
+// samehash splits each group of same-size files by content, keeping the
+// hashes shared by more than one file. a file that can't be read is skipped.
+func (s *server) sameHash(ctx context.Context, dupes []duplicateGroup) ([]duplicateGroup, error) {
+	var hashed []duplicateGroup
+	for _, group := range dupes {
+		byHash := make(map[string][]duplicateFile)
+		var hashes []string
+		for _, file := range group.Files {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			sum, err := s.hashFile(file)
+			if err != nil {
+				log.Println("Error hashing file:", err)
+				continue
+			}
+			if _, ok := byHash[sum]; !ok {
+				hashes = append(hashes, sum)
+			}
+			byHash[sum] = append(byHash[sum], file)
+		}
+		for _, sum := range hashes {
+			if files := byHash[sum]; len(files) > 1 {
+				hashed = append(hashed, duplicateGroup{Size: group.Size, Hash: sum, Files: files})
+			}
+		}
+	}
+	return hashed, nil
+}
+
+// hashfile returns the hex sha-256 of a file's contents, cached like the
+// checksums lists.
+func (s *server) hashFile(file duplicateFile) (string, error) {
+	config, ok := s.category(file.Category)
+	if !ok {
+		return "", fmt.Errorf("no category %s", file.Category)
+	}
+	return fileChecksum(config, file.Path)
+}
+
+// formatsize shows a byte count in the largest unit that keeps it above one.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// html template for the duplicates report
+const duplicatesTemplate = `
+<!DOCTYPE html>
+<html lang="en"{{if ne .Theme "auto"}} data-bs-theme="{{.Theme}}"{{end}}>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    {{if .Offline}}<link href="{{.BasePath}}/assets/bootstrap.min.css" rel="stylesheet">{{else}}<link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0-alpha3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-KK94CHFLLe+nY2dmCWGMq91rCGa5gtU4mk92HdvYe+M/SXH301p5ILy+dN9+nJOZ" crossorigin="anonymous">{{end}}
+		<title>Duplicates - {{.SiteTitle}}</title>
+    {{template "theme" .}}
+</head>
+<body>
+<div class="container-fluid">
+    <div class="row">
+        <div class="col">
+            <h1><a href="{{.BasePath}}/">{{.SiteTitle}}</a></h1>
+            {{template "theme-switch"}}
+            {{if .Hashed}}
+            <p>files with the same contents: {{len .Groups}} sets, {{size .Wasted}} in extra copies. <a href="{{.BasePath}}/duplicates">compare by size only</a></p>
+            {{else}}
+            <p>files with the same size: {{len .Groups}} sets, up to {{size .Wasted}} in extra copies. same size doesn't always mean same contents: <a href="{{.BasePath}}/duplicates?hash=1">compare contents</a>, which reads every file listed here.</p>
+            {{end}}
+        </div>
+    </div>
+    <div class="row">
+        <div class="col">
+            <ul>
+                {{range .Groups}}
+                <li>
+                    <strong>{{size .Size}}</strong> <span class="badge bg-secondary">{{len .Files}}</span>
+                    {{if .Hash}}<small class="text-muted"><code>{{.Hash}}</code></small>{{end}}
+                    <ul>
+                        {{range .Files}}
+                        <li><a href="{{$.BasePath}}/{{.Path}}" title="{{.Path}}" target="_blank">{{.Path}}</a> <small class="text-muted">{{.Category}}</small></li>
+                        {{end}}
+                    </ul>
+                </li>
+                {{end}}
+            </ul>
+        </div>
+    </div>
+</div>
+</body>
+</html>
+`
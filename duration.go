@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// durations caches probed durations by path, size and modification time.
+var durations = newFileCache[time.Duration]("durations")
+
+// errnoduration is returned when a file's container has no usable duration.
+var errNoDuration = errors.New("no duration found")
+
+// probeduration returns the playing time of the media file at path, or zero if
+// the format isn't recognized or the file can't be parsed.
+func probeDuration(path string) time.Duration {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	var d time.Duration
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		d, err = mp3Duration(f)
+	case ".mp4", ".m4a", ".m4b", ".m4v", ".mov":
+		d, err = mp4Duration(f)
+	case ".mkv", ".mka", ".webm":
+		d, err = mkvDuration(f)
+	case ".flac":
+		d, err = flacDuration(f)
+	case ".ogg", ".oga", ".opus":
+		d, err = oggDuration(f)
+	case ".wav":
+		d, err = wavDuration(f)
+	default:
+		return 0
+	}
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// formatduration renders a duration as m:ss, or h:mm:ss for an hour or more.
+func formatDuration(d time.Duration) string {
+	total := int(d.Round(time.Second) / time.Second)
+	h, m, s := total/3600, total/60%60, total%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// mp3 tables indexed by mpeg version (0 = 2.5, 2 = 2, 3 = 1)
+var (
+	mp3SampleRates = map[byte][3]int{
+		0: {11025, 12000, 8000},
+		2: {22050, 24000, 16000},
+		3: {44100, 48000, 32000},
+	}
+	mp3BitratesV1 = map[byte][16]int{
+		3: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		2: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		1: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	}
+	mp3BitratesV2 = map[byte][16]int{
+		3: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		2: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		1: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	}
+)
+
+// mp3duration reads the xing or vbri header of the first frame for vbr files and
+// falls back to estimating from the bitrate for cbr files.
+func mp3Duration(f *os.File) (time.Duration, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// skip an id3v2 tag at the start of the file
+	var audioStart int64
+	head := make([]byte, 10)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return 0, err
+	}
+	if string(head[:3]) == "ID3" {
+		audioStart = 10 + int64(synchsafe(head[6:10]))
+		if head[5]&0x10 != 0 {
+			audioStart += 10
+		}
+	}
+
+	// search for the first frame sync near the start of the audio
+	buf := make([]byte, 64*1024)
+	n, err := f.ReadAt(buf, audioStart)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xff || buf[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		version := (buf[i+1] >> 3) & 3
+		layer := (buf[i+1] >> 1) & 3
+		bitrateIndex := buf[i+2] >> 4
+		rateIndex := (buf[i+2] >> 2) & 3
+		if version == 1 || layer == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue
+		}
+
+		sampleRate := mp3SampleRates[version][rateIndex]
+		bitrate := mp3BitratesV2[layer][bitrateIndex]
+		if version == 3 {
+			bitrate = mp3BitratesV1[layer][bitrateIndex]
+		}
+
+		// samples per frame depend on the layer and version
+		samplesPerFrame := 1152
+		switch {
+		case layer == 3:
+			samplesPerFrame = 384
+		case layer == 1 && version != 3:
+			samplesPerFrame = 576
+		}
+
+		// the xing/info header sits after the side information
+		mono := buf[i+3]>>6 == 3
+		sideInfo := 32
+		switch {
+		case version == 3 && mono:
+			sideInfo = 17
+		case version != 3 && mono:
+			sideInfo = 9
+		case version != 3:
+			sideInfo = 17
+		}
+		frame := buf[i:]
+		if x := 4 + sideInfo; len(frame) >= x+12 {
+			tag := string(frame[x : x+4])
+			if (tag == "Xing" || tag == "Info") && frame[x+7]&1 != 0 {
+				frames := binary.BigEndian.Uint32(frame[x+8 : x+12])
+				return samplesDuration(int64(frames)*int64(samplesPerFrame), sampleRate), nil
+			}
+		}
+		if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+			frames := binary.BigEndian.Uint32(frame[36+14 : 36+18])
+			return samplesDuration(int64(frames)*int64(samplesPerFrame), sampleRate), nil
+		}
+
+		// without a vbr header assume a constant bitrate across the file
+		audioBytes := info.Size() - audioStart - int64(i)
+		return time.Duration(float64(audioBytes*8) / float64(bitrate*1000) * float64(time.Second)), nil
+	}
+
+	return 0, errNoDuration
+}
+
+// mp4duration reads the duration and timescale from the moov/mvhd box.
+func mp4Duration(f *os.File) (time.Duration, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// find moov among the top-level boxes, then mvhd inside it
+	moovStart, moovSize, err := findMP4Box(f, 0, info.Size(), "moov")
+	if err != nil {
+		return 0, err
+	}
+	mvhdStart, _, err := findMP4Box(f, moovStart, moovSize, "mvhd")
+	if err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 32)
+	if _, err := f.ReadAt(header, mvhdStart); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	var timescale uint32
+	var duration uint64
+	if header[0] == 1 {
+		timescale = binary.BigEndian.Uint32(header[20:24])
+		duration = binary.BigEndian.Uint64(header[24:32])
+	} else {
+		timescale = binary.BigEndian.Uint32(header[12:16])
+		duration = uint64(binary.BigEndian.Uint32(header[16:20]))
+	}
+	if timescale == 0 {
+		return 0, errNoDuration
+	}
+	return samplesDuration(int64(duration), int(timescale)), nil
+}
+
+// findmp4box returns the payload offset and size of the first box named name
+// within the byte range [start, start+size).
+func findMP4Box(f *os.File, start, size int64, name string) (int64, int64, error) {
+	header := make([]byte, 16)
+	end := start + size
+	for offset := start; offset+8 <= end; {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			return 0, 0, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+
+		switch boxSize {
+		case 0:
+
+			// the box extends to the end of its parent
+			boxSize = end - offset
+		case 1:
+
+			// a 64-bit size follows the type
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return 0, 0, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if boxSize < headerSize {
+			return 0, 0, errNoDuration
+		}
+
+		if boxType == name {
+			return offset + headerSize, boxSize - headerSize, nil
+		}
+		offset += boxSize
+	}
+	return 0, 0, errNoDuration
+}
+
+// matroska element ids needed to find the duration
+const (
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549A966
+	ebmlTimecodeScale = 0x2AD7B1
+	ebmlDuration      = 0x4489
+)
+
+// mkvduration reads the duration and timecode scale from the segment info element.
+func mkvDuration(f *os.File) (time.Duration, error) {
+	r := &countingReader{r: f}
+
+	// the file starts with an ebml header followed by the segment
+	for {
+		id, size, err := readEBMLElement(r)
+		if err != nil {
+			return 0, err
+		}
+		if id == ebmlSegment {
+			break
+		}
+		if size < 0 {
+			return 0, errNoDuration
+		}
+		if _, err := f.Seek(size, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+
+	// walk the segment's children until the info element
+	for {
+		id, size, err := readEBMLElement(r)
+		if err != nil {
+			return 0, err
+		}
+		if size < 0 {
+			return 0, errNoDuration
+		}
+		if id != ebmlInfo {
+			if _, err := f.Seek(size, io.SeekCurrent); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		// read the info children for the scale and the duration
+		scale := uint64(1000000)
+		duration := -1.0
+		end := r.n + size
+		for r.n < end {
+			childID, childSize, err := readEBMLElement(r)
+			if err != nil || childSize < 0 || r.n+childSize > end {
+				return 0, errNoDuration
+			}
+
+			// the scale and the duration are numbers of at most 8 bytes, the
+			// rest is skipped unread since its size comes from the file
+			if childID != ebmlTimecodeScale && childID != ebmlDuration {
+				if err := r.skip(childSize); err != nil {
+					return 0, errNoDuration
+				}
+				continue
+			}
+			if childSize > 8 {
+				return 0, errNoDuration
+			}
+			data := make([]byte, childSize)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return 0, errNoDuration
+			}
+			switch childID {
+			case ebmlTimecodeScale:
+				scale = 0
+				for _, b := range data {
+					scale = scale<<8 | uint64(b)
+				}
+			case ebmlDuration:
+				switch len(data) {
+				case 4:
+					duration = float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+				case 8:
+					duration = math.Float64frombits(binary.BigEndian.Uint64(data))
+				}
+			}
+		}
+		if duration < 0 {
+			return 0, errNoDuration
+		}
+		return time.Duration(duration * float64(scale)), nil
+	}
+}
+
+// readebmlelement reads an element id and its data size. a size of -1 means unknown.
+func readEBMLElement(r *countingReader) (uint64, int64, error) {
+	id, _, err := readEBMLVint(r, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, unknown, err := readEBMLVint(r, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	if unknown {
+		return id, -1, nil
+	}
+	return id, int64(size), nil
+}
+
+// readebmlvint reads a variable length integer. ids keep their length marker bits,
+// sizes don't, and a size with every value bit set is reported as unknown.
+func readEBMLVint(r *countingReader, keepMarker bool) (uint64, bool, error) {
+	var first [1]byte
+	if _, err := io.ReadFull(r, first[:]); err != nil {
+		return 0, false, err
+	}
+
+	length := 1
+	for mask := byte(0x80); length <= 8 && first[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, false, errNoDuration
+	}
+
+	value := uint64(first[0])
+	if !keepMarker {
+		value &= uint64(0xff >> length)
+	}
+	allOnes := value == uint64(0xff>>length)
+
+	rest := make([]byte, length-1)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return 0, false, err
+	}
+	for _, b := range rest {
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xff
+	}
+	return value, !keepMarker && allOnes, nil
+}
+
+// countingreader tracks how many bytes have been read so element ends can be found.
+type countingReader struct {
+	r io.ReadSeeker
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// skip moves past n bytes without reading them.
+func (c *countingReader) skip(n int64) error {
+	if _, err := c.r.Seek(n, io.SeekCurrent); err != nil {
+		return err
+	}
+	c.n += n
+	return nil
+}
+
+// flacduration reads the total sample count and rate from the streaminfo block.
+func flacDuration(f *os.File) (time.Duration, error) {
+	header := make([]byte, 4+4+34)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return 0, err
+	}
+	if string(header[:4]) != "fLaC" || header[4]&0x7f != 0 {
+		return 0, errNoDuration
+	}
+
+	// streaminfo packs the sample rate into 20 bits and the sample count into 36
+	info := header[8:]
+	sampleRate := int(info[10])<<12 | int(info[11])<<4 | int(info[12])>>4
+	samples := int64(info[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(info[14:18]))
+	if sampleRate == 0 || samples == 0 {
+		return 0, errNoDuration
+	}
+	return samplesDuration(samples, sampleRate), nil
+}
+
+// oggduration divides the granule position of the last page by the sample rate
+// from the vorbis or opus identification header.
+func oggDuration(f *os.File) (time.Duration, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// the identification header is in the first page
+	first := make([]byte, 512)
+	n, err := f.ReadAt(first, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	first = first[:n]
+
+	var sampleRate int
+	var preSkip int64
+	if i := bytes.Index(first, []byte("\x01vorbis")); i >= 0 && len(first) >= i+16 {
+		sampleRate = int(binary.LittleEndian.Uint32(first[i+12 : i+16]))
+	} else if i := bytes.Index(first, []byte("OpusHead")); i >= 0 && len(first) >= i+12 {
+
+		// opus granule positions always count 48khz samples
+		sampleRate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(first[i+10 : i+12]))
+	}
+	if sampleRate == 0 {
+		return 0, errNoDuration
+	}
+
+	// find the last page header near the end of the file
+	tailSize := int64(64 * 1024)
+	if tailSize > info.Size() {
+		tailSize = info.Size()
+	}
+	tail := make([]byte, tailSize)
+	if _, err := f.ReadAt(tail, info.Size()-tailSize); err != nil && err != io.EOF {
+		return 0, err
+	}
+	i := bytes.LastIndex(tail, []byte("OggS"))
+	if i < 0 || len(tail) < i+14 {
+		return 0, errNoDuration
+	}
+	granule := int64(binary.LittleEndian.Uint64(tail[i+6 : i+14]))
+	if granule <= preSkip {
+		return 0, errNoDuration
+	}
+	return samplesDuration(granule-preSkip, sampleRate), nil
+}
+
+// maxwavformatsize caps how much of a wav fmt chunk is read. the byte rate is
+// in the first 12 bytes and the chunk is 40 at most, so the size a file claims
+// beyond that is skipped rather than allocated.
+const maxWAVFormatSize = 64
+
+// wavduration divides the size of the data chunk by the byte rate from the fmt chunk.
+func wavDuration(f *os.File) (time.Duration, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return 0, err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return 0, errNoDuration
+	}
+
+	var byteRate uint32
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(f, chunk); err != nil {
+			return 0, err
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		switch string(chunk[:4]) {
+		case "fmt ":
+			read := size
+			if read > maxWAVFormatSize {
+				read = maxWAVFormatSize
+			}
+			format := make([]byte, read)
+			if _, err := io.ReadFull(f, format); err != nil || len(format) < 12 {
+				return 0, errNoDuration
+			}
+			byteRate = binary.LittleEndian.Uint32(format[8:12])
+			if _, err := f.Seek(size-read+size%2, io.SeekCurrent); err != nil {
+				return 0, err
+			}
+			continue
+		case "data":
+			if byteRate == 0 {
+				return 0, errNoDuration
+			}
+			return time.Duration(float64(size) / float64(byteRate) * float64(time.Second)), nil
+		}
+
+		// chunks are padded to an even length
+		if _, err := f.Seek(size+size%2, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// samplesduration converts a sample count at the given rate into a duration.
+func samplesDuration(samples int64, rate int) time.Duration {
+	return time.Duration(float64(samples) / float64(rate) * float64(time.Second))
+}
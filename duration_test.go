@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// ebml builds a matroska element from its id, with data of the given size
+// following. a negative size writes the size as unknown.
+func ebml(id uint32, size int64, data []byte) []byte {
+	var b bytes.Buffer
+	for shift := 24; shift >= 0; shift -= 8 {
+		if byte(id>>shift) != 0 || b.Len() > 0 {
+			b.WriteByte(byte(id >> shift))
+		}
+	}
+	switch {
+	case size < 0:
+		b.Write([]byte{0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	case size < 0x7f:
+		b.WriteByte(0x80 | byte(size))
+	default:
+		b.WriteByte(0x01)
+		for shift := 48; shift >= 0; shift -= 8 {
+			b.WriteByte(byte(size >> shift))
+		}
+	}
+	b.Write(data)
+	return b.Bytes()
+}
+
+// mkvFile builds a matroska file whose segment info holds children.
+func mkvFile(children ...[]byte) []byte {
+	info := bytes.Join(children, nil)
+	return append(ebml(0x1A45DFA3, 0, nil), ebml(ebmlSegment, -1, ebml(ebmlInfo, int64(len(info)), info))...)
+}
+
+// wavFile builds a wav file with an fmt chunk claiming formatSize bytes,
+// holding format, and a data chunk of dataSize bytes after it.
+func wavFile(formatSize uint32, format []byte, dataSize uint32) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF\x00\x00\x00\x00WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, formatSize)
+	b.Write(format)
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, dataSize)
+	return b.Bytes()
+}
+
+// wavFormat is a 16-byte fmt chunk with the given byte rate.
+func wavFormat(byteRate uint32) []byte {
+	format := make([]byte, 16)
+	binary.LittleEndian.PutUint32(format[8:], byteRate)
+	return format
+}
+
+func TestMalformedDurations(t *testing.T) {
+	duration := make([]byte, 8)
+	binary.BigEndian.PutUint64(duration, math.Float64bits(1500))
+	scale := ebml(ebmlTimecodeScale, 3, []byte{0x0f, 0x42, 0x40})
+	huge := int64(1) << 50
+	wav := func(format []byte) []byte { return wavFile(uint32(len(format)), format, 2500) }
+
+	tests := []struct {
+		name  string
+		probe func(*os.File) (time.Duration, error)
+		data  []byte
+		want  time.Duration
+	}{
+		{"mkv", mkvDuration, mkvFile(scale, ebml(0x4D80, 3, []byte("app")), ebml(ebmlDuration, 8, duration)), 1500 * time.Millisecond},
+
+		// a child claiming petabytes is skipped, not allocated
+		{"mkv with a huge child", mkvDuration, mkvFile(scale, ebml(0x4D80, huge, []byte("app"))), 0},
+		{"mkv with a huge child before the duration", mkvDuration, append(mkvFile(scale, ebml(0x4D80, huge, nil)), ebml(ebmlDuration, 8, duration)...), 0},
+		{"mkv with a large child past the end", mkvDuration, append(ebml(0x1A45DFA3, 0, nil), ebml(ebmlSegment, -1, ebml(ebmlInfo, 64<<20, ebml(0x4D80, 32<<20, nil)))...), 0},
+		{"mkv with a long duration", mkvDuration, mkvFile(scale, ebml(ebmlDuration, 16, append(duration, duration...))), 0},
+		{"mkv with a long scale", mkvDuration, mkvFile(ebml(ebmlTimecodeScale, huge, nil), ebml(ebmlDuration, 8, duration)), 0},
+		{"truncated mkv", mkvDuration, mkvFile(scale, ebml(ebmlDuration, 8, duration))[:30], 0},
+
+		{"wav", wavDuration, wav(wavFormat(1000)), 2500 * time.Millisecond},
+		{"wav with a long fmt chunk", wavDuration, wav(append(wavFormat(1000), make([]byte, 100)...)), 2500 * time.Millisecond},
+
+		// an fmt chunk claiming 4gb is read only as far as the cap
+		{"wav with a huge fmt chunk", wavDuration, wavFile(math.MaxUint32, wavFormat(1000), 2500), 0},
+		{"wav with a short fmt chunk", wavDuration, wav(make([]byte, 8)), 0},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			got, err := tt.probe(f)
+			runtime.ReadMemStats(&after)
+			if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+				t.Errorf("allocated %d bytes", allocated)
+			}
+			if tt.want == 0 {
+				if err == nil {
+					t.Errorf("got %v, want an error", got)
+				} else if !errors.Is(err, errNoDuration) {
+					t.Errorf("err = %v, want errNoDuration", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
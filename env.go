@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// envprefix starts the environment variables that override [Server] settings.
+const envPrefix = "CHILL_"
+
+// the variables that aren't a [Server] setting of their own: the port of
+// Listen, and categories for a container run without a config file
+const (
+	envPort        = "PORT"
+	envDirectories = "DIRECTORIES"
+	envFileTypes   = "FILETYPES"
+)
+
+// serverkeyforenv finds the [Server] key an environment variable name stands
+// for, ignoring case and underscores, so CHILL_MAX_ZIP_SIZE and CHILL_MAXZIPSIZE
+// both set MaxZipSize. the Header. and MIME. maps have no single key, so they
+// can't be set this way.
+func serverKeyForEnv(name string) (string, bool) {
+	want := strings.ReplaceAll(strings.ToUpper(name), "_", "")
+	t := reflect.TypeOf(ServerConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type.Kind() == reflect.Map {
+			continue
+		}
+		if strings.ToUpper(field.Name) == want {
+			return field.Name, true
+		}
+	}
+	return "", false
+}
+
+// applyenv overrides [Server] settings from CHILL_ variables in environ, after
+// the config file is loaded, so one file can be shared by several hosts. the
+// values are read like the config file's, and an invalid one stops startup.
+// a variable naming no setting is only warned about. CHILL_PORT and
+// CHILL_DIRECTORIES are applied last, so the port replaces that of
+// CHILL_LISTEN whichever comes first.
+func applyEnv(cfg *Config, environ []string) error {
+	extra := make(map[string]string)
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		setting, ok := strings.CutPrefix(name, envPrefix)
+		if !ok || setting == "" {
+			continue
+		}
+		switch setting {
+		case envPort, envDirectories, envFileTypes:
+			extra[setting] = value
+			continue
+		}
+		key, ok := serverKeyForEnv(setting)
+		if !ok {
+			log.Printf("Warning: %s doesn't name a [Server] setting, ignoring it", name)
+			continue
+		}
+		if err := cfg.Server.set(key, scalarValue(value)); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	// the port keeps the host of Listen, like -port
+	if port, ok := extra[envPort]; ok {
+		f := listenFlags{port: strings.TrimSpace(port)}
+		if err := f.check(); err != nil {
+			return fmt.Errorf("%s%s: invalid value %q, expected a number from 0 to 65535", envPrefix, envPort, port)
+		}
+		addr, err := f.address(cfg.Server.Listen)
+		if err != nil {
+			return fmt.Errorf("%s%s: %w", envPrefix, envPort, err)
+		}
+		cfg.Server.Listen = addr
+	}
+
+	if dirs, ok := extra[envDirectories]; ok {
+		categories, err := envCategories(dirs, extra[envFileTypes], cfg.Categories)
+		if err != nil {
+			return fmt.Errorf("%s%s: %w", envPrefix, envDirectories, err)
+		}
+		cfg.Categories = append(cfg.Categories, categories...)
+	} else if _, ok := extra[envFileTypes]; ok {
+		log.Printf("Warning: %s%s only applies to %s%s, ignoring it", envPrefix, envFileTypes, envPrefix, envDirectories)
+	}
+	return nil
+}
+
+// envvalue returns the value of the named variable in environ, or an empty
+// string when it isn't set.
+func envValue(environ []string, name string) string {
+	for _, kv := range environ {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == name {
+			return v
+		}
+	}
+	return ""
+}
+
+// envcategories makes a category of each folder in dirs, a list like $PATH's
+// of /media/music or Music=/media/music entries. a folder without a name is
+// named after its last element. every one lists fileTypes, comma separated,
+// or every media type chill knows when that's empty. names already taken by
+// the config file are an error.
+func envCategories(dirs, fileTypes string, existing []CategoryConfig) ([]CategoryConfig, error) {
+	taken := make(map[string]bool)
+	for _, config := range existing {
+		taken[config.Name] = true
+	}
+	types := normalizeFileTypes(scalarValue(fileTypes).strings())
+	if strings.TrimSpace(fileTypes) == "" {
+		types = nil
+		for ext := range knownMediaTypes {
+			types = append(types, ext)
+		}
+		sort.Strings(types)
+	}
+
+	var categories []CategoryConfig
+	for _, entry := range filepath.SplitList(dirs) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, dir, ok := strings.Cut(entry, "=")
+		if !ok {
+			dir = entry
+			name = filepath.Base(filepath.Clean(entry))
+		}
+		name, dir = strings.TrimSpace(name), strings.TrimSpace(dir)
+		if name == "" || name == "." || name == string(filepath.Separator) || dir == "" {
+			return nil, fmt.Errorf("can't make a category of %q, name it like Music=%s", entry, dir)
+		}
+		if name == serverSection || taken[name] {
+			return nil, fmt.Errorf("a category named %s is already in the config", name)
+		}
+		taken[name] = true
+		categories = append(categories, CategoryConfig{
+			Name:      name,
+			Directory: dir,
+			FileTypes: append([]string(nil), types...),
+		})
+	}
+	return categories, nil
+}
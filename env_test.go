@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// fileConfig is the config file the precedence tests start from.
+const fileConfig = "[Server]\nListen=127.0.0.1:8000\nSiteTitle=From the file\nMetrics=true\nMaxZipSize=100\n" +
+	"[Music]\nDirectory=/music\nFileTypes=.mp3\n"
+
+func TestEnvOverridesTheFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		check   func(ServerConfig) bool
+	}{
+		{"nothing set keeps the file", nil, func(s ServerConfig) bool {
+			return s.Listen == "127.0.0.1:8000" && s.SiteTitle == "From the file" && s.Metrics && s.MaxZipSize == 100
+		}},
+		{"a string", []string{"CHILL_SITE_TITLE=From the env"}, func(s ServerConfig) bool { return s.SiteTitle == "From the env" }},
+		{"a bool", []string{"CHILL_METRICS=false"}, func(s ServerConfig) bool { return !s.Metrics }},
+		{"a number", []string{"CHILL_MAXZIPSIZE=5"}, func(s ServerConfig) bool { return s.MaxZipSize == 5 }},
+		{"the address", []string{"CHILL_LISTEN=[::1]:9000"}, func(s ServerConfig) bool { return s.Listen == "[::1]:9000" }},
+		{"the port keeps the file's host", []string{"CHILL_PORT=9000"}, func(s ServerConfig) bool { return s.Listen == "127.0.0.1:9000" }},
+		{"the port comes after the address", []string{"CHILL_PORT=9001", "CHILL_LISTEN=0.0.0.0:9000"}, func(s ServerConfig) bool { return s.Listen == "0.0.0.0:9001" }},
+		{"other variables are left alone", []string{"HOME=/root", "CHILLY=1", "SITETITLE=no"}, func(s ServerConfig) bool { return s.SiteTitle == "From the file" }},
+	}
+	for _, tt := range tests {
+		cfg, err := ParseConfig(strings.NewReader(fileConfig))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := applyEnv(cfg, tt.environ); err != nil {
+			t.Errorf("%s: applyEnv: %v", tt.name, err)
+			continue
+		}
+		if !tt.check(cfg.Server) {
+			t.Errorf("%s: got %+v", tt.name, cfg.Server)
+		}
+	}
+}
+
+func TestEnvErrors(t *testing.T) {
+	logged := captureLog(t)
+	for _, environ := range [][]string{{"CHILL_METRICS=maybe"}, {"CHILL_PORT=http"}, {"CHILL_MAX_ZIP_SIZE=big"}} {
+		cfg, _ := ParseConfig(strings.NewReader(fileConfig))
+		if err := applyEnv(cfg, environ); err == nil || !strings.Contains(err.Error(), strings.Split(environ[0], "=")[0]) {
+			t.Errorf("applyEnv(%v) = %v, want an error naming the variable", environ, err)
+		}
+	}
+
+	// a name that isn't a setting is only warned about
+	cfg, _ := ParseConfig(strings.NewReader(fileConfig))
+	if err := applyEnv(cfg, []string{"CHILL_NO_SUCH_THING=1"}); err != nil || !strings.Contains(logged.String(), "CHILL_NO_SUCH_THING") {
+		t.Errorf("applyEnv with an unknown name = %v, log:\n%s", err, logged)
+	}
+}
+
+func TestFlagsOverrideTheEnv(t *testing.T) {
+	tests := []struct {
+		flags listenFlags
+		want  string
+	}{
+		{listenFlags{}, "0.0.0.0:9000"},
+		{listenFlags{port: "9100"}, "0.0.0.0:9100"},
+		{listenFlags{addr: "127.0.0.2"}, "127.0.0.2:9000"},
+		{listenFlags{listen: "[::1]:7000"}, "[::1]:7000"},
+	}
+	for _, tt := range tests {
+
+		// file, then env, then flags, the way main loads them
+		cfg, _ := ParseConfig(strings.NewReader(fileConfig))
+		if err := applyEnv(cfg, []string{"CHILL_LISTEN=0.0.0.0:9000"}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := tt.flags.address(cfg.Server.Listen)
+		if err != nil || got != tt.want {
+			t.Errorf("%+v: address = %q, %v, want %q", tt.flags, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// externalplaceholder is replaced with a file's absolute url in ExternalScheme.
+const externalPlaceholder = "{url}"
+
+// externallink turns the ExternalScheme template, like vlc://{url}, into a link
+// per file that opens it in a desktop app instead of the browser.
+type externalLink struct {
+	scheme string
+	root   url.URL
+}
+
+// external returns the link builder for a request, or nil when ExternalScheme
+// is unset and only the normal links are shown.
+func (s *server) external(r *http.Request) *externalLink {
+	if s.cfg.Server.ExternalScheme == "" {
+		return nil
+	}
+	return &externalLink{scheme: s.cfg.Server.ExternalScheme, root: rootURL(r, s.cfg.Server.BasePath)}
+}
+
+// link renders the template for a file. the result is marked safe because
+// html/template would otherwise blank out any scheme it doesn't know, and the
+// template comes from the config rather than from a visitor.
+func (e *externalLink) Link(filePath string) template.URL {
+	link := e.root
+	link.Path += filePath
+	return template.URL(strings.ReplaceAll(e.scheme, externalPlaceholder, link.String()))
+}
+
+// scheme and root let the listing script build the same links for files it
+// fetches after the page has loaded.
+func (e *externalLink) Scheme() string { return e.scheme }
+func (e *externalLink) Root() string   { return e.root.String() }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultfeedcount is how many items the feed lists when FeedCount is unset.
+const defaultFeedCount = 50
+
+// rss is the root of an rss 2.0 document.
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+// rsschannel describes the feed itself.
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+// rssitem is a single media file in the feed.
+type rssItem struct {
+	Title     string       `xml:"title"`
+	Link      string       `xml:"link"`
+	GUID      string       `xml:"guid"`
+	PubDate   string       `xml:"pubDate"`
+	Enclosure rssEnclosure `xml:"enclosure"`
+}
+
+// rssenclosure points podcast clients at the file to download.
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// rooturl returns the absolute url of the listing as the client reached it,
+// for links that have to work outside the page.
+func rootURL(r *http.Request, basePath string) url.URL {
+	root := url.URL{Scheme: "http", Host: r.Host, Path: basePath + "/"}
+	if r.TLS != nil {
+		root.Scheme = "https"
+	}
+	return root
+}
+
+// handlefeed serves the most recently modified files as an rss feed at
+// /feed.xml, or those of one category with ?category=name.
+func (s *server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	configs := s.categories(r)
+	title := s.cfg.Server.SiteTitle
+	if name := r.URL.Query().Get("category"); name != "" {
+		config, ok := s.category(name)
+		if !ok {
+			s.notFound(w, r)
+			return
+		}
+		if !s.authorize(w, r, config) {
+			return
+		}
+		configs = []CategoryConfig{config}
+		title = MediaGroup{Name: config.Name, Title: config.Title}.Heading() + " - " + title
+	}
+
+	groups, err := buildMediaList(r.Context(), configs, s.cfg.Server)
+	if err != nil {
+		message, status := libraryError(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	n := s.cfg.Server.FeedCount
+	if n <= 0 {
+		n = defaultFeedCount
+	}
+
+	// every link in a feed has to be absolute, so build them from the request
+	root := rootURL(r, s.cfg.Server.BasePath)
+
+	feed := rss{Version: "2.0", Channel: rssChannel{
+		Title:       title,
+		Link:        root.String(),
+		Description: "Recently added media",
+	}}
+	for _, file := range recentlyAdded(groups, n).Files {
+		link := root
+		link.Path += file.Path
+		mime := file.MIME()
+		if mime == "" {
+			mime = "application/octet-stream"
+		}
+
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:     file.DisplayName(),
+			Link:      link.String(),
+			GUID:      link.String(),
+			PubDate:   file.ModTime.UTC().Format(time.RFC1123Z),
+			Enclosure: rssEnclosure{URL: link.String(), Length: file.Size, Type: mime},
+		})
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// filecache memoizes an expensive per-file computation, keyed by path and
+// invalidated whenever the file's size or modification time changes.
+type fileCache[T any] struct {
+	name string
+
+	mu      sync.Mutex
+	entries map[string]fileCacheEntry[T]
+
+	// hits and misses count the lookups for /metrics
+	hits, misses atomic.Uint64
+}
+
+// filecacheentry is a cached value along with the file state it was computed from.
+type fileCacheEntry[T any] struct {
+	size    int64
+	modTime time.Time
+	value   T
+}
+
+// newfilecache creates an empty cache, counted in the metrics under name.
+func newFileCache[T any](name string) *fileCache[T] {
+	c := &fileCache[T]{name: name, entries: make(map[string]fileCacheEntry[T])}
+	metrics.addCache(c)
+	return c
+}
+
+// get returns the cached value for path, calling load when the entry is missing
+// or stale. load runs without the lock held so slow files don't block others.
+func (c *fileCache[T]) get(path string, info os.FileInfo, load func(path string) T) T {
+	c.mu.Lock()
+	entry, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		c.hits.Add(1)
+		return entry.value
+	}
+	c.misses.Add(1)
+
+	value := load(path)
+
+	c.put(path, info.Size(), info.ModTime(), value)
+	return value
+}
+
+// put caches a value for path as it was at the given size and modification
+// time, like one loaded before a restart.
+func (c *fileCache[T]) put(path string, size int64, modTime time.Time, value T) {
+	c.mu.Lock()
+	c.entries[path] = fileCacheEntry[T]{size: size, modTime: modTime, value: value}
+	c.mu.Unlock()
+}
+
+// cachename is the name the cache is counted under.
+func (c *fileCache[T]) cacheName() string {
+	return c.name
+}
+
+// lookups returns how many lookups found a current value and how many had to
+// load one.
+func (c *fileCache[T]) lookups() (hits, misses uint64) {
+	return c.hits.Load(), c.misses.Load()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"path"
+	"strings"
+)
+
+// mimekeyprefix marks a [Server] key as a mime type for an extension, so
+// MIME.mka=audio/x-matroska serves .mka files with that type.
+const mimeKeyPrefix = "MIME."
+
+// mediakind is the broad family a file type belongs to.
+type mediaKind string
+
+const (
+	kindAudio    mediaKind = "audio"
+	kindVideo    mediaKind = "video"
+	kindImage    mediaKind = "image"
+	kindPlaylist mediaKind = "playlist"
+	kindDocument mediaKind = "document"
+	kindSubtitle mediaKind = "subtitle"
+)
+
+// mediatype describes a recognized file extension.
+type mediaType struct {
+	Kind mediaKind
+	MIME string
+}
+
+// knownmediatypes are the extensions chill recognizes, keyed by lowercase extension.
+var knownMediaTypes = map[string]mediaType{
+	// audio
+	".aac":  {kindAudio, "audio/aac"},
+	".aif":  {kindAudio, "audio/aiff"},
+	".aiff": {kindAudio, "audio/aiff"},
+	".alac": {kindAudio, "audio/mp4"},
+	".ape":  {kindAudio, "audio/ape"},
+	".flac": {kindAudio, "audio/flac"},
+	".m4a":  {kindAudio, "audio/mp4"},
+	".m4b":  {kindAudio, "audio/mp4"},
+	".mid":  {kindAudio, "audio/midi"},
+	".midi": {kindAudio, "audio/midi"},
+	".mka":  {kindAudio, "audio/x-matroska"},
+	".mp2":  {kindAudio, "audio/mpeg"},
+	".mp3":  {kindAudio, "audio/mpeg"},
+	".oga":  {kindAudio, "audio/ogg"},
+	".ogg":  {kindAudio, "audio/ogg"},
+	".opus": {kindAudio, "audio/ogg; codecs=opus"},
+	".wav":  {kindAudio, "audio/wav"},
+	".weba": {kindAudio, "audio/webm"},
+	".wma":  {kindAudio, "audio/x-ms-wma"},
+
+	// video
+	".3gp":  {kindVideo, "video/3gpp"},
+	".avi":  {kindVideo, "video/x-msvideo"},
+	".flv":  {kindVideo, "video/x-flv"},
+	".m2ts": {kindVideo, "video/mp2t"},
+	".m4v":  {kindVideo, "video/mp4"},
+	".mkv":  {kindVideo, "video/x-matroska"},
+	".mov":  {kindVideo, "video/quicktime"},
+	".mp4":  {kindVideo, "video/mp4"},
+	".mpeg": {kindVideo, "video/mpeg"},
+	".mpg":  {kindVideo, "video/mpeg"},
+	".ogv":  {kindVideo, "video/ogg"},
+	".ts":   {kindVideo, "video/mp2t"},
+	".webm": {kindVideo, "video/webm"},
+	".wmv":  {kindVideo, "video/x-ms-wmv"},
+
+	// images
+	".avif": {kindImage, "image/avif"},
+	".bmp":  {kindImage, "image/bmp"},
+	".gif":  {kindImage, "image/gif"},
+	".heic": {kindImage, "image/heic"},
+	".jpeg": {kindImage, "image/jpeg"},
+	".jpg":  {kindImage, "image/jpeg"},
+	".png":  {kindImage, "image/png"},
+	".svg":  {kindImage, "image/svg+xml"},
+	".tif":  {kindImage, "image/tiff"},
+	".tiff": {kindImage, "image/tiff"},
+	".webp": {kindImage, "image/webp"},
+
+	// playlists
+	".m3u":  {kindPlaylist, "audio/x-mpegurl"},
+	".m3u8": {kindPlaylist, "application/vnd.apple.mpegurl"},
+	".pls":  {kindPlaylist, "audio/x-scpls"},
+	".xspf": {kindPlaylist, "application/xspf+xml"},
+
+	// documents
+	".cbr":  {kindDocument, "application/vnd.comicbook-rar"},
+	".cbz":  {kindDocument, "application/vnd.comicbook+zip"},
+	".epub": {kindDocument, "application/epub+zip"},
+	".mobi": {kindDocument, "application/x-mobipocket-ebook"},
+	".pdf":  {kindDocument, "application/pdf"},
+	".txt":  {kindDocument, "text/plain; charset=utf-8"},
+
+	// subtitles
+	".ass": {kindSubtitle, "text/x-ssa"},
+	".srt": {kindSubtitle, "application/x-subrip"},
+	".ssa": {kindSubtitle, "text/x-ssa"},
+	".vtt": {kindSubtitle, "text/vtt"},
+}
+
+// registermimetypes makes the file server, and everything else that goes by
+// mime.TypeByExtension, use the types above and those from the config, so
+// media plays in the browser even on hosts without an /etc/mime.types. a
+// configured type for an unknown extension also makes it a known media type,
+// with the kind taken from the type, like audio for audio/x-matroska.
+func registerMIMETypes(extra map[string]string) {
+	for ext, mediaType := range extra {
+		t := knownMediaTypes[ext]
+		t.MIME = mediaType
+		if t.Kind == "" {
+			t.Kind = kindOfMIME(mediaType)
+		}
+		knownMediaTypes[ext] = t
+	}
+	for ext, t := range knownMediaTypes {
+		if err := mime.AddExtensionType(ext, t.MIME); err != nil {
+			log.Printf("Could not register the mime type of %s: %v", ext, err)
+		}
+	}
+}
+
+// kindofmime guesses the media kind from a mime type's top-level type.
+func kindOfMIME(mediaType string) mediaKind {
+	top, _, _ := strings.Cut(mediaType, "/")
+	switch top {
+	case "audio":
+		return kindAudio
+	case "video":
+		return kindVideo
+	case "image":
+		return kindImage
+	}
+	return ""
+}
+
+// kind returns the media kind of the file from its extension, or an empty kind
+// when the extension isn't recognized.
+func (f MediaFile) Kind() mediaKind {
+	return knownMediaTypes[normalizeFileType(path.Ext(f.Path))].Kind
+}
+
+// mime returns the mime type of the file from its extension, or an empty string
+// when the extension isn't recognized.
+func (f MediaFile) MIME() string {
+	return knownMediaTypes[normalizeFileType(path.Ext(f.Path))].MIME
+}
+
+// unknownfiletypes returns a warning for every declared extension that isn't a
+// recognized media type, which usually means a typo like .mp33.
+func unknownFileTypes(categories []CategoryConfig) []string {
+	var warnings []string
+	for _, category := range categories {
+		for _, fileType := range category.FileTypes {
+			if _, ok := knownMediaTypes[fileType]; !ok {
+				warnings = append(warnings, fmt.Sprintf("category %s: %s is not a recognized media type", category.Name, fileType))
+			}
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestIsAllowedFileType(t *testing.T) {
+
+	// config entries are matched however they're written
+	fileTypes := normalizeFileTypes([]string{"jpg", ".MP4", "Flac", " .mkv ", "", "."})
+	if want := []string{".jpg", ".mp4", ".flac", ".mkv"}; !reflect.DeepEqual(fileTypes, want) {
+		t.Fatalf("normalizeFileTypes = %q, want %q", fileTypes, want)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"photo.JPG", true},
+		{"photo.jpg", true},
+		{"film.MP4", true},
+		{"album/song.Flac", true},
+		{"film.MkV", true},
+		{"notes.txt", false},
+		{"NOTES.TXT", false},
+		{"mp4", false},
+		{"no-extension", false},
+		{".flac", true},
+		{"notes.", false},
+		{"song.flac.txt", false},
+		{"archive.tar.FLAC", true},
+	}
+	for _, tt := range tests {
+		if got := isAllowedFileType(tt.path, fileTypes); got != tt.want {
+			t.Errorf("isAllowedFileType(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMediaFilesServeWithTheirType(t *testing.T) {
+
+	// configured types change the shared table, so put it back afterwards
+	saved := make(map[string]mediaType, len(knownMediaTypes))
+	for ext, t := range knownMediaTypes {
+		saved[ext] = t
+	}
+	t.Cleanup(func() { knownMediaTypes = saved })
+
+	dir := testTree(t, "song.flac", "song.opus", "film.mkv", "clip.webm", "tune.chilltest")
+	h := testServer(t, "[Server]\nMIME.chilltest=audio/x-chill\nMIME.mkv=video/x-matroska-custom\n"+
+		"[Media]\nDirectory="+dir+"\nFileTypes=.flac,.opus,.mkv,.webm,.chilltest\n").routes()
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/song.flac", "audio/flac"},
+		{"/song.opus", "audio/ogg; codecs=opus"},
+		{"/clip.webm", "video/webm"},
+
+		// MIME. keys replace a type or add an extension
+		{"/film.mkv", "video/x-matroska-custom"},
+		{"/tune.chilltest", "audio/x-chill"},
+	}
+	for _, tt := range tests {
+		w := request(h, http.MethodGet, tt.target)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s = %d", tt.target, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.want {
+			t.Errorf("GET %s has Content-Type %q, want %q", tt.target, got, tt.want)
+		}
+	}
+
+	// a configured type makes the extension playable media too
+	if kind := knownMediaTypes[".chilltest"].Kind; kind != kindAudio {
+		t.Errorf(".chilltest is kind %q, want audio", kind)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// headerkeyprefix marks a [Server] key as an extra response header, so
+// Header.X-Frame-Options=DENY adds that header to every response.
+const headerKeyPrefix = "Header."
+
+// withheaders adds the configured extra headers to every response.
+func (s *server) withHeaders(next http.Handler) http.Handler {
+	if len(s.cfg.Server.Headers) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range s.cfg.Server.Headers {
+			w.Header()[name] = values
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsmethods are the methods a preflight allows, those of the playlists api
+// as well as the read-only endpoints.
+const corsMethods = "GET, HEAD, POST, PUT, DELETE, OPTIONS"
+
+// cors lets pages on the origins in AllowOrigin read an endpoint from the
+// browser. without AllowOrigin no cors headers are sent and browsers keep
+// blocking cross-origin reads.
+func (s *server) cors(next http.HandlerFunc) http.HandlerFunc {
+	if len(s.cfg.Server.AllowOrigin) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.allowOrigin(w, r)
+		next(w, r)
+	}
+}
+
+// preflight answers the requests browsers send ahead of a cross-origin one,
+// asking whether it may be sent, so none of them reaches a handler. it comes
+// before the login, because browsers never send credentials with a preflight.
+// answering one gives nothing away: the request that follows still needs the
+// login, and only the cors endpoints let the page read what comes back.
+func (s *server) preflight(next http.Handler) http.Handler {
+	if len(s.cfg.Server.AllowOrigin) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.allowOrigin(w, r) {
+			w.Header().Set("Access-Control-Allow-Methods", corsMethods)
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// alloworigin sets Access-Control-Allow-Origin when the request's origin is
+// allowed, and reports whether it was.
+func (s *server) allowOrigin(w http.ResponseWriter, r *http.Request) bool {
+	allowed := s.allowedOrigin(r.Header.Get("Origin"))
+	if allowed != "" {
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+	}
+
+	// the answer depends on the origin unless every origin is allowed
+	if allowed != "*" {
+		w.Header().Add("Vary", "Origin")
+	}
+	return allowed != ""
+}
+
+// allowedorigin returns the value for Access-Control-Allow-Origin, or an empty
+// string when origin isn't allowed.
+func (s *server) allowedOrigin(origin string) string {
+	for _, allowed := range s.cfg.Server.AllowOrigin {
+		if allowed == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
+}
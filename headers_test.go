@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// crossOrigin sends a request from a page on origin, with the site login
+// when user isn't empty, and a preflight's headers when preflight isn't.
+func crossOrigin(h http.Handler, method, target, origin, user, preflight string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("Origin", origin)
+	if user != "" {
+		r.SetBasicAuth(user, "correct horse battery staple")
+	}
+	if preflight != "" {
+		r.Header.Set("Access-Control-Request-Method", preflight)
+		r.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCORS(t *testing.T) {
+	dir := testTree(t, "song.mp3")
+	origin := "https://player.example.com"
+	s := testServer(t, "[Server]\nAllowOrigin="+origin+"\nAuthUser=family\nAuthHash="+siteHash+"\n"+
+		"[Music]\nDirectory="+dir+"\nFileTypes=.mp3\n")
+	playlists, err := openPlaylists(filepath.Join(t.TempDir(), "playlists.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.playlists = playlists
+	h := s.routes()
+
+	// the api and every playlist endpoint can be read from the allowed origin
+	for _, target := range []string{"/api/media", "/api/playlists", "/playlists", "/playlist.m3u", "/playlist.m3u8", "/shuffle.m3u"} {
+		w := crossOrigin(h, http.MethodGet, target, origin, "family", "")
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != origin {
+			t.Errorf("GET %s = %d with Access-Control-Allow-Origin %q, want 200 with %q", target, w.Code, w.Header().Get("Access-Control-Allow-Origin"), origin)
+		}
+	}
+
+	// preflights come without credentials, and are answered before the login
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+		w := crossOrigin(h, http.MethodOptions, "/api/playlists", origin, "", method)
+		if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != origin {
+			t.Errorf("preflight for %s = %d with Access-Control-Allow-Origin %q, want 204 with %q", method, w.Code, w.Header().Get("Access-Control-Allow-Origin"), origin)
+		}
+		if !strings.Contains(w.Header().Get("Access-Control-Allow-Methods"), method) {
+			t.Errorf("preflight for %s allows %q", method, w.Header().Get("Access-Control-Allow-Methods"))
+		}
+		if got := w.Header().Get("Access-Control-Allow-Headers"); got != "authorization, content-type" {
+			t.Errorf("preflight allows the headers %q", got)
+		}
+	}
+
+	// another origin's preflight is answered without allowing anything
+	w := crossOrigin(h, http.MethodOptions, "/api/media", "https://elsewhere.example.com", "", http.MethodGet)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Errorf("preflight from another origin = %d with %v", w.Code, w.Header())
+	}
+
+	// only a preflight gets past the login, not the request itself or a plain options
+	for _, method := range []string{http.MethodGet, http.MethodOptions} {
+		if w := crossOrigin(h, method, "/api/media", origin, "", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s /api/media without the login = %d, want 401", method, w.Code)
+		}
+	}
+	if w := crossOrigin(h, http.MethodGet, "/song.mp3", origin, "", http.MethodGet); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /song.mp3 with preflight headers and no login = %d, want 401", w.Code)
+	}
+
+	// without AllowOrigin there's no cors at all
+	h = testServer(t, "[Music]\nDirectory="+dir+"\nFileTypes=.mp3\n").routes()
+	for _, method := range []string{http.MethodGet, http.MethodOptions} {
+		w := crossOrigin(h, method, "/api/media", origin, "", http.MethodGet)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("%s /api/media without AllowOrigin sent Access-Control-Allow-Origin %q", method, got)
+		}
+	}
+}
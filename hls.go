@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// segments are cut this long, the last one takes what's left
+const (
+	hlsSegmentLength = 6 * time.Second
+	hlsTimeout       = 2 * time.Minute
+)
+
+// the names a video's playlists are served under, after its path
+const (
+	hlsMaster = "master.m3u8"
+	hlsIndex  = "index.m3u8"
+)
+
+// defaulthlscachesize is how much HLSCacheDir holds when HLSCacheSize isn't set.
+const defaultHLSCacheSize = 2 << 30
+
+// hlsslots lets a couple of segments be made at once, one player fetching
+// ahead while another starts.
+var hlsSlots = make(chan struct{}, 2)
+
+// hlsmaking holds a lock per segment being made, so two players asking for the
+// same one run ffmpeg once and the second waits for the first.
+var hlsMaking = struct {
+	sync.Mutex
+	segments map[string]*sync.Mutex
+}{segments: make(map[string]*sync.Mutex)}
+
+// hlspruning is held while the cache is cut back, so two new segments don't
+// both walk it.
+var hlsPruning sync.Mutex
+
+// hlscachedir returns where segments are kept: HLSCacheDir, or a folder in
+// the system's temporary directory.
+func hlsCacheDir(server ServerConfig) string {
+	if server.HLSCacheDir != "" {
+		return server.HLSCacheDir
+	}
+	return filepath.Join(os.TempDir(), "chill-hls")
+}
+
+// hlssegments returns how many segments a video of the given length is cut into.
+func hlsSegments(duration time.Duration) int {
+	return int((duration + hlsSegmentLength - 1) / hlsSegmentLength)
+}
+
+// hlssegmentargs returns the ffmpeg arguments that write segment n of the
+// video at path to out as mpeg-ts, in h.264 and aac like /transcode/. every
+// segment is made on its own, so its timestamps are shifted to where it
+// starts and it opens on a keyframe.
+func hlsSegmentArgs(path, out string, n int, length time.Duration) []string {
+	start := fmt.Sprintf("%.3f", (time.Duration(n) * hlsSegmentLength).Seconds())
+	return []string{
+		"-nostdin", "-loglevel", "error",
+		"-ss", start, "-i", path, "-t", fmt.Sprintf("%.3f", length.Seconds()),
+		"-map", "0:v:0?", "-map", "0:a:0?", "-sn",
+		"-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p", "-force_key_frames", "expr:eq(n,0)",
+		"-c:a", "aac", "-ac", "2",
+		"-output_ts_offset", start,
+		"-f", "mpegts", "-y", out,
+	}
+}
+
+// hlssegmentpath returns where segment n of a video is cached. the folder is
+// named after the file's path, size and modification time, so a changed file
+// gets fresh segments.
+func hlsSegmentPath(server ServerConfig, filePath string, info os.FileInfo, n int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d", filePath, info.Size(), info.ModTime().UnixNano())))
+	return filepath.Join(hlsCacheDir(server), hex.EncodeToString(sum[:8]), strconv.Itoa(n)+".ts")
+}
+
+// makehlssegment runs ffmpeg for segment n unless it's already cached, and
+// reports whether it did. like a thumbnail, it's finished even if the player
+// goes away, since the player usually asks again.
+func makeHLSSegment(ffmpeg, filePath, out string, n int, length time.Duration) (bool, error) {
+	hlsMaking.Lock()
+	lock, ok := hlsMaking.segments[out]
+	if !ok {
+		lock = new(sync.Mutex)
+		hlsMaking.segments[out] = lock
+	}
+	hlsMaking.Unlock()
+	lock.Lock()
+	defer func() {
+		lock.Unlock()
+		hlsMaking.Lock()
+		delete(hlsMaking.segments, out)
+		hlsMaking.Unlock()
+	}()
+
+	if _, err := os.Stat(out); err == nil {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return false, err
+	}
+	hlsSlots <- struct{}{}
+	defer func() { <-hlsSlots }()
+
+	// ffmpeg writes beside the segment, which only appears once it's whole
+	tmp := out + ".tmp"
+	defer os.Remove(tmp)
+	ctx, cancel := context.WithTimeout(context.Background(), hlsTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ffmpeg, hlsSegmentArgs(filePath, tmp, n, length)...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return false, fmt.Errorf("%v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return true, os.Rename(tmp, out)
+}
+
+// prunehlscache removes the least recently served segments below dir until
+// they take no more than limit bytes, keeping keep, the one just made, and
+// removes the folders that leaves empty. a limit of 0 keeps everything. only
+// segments are counted and removed, in case dir holds anything else.
+func pruneHLSCache(dir string, limit int64, keep string) {
+	if limit <= 0 {
+		return
+	}
+	hlsPruning.Lock()
+	defer hlsPruning.Unlock()
+
+	type segment struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var segments []segment
+	var total int64
+	folders, _ := os.ReadDir(dir)
+	for _, folder := range folders {
+		if !folder.IsDir() {
+			continue
+		}
+		entries, _ := os.ReadDir(filepath.Join(dir, folder.Name()))
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry.Name(), ".ts") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			segments = append(segments, segment{filepath.Join(dir, folder.Name(), entry.Name()), info.Size(), info.ModTime()})
+			total += info.Size()
+		}
+	}
+	if total <= limit {
+		return
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].modTime.Before(segments[j].modTime) })
+	for _, seg := range segments {
+		if total <= limit {
+			break
+		}
+		if seg.path == keep {
+			continue
+		}
+		if err := os.Remove(seg.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Println("Error removing an old hls segment:", err)
+			continue
+		}
+		total -= seg.size
+
+		// fails while the folder still has segments, which is what's wanted
+		os.Remove(filepath.Dir(seg.path))
+	}
+}
+
+// handlehls serves a video as hls, for safari and tvs that want it, at
+// /hls/{path}/master.m3u8. the playlists are written from the video's length,
+// and each segment is converted the first time it's asked for and kept in
+// HLSCacheDir.
+func (s *server) handleHLS(w http.ResponseWriter, r *http.Request) {
+	urlPath, name := path.Split(strings.TrimPrefix(r.URL.Path, "/hls"))
+	urlPath = strings.TrimSuffix(urlPath, "/")
+	if urlPath == "" || (!s.cfg.Server.ShowHidden && hasHiddenSegment(urlPath)) {
+		s.notFound(w, r)
+		return
+	}
+
+	// ffmpeg reads from disk, so videos inside archives can't be streamed
+	config, filePath, ok := s.locate(r, urlPath)
+	if !ok || isArchive(config.Directory) || !isAllowedFileType(urlPath, config.FileTypes) || (MediaFile{Path: urlPath}).Kind() != kindVideo {
+		s.notFound(w, r)
+		return
+	}
+	if !s.authorize(w, r, config) {
+		return
+	}
+	if !allowsTranscode(config, "mp4") {
+		http.Error(w, "video is not one of this category's TranscodeFormats", http.StatusForbidden)
+		return
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil || !info.Mode().IsRegular() {
+		s.notFound(w, r)
+		return
+	}
+
+	// the segments are listed up front, which takes knowing how long the video is
+	duration := durations.get(filePath, info, probeDuration)
+	if duration <= 0 {
+		http.Error(w, "the length of this video isn't known, which hls needs", http.StatusNotFound)
+		return
+	}
+	segments := hlsSegments(duration)
+
+	switch {
+	case name == hlsMaster:
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		bandwidth := int64(float64(info.Size()*8) / duration.Seconds())
+		fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-STREAM-INF:BANDWIDTH=%d\n%s\n", bandwidth, hlsIndex)
+	case name == hlsIndex:
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		out := bufio.NewWriter(w)
+		fmt.Fprintf(out, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n",
+			int(math.Ceil(hlsSegmentLength.Seconds())))
+		for n := 0; n < segments; n++ {
+			fmt.Fprintf(out, "#EXTINF:%.3f,\n%d.ts\n", hlsLength(duration, n).Seconds(), n)
+		}
+		out.WriteString("#EXT-X-ENDLIST\n")
+		if err := out.Flush(); err != nil {
+			log.Println("Error writing playlist:", err)
+		}
+	case strings.HasSuffix(name, ".ts"):
+		n, err := strconv.Atoi(strings.TrimSuffix(name, ".ts"))
+		if err != nil || n < 0 || n >= segments || strconv.Itoa(n)+".ts" != name {
+			s.notFound(w, r)
+			return
+		}
+		ffmpeg, ok := ffmpegPath(s.cfg.Server)
+		if !ok {
+			http.Error(w, "ffmpeg is not installed", http.StatusServiceUnavailable)
+			return
+		}
+		segment := hlsSegmentPath(s.cfg.Server, filePath, info, n)
+		made, err := makeHLSSegment(ffmpeg, filePath, segment, n, hlsLength(duration, n))
+		if err != nil {
+			log.Printf("Error making hls segment %d of %s: %v", n, filePath, err)
+			http.Error(w, "could not make the segment", http.StatusInternalServerError)
+			return
+		}
+
+		// the modification time marks when a segment was last served, so the
+		// ones nobody's watching go first once a new one outgrows HLSCacheSize
+		if made {
+			pruneHLSCache(hlsCacheDir(s.cfg.Server), s.cfg.Server.HLSCacheSize, segment)
+		} else {
+			now := time.Now()
+			os.Chtimes(segment, now, now)
+		}
+		w.Header().Set("Content-Type", "video/mp2t")
+		http.ServeFile(w, r, segment)
+	default:
+		s.notFound(w, r)
+	}
+}
+
+// hlslength returns how long segment n of a video of the given length is.
+func hlsLength(duration time.Duration, n int) time.Duration {
+	if rest := duration - time.Duration(n)*hlsSegmentLength; rest < hlsSegmentLength {
+		return rest
+	}
+	return hlsSegmentLength
+}
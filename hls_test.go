@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPruneHLSCache(t *testing.T) {
+	base := time.Now().Add(-time.Hour)
+
+	// ten-byte segments, each served a minute after the one before
+	cache := func(t *testing.T) string {
+		dir := t.TempDir()
+		for i, name := range []string{"a/0.ts", "b/0.ts", "a/1.ts", "c/0.ts"} {
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			when := base.Add(time.Duration(i) * time.Minute)
+			if err := os.Chtimes(path, when, when); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// nothing but segments is counted or removed
+		testTreeAt(t, dir, "notes.txt", "a/1.ts.tmp")
+		return dir
+	}
+	tests := []struct {
+		name  string
+		limit int64
+		keep  string
+		want  []string
+	}{
+		{"under the limit", 40, "", []string{"a/0.ts", "b/0.ts", "a/1.ts", "c/0.ts"}},
+		{"no limit", 0, "", []string{"a/0.ts", "b/0.ts", "a/1.ts", "c/0.ts"}},
+		{"oldest first", 25, "", []string{"a/1.ts", "c/0.ts"}},
+		{"the new segment stays", 10, "a/0.ts", []string{"a/0.ts"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := cache(t)
+			keep := ""
+			if tt.keep != "" {
+				keep = filepath.Join(dir, filepath.FromSlash(tt.keep))
+			}
+			pruneHLSCache(dir, tt.limit, keep)
+			want := make(map[string]bool)
+			for _, name := range tt.want {
+				want[name] = true
+			}
+
+			for _, name := range []string{"a/0.ts", "b/0.ts", "a/1.ts", "c/0.ts"} {
+				_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+				if kept := err == nil; kept != want[name] {
+					t.Errorf("%s kept = %v, want %v", name, kept, !kept)
+				}
+			}
+			for _, name := range []string{"notes.txt", "a/1.ts.tmp"} {
+				if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+					t.Errorf("%s was removed", name)
+				}
+			}
+
+			// a folder left without segments goes too
+			if _, err := os.Stat(filepath.Join(dir, "b")); (err == nil) != want["b/0.ts"] {
+				t.Errorf("b exists = %v with its segment kept = %v", err == nil, want["b/0.ts"])
+			}
+		})
+	}
+}
+
+func TestHLSUsesTheCachedDuration(t *testing.T) {
+	captureLog(t)
+	dir := testTree(t, "film.mp4")
+	h := testServer(t, "[Server]\nHLS=true\n[Films]\nDirectory="+dir+"\nFileTypes=.mp4\n").routes()
+
+	// the file isn't a real mp4, so only the cache knows how long it is
+	path := filepath.Join(dir, "film.mp4")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	durations.put(path, info.Size(), info.ModTime(), 13*time.Second)
+
+	w := request(h, http.MethodGet, "/hls/film.mp4/index.m3u8")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET index.m3u8 = %d:\n%s", w.Code, w.Body)
+	}
+	if got := strings.Count(w.Body.String(), "#EXTINF:"); got != 3 {
+		t.Errorf("the playlist has %d segments, want 3 for 13 seconds:\n%s", got, w.Body)
+	}
+}
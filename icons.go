@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// iconkeyprefix marks a [Server] key as the icon for an extension, so
+// Icon.cbz=book shows .cbz files with the book icon.
+const iconKeyPrefix = "Icon."
+
+// fallbackicon is shown for files whose type has no icon.
+const fallbackIcon = "file"
+
+// validiconname keeps icon names usable as a single css class.
+var validIconName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// fileicons maps an extension to an icon name. every extension gets the icon of
+// its kind unless the config names another, which is only changed at startup.
+var fileIcons = map[string]string{}
+
+// registericons adds the icons from Icon. keys on top of the kinds' icons.
+func registerIcons(extra map[string]string) {
+	for ext, name := range extra {
+		fileIcons[ext] = name
+	}
+}
+
+// fileicon returns the icon name for the file at p: the configured icon for its
+// extension, then the icon for its kind, like audio or video, then a plain file.
+func fileIcon(p string) string {
+	ext := normalizeFileType(path.Ext(p))
+	if name, ok := fileIcons[ext]; ok {
+		return name
+	}
+	if kind := knownMediaTypes[ext].Kind; kind != "" {
+		return string(kind)
+	}
+	return fallbackIcon
+}
+
+// iconclass returns the css classes for a file's icon, for the icon template func.
+func iconClass(p string) string {
+	return "icon icon-" + fileIcon(p)
+}
+
+// parseiconname checks the value of an Icon. key.
+func parseIconName(key string, value configValue) (string, error) {
+	name, err := value.scalar(key)
+	if err != nil {
+		return "", err
+	}
+	if !validIconName.MatchString(name) {
+		return "", fmt.Errorf("invalid value for %s: %q", key, name)
+	}
+	return name, nil
+}
+
+// iconstyle draws the built-in icons as characters, so they work offline and
+// need no icon font. an icon name from the config that isn't one of these can
+// be drawn with CustomCSS, as .icon-name::before.
+const iconStyle = `{{define "icons"}}<style>
+        .icon {
+            display: inline-block;
+            width: 1.5em;
+            font-style: normal;
+            text-align: center;
+        }
+
+        .icon::before { content: "\1F4C4"; }
+        .icon-audio::before { content: "\1F3B5"; }
+        .icon-video::before { content: "\1F3AC"; }
+        .icon-image::before { content: "\1F5BC"; }
+        .icon-playlist::before { content: "\1F4C3"; }
+        .icon-document::before { content: "\1F4D6"; }
+        .icon-subtitle::before { content: "\1F4AC"; }
+    </style>{{end}}`
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fileid returns the stable id of a file in a category. it hashes the file's
+// name and size rather than its path, so the id survives moving the file to
+// another folder in the same category.
+func fileID(category string, file MediaFile) string {
+	sum := sha256.Sum256([]byte(category + "\x00" + file.Name + "\x00" + strconv.FormatInt(file.Size, 10)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// assignids gives every file in a category its stable id.
+func assignIDs(category string, files []MediaFile) {
+	for i := range files {
+		files[i].ID = fileID(category, files[i])
+	}
+}
+
+// idindex maps each category's file ids to their paths, as of the category's
+// last walk.
+type idIndex struct {
+	mu         sync.Mutex
+	categories map[string]map[string]string
+}
+
+// fileids is filled in by every walk when StableIDs is enabled.
+var fileIDs = &idIndex{categories: make(map[string]map[string]string)}
+
+// record replaces the ids of the walked categories, leaving the others alone.
+// synthetic groups have no directory and only repeat files from real ones.
+func (idx *idIndex) record(groups []MediaGroup) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, group := range groups {
+		if group.Directory == "" {
+			continue
+		}
+		paths := make(map[string]string, len(group.Files))
+		for _, file := range group.Files {
+			if _, ok := paths[file.ID]; !ok {
+				paths[file.ID] = file.Path
+			}
+		}
+		idx.categories[group.Name] = paths
+	}
+}
+
+// lookup finds the category and path of an id among the given categories.
+func (idx *idIndex) lookup(configs []CategoryConfig, id string) (CategoryConfig, string, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, config := range configs {
+		if relPath, ok := idx.categories[config.Name][id]; ok {
+			return config, relPath, true
+		}
+	}
+	return CategoryConfig{}, "", false
+}
+
+// handleid serves a file by its stable id at /id/{id}. an id that isn't known
+// yet, or whose file moved since the last walk, triggers a fresh walk.
+func (s *server) handleID(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/id/")
+	config, relPath, ok := fileIDs.lookup(s.cfg.Categories, id)
+	if !ok || !s.idFileExists(config, relPath) {
+		if _, err := buildMediaList(r.Context(), s.cfg.Categories, s.cfg.Server); err != nil {
+			message, status := libraryError(err)
+			http.Error(w, message, status)
+			return
+		}
+		config, relPath, ok = fileIDs.lookup(s.cfg.Categories, id)
+	}
+	if !ok || !config.visible() {
+		s.notFound(w, r)
+		return
+	}
+	if !s.authorize(w, r, config) {
+		return
+	}
+
+	// serve the file from its own category, even if another one has the same path
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = "/" + relPath
+	if !s.serveFrom(w, r2, config) {
+		s.notFound(w, r)
+	}
+}
+
+// idfileexists reports whether the file an id was last seen at is still there.
+func (s *server) idFileExists(config CategoryConfig, relPath string) bool {
+	config = config.partWith(relPath)
+	filePath, ok := resolveInCategory(config.Directory, relPath)
+	return ok && s.hasFile(config, filePath, relPath)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// libraryindex keeps every category's walked group in memory, so requests
+// read the library instead of walking it. it's scanned once at startup and
+// again every IndexInterval in the background; a collection isn't kept, it's
+// put together from its sources' groups when asked for.
+type libraryIndex struct {
+	interval time.Duration
+
+	// mu guards the categories and settings too, which a reload swaps
+	mu      sync.RWMutex
+	configs []CategoryConfig
+	server  ServerConfig
+	groups  map[string]MediaGroup
+
+	// ready is closed once every category has a group, after the first scan
+	// or straight away when the library database had them all
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+// library is the index requests read from, nil when IndexInterval isn't set
+// and every request walks the directories itself.
+var library *libraryIndex
+
+// newlibraryindex creates an index of the categories that aren't collections.
+// nothing is scanned until run.
+func newLibraryIndex(configs []CategoryConfig, server ServerConfig) *libraryIndex {
+	return &libraryIndex{
+		configs:  indexedCategories(configs),
+		server:   server,
+		interval: server.IndexInterval,
+		groups:   make(map[string]MediaGroup),
+		ready:    make(chan struct{}),
+	}
+}
+
+// indexedcategories returns the categories an index keeps, the ones that
+// aren't collections.
+func indexedCategories(configs []CategoryConfig) []CategoryConfig {
+	var indexed []CategoryConfig
+	for _, config := range configs {
+		if !isCollection(config) {
+			indexed = append(indexed, config)
+		}
+	}
+	return indexed
+}
+
+// reconfigure swaps in the categories and settings of a reloaded config and
+// returns the categories that need scanning again. the groups of categories
+// that didn't change are kept and those of removed ones dropped; when the
+// settings changed every category is scanned again, keeping its old group
+// until then.
+func (idx *libraryIndex) reconfigure(configs []CategoryConfig, server ServerConfig) []CategoryConfig {
+	if idx == nil {
+		return nil
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	old := make(map[string]CategoryConfig)
+	for _, config := range idx.configs {
+		old[config.Name] = config
+	}
+	sameServer := reflect.DeepEqual(idx.server, server)
+	idx.configs, idx.server = indexedCategories(configs), server
+
+	var stale []CategoryConfig
+	groups := make(map[string]MediaGroup)
+	for _, config := range idx.configs {
+		group, ok := idx.groups[config.Name]
+		if ok {
+			groups[config.Name] = group
+		}
+		if !ok || !sameServer || !reflect.DeepEqual(old[config.Name], config) {
+			stale = append(stale, config)
+		}
+	}
+	idx.groups = groups
+	return stale
+}
+
+// settings returns the categories and settings the index is scanning with.
+func (idx *libraryIndex) settings() ([]CategoryConfig, ServerConfig) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.configs, idx.server
+}
+
+// seed fills the index with groups from the library database, so requests are
+// answered from them while the first scan catches up. the categories the
+// database doesn't have still wait for the scan.
+func (idx *libraryIndex) seed(groups map[string]MediaGroup) {
+	idx.mu.Lock()
+	for name, group := range groups {
+		idx.groups[name] = group
+	}
+	all := true
+	for _, config := range idx.configs {
+		if _, ok := idx.groups[config.Name]; !ok {
+			all = false
+		}
+	}
+	idx.mu.Unlock()
+	if all {
+		idx.readyOnce.Do(func() { close(idx.ready) })
+	}
+}
+
+// run scans the library, then rescans it every interval until ctx is done.
+func (idx *libraryIndex) run(ctx context.Context) {
+	start := time.Now()
+	configs, _ := idx.settings()
+	idx.scan(ctx, configs)
+	idx.readyOnce.Do(func() { close(idx.ready) })
+	log.Printf("Indexed %d categories in %v", len(configs), time.Since(start).Round(time.Millisecond))
+
+	ticker := time.NewTicker(idx.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			configs, _ := idx.settings()
+			idx.scan(ctx, configs)
+		}
+	}
+}
+
+// scan walks the categories, WalkConcurrency at a time, and swaps in each
+// group as soon as it's done. a walk that fails keeps the group from the last
+// scan, so a slow disk doesn't empty the listing. open websockets are told
+// when anything changed.
+func (idx *libraryIndex) scan(ctx context.Context, configs []CategoryConfig) {
+	_, server := idx.settings()
+	concurrency := server.WalkConcurrency
+	if concurrency < 1 {
+		concurrency = defaultWalkConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	var changedMu sync.Mutex
+	changed := false
+	for _, config := range configs {
+		wg.Add(1)
+		go func(config CategoryConfig) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if idx.rescan(ctx, config) {
+				changedMu.Lock()
+				changed = true
+				changedMu.Unlock()
+			}
+		}(config)
+	}
+	wg.Wait()
+
+	if changed {
+		libraryEvents.publish()
+	}
+}
+
+// rescan walks one category again and swaps in its group, reporting whether
+// its files changed since the last walk. a walk that a reload overtook, of a
+// category that was since removed or changed, is thrown away.
+func (idx *libraryIndex) rescan(ctx context.Context, config CategoryConfig) bool {
+	_, server := idx.settings()
+	group, err := scanCategory(ctx, config, server)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Error indexing %s: %v", config.Name, err)
+		}
+		return false
+	}
+	group.tally()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if !idx.keeps(config) || !reflect.DeepEqual(idx.server, server) {
+		return false
+	}
+	database.record(config, group)
+	old, had := idx.groups[config.Name]
+	idx.groups[config.Name] = group
+	return !had || !sameFiles(old, group)
+}
+
+// keeps reports whether config is one of the index's categories as it stands.
+// the caller holds mu.
+func (idx *libraryIndex) keeps(config CategoryConfig) bool {
+	for _, c := range idx.configs {
+		if c.Name == config.Name {
+			return reflect.DeepEqual(c, config)
+		}
+	}
+	return false
+}
+
+// group returns a category's group from the index, waiting for the first scan
+// to finish if it hasn't yet. it reports false for a category the index
+// doesn't keep, like a collection, which is then walked as usual.
+func (idx *libraryIndex) group(ctx context.Context, config CategoryConfig) (MediaGroup, bool, error) {
+	if idx == nil || isCollection(config) {
+		return MediaGroup{}, false, nil
+	}
+	select {
+	case <-idx.ready:
+	case <-ctx.Done():
+		return MediaGroup{}, true, ctx.Err()
+	}
+	idx.mu.RLock()
+	group, ok := idx.groups[config.Name]
+	idx.mu.RUnlock()
+	return group, ok, nil
+}
+
+// samefiles reports whether two walks of a category found the same files,
+// going by their paths, sizes and modification times, and the same cover and
+// description.
+func sameFiles(a, b MediaGroup) bool {
+	if a.Offline != b.Offline || a.Cover != b.Cover || a.Description != b.Description || len(a.Files) != len(b.Files) {
+		return false
+	}
+	for i := range a.Files {
+		x, y := a.Files[i], b.Files[i]
+		if x.Path != y.Path || x.Size != y.Size || !x.ModTime.Equal(y.ModTime) {
+			return false
+		}
+	}
+	return true
+}
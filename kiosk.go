@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// kioskdisabledroutes are the endpoints kiosk mode leaves out, listed in the
+// startup log so it's clear what a public screen can't reach.
+var kioskDisabledRoutes = []string{"/admin/config", "/download/", "/transcode/", "/hls/", "/browse/", "/index/", "/duplicates", "/checksums/", "/playlists", "/api/playlists"}
+
+// applykiosk locks the config down for a public display: only KioskCategory is
+// served, and the admin, zip download, transcode, hls, browse, duplicates,
+// checksums and playlist endpoints are off. everything else about the category, like its password,
+// still applies.
+func applyKiosk(cfg *Config) error {
+	name := cfg.Server.KioskCategory
+	if name == "" {
+		return errors.New("kiosk mode needs KioskCategory in [Server]")
+	}
+
+	// the other categories are dropped outright, so none of their files can be reached
+	var kiosk []CategoryConfig
+	for _, config := range cfg.Categories {
+		if config.Name == name {
+			kiosk = append(kiosk, config)
+		}
+	}
+	if len(kiosk) == 0 {
+		return fmt.Errorf("KioskCategory %q is not a category in the config", name)
+	}
+	if isCollection(kiosk[0]) {
+		return fmt.Errorf("KioskCategory %q is a collection, which kiosk mode can't serve on its own", name)
+	}
+	cfg.Categories = kiosk
+
+	cfg.Server.Kiosk = true
+	cfg.Server.AdminUser, cfg.Server.AdminPass = "", ""
+	cfg.Server.Transcode = false
+	cfg.Server.HLS = false
+	cfg.Server.PlaylistFile = ""
+	log.Printf("Kiosk mode: only %s is listed, disabled %s", name, strings.Join(kioskDisabledRoutes, ", "))
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"net/http"
+)
+
+// handlelist writes the absolute url of every media file, one per line, at
+// /list.txt, for piping into wget -i or aria2c. ?category=name narrows it to
+// one category.
+func (s *server) handleList(w http.ResponseWriter, r *http.Request) {
+	configs := s.categories(r)
+	if name := r.URL.Query().Get("category"); name != "" {
+		config, ok := s.category(name)
+		if !ok {
+			s.notFound(w, r)
+			return
+		}
+		if !s.authorize(w, r, config) {
+			return
+		}
+		configs = []CategoryConfig{config}
+	}
+
+	groups, err := buildMediaList(r.Context(), configs, s.cfg.Server)
+	if err != nil {
+		message, status := libraryError(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// the same file can be reached from a collection, so only list each url once
+	root := rootURL(r, s.cfg.Server.BasePath)
+	seen := make(map[string]bool)
+	out := bufio.NewWriter(w)
+	for _, group := range groups {
+		for _, file := range group.Files {
+			link := root
+			link.Path += file.Path
+			if seen[link.Path] {
+				continue
+			}
+			seen[link.Path] = true
+			out.WriteString(link.String() + "\n")
+		}
+	}
+	if err := out.Flush(); err != nil {
+		log.Println("Error writing list:", err)
+	}
+}
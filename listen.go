@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// defaultlisten is the address used when neither the config nor a flag sets one.
+const defaultListen = ":8080"
+
+// unixprefix marks a listen address as a unix domain socket path.
+const unixPrefix = "unix:"
+
+// socketmode lets the owner and group, such as a reverse proxy's, use the socket.
+const socketMode = 0660
+
+// listenflags are the command line flags that pick the listen address: -listen
+// for the whole address, or -addr and -port for either half of it.
+type listenFlags struct {
+	listen string
+	addr   string
+	port   string
+}
+
+// check rejects flag values that can't make an address, before anything is
+// loaded or bound.
+func (f listenFlags) check() error {
+	if f.listen != "" && (f.addr != "" || f.port != "") {
+		return errors.New("-listen sets the whole address, use it or -addr and -port, not both")
+	}
+	if f.port != "" {
+		n, err := strconv.Atoi(f.port)
+		if err != nil || n < 0 || n > 65535 {
+			return fmt.Errorf("invalid -port %q, expected a number from 0 to 65535", f.port)
+		}
+	}
+	if f.addr != "" {
+
+		// a host, an ip, or an interface name, with ipv6 allowed in brackets
+		host := strings.TrimSuffix(strings.TrimPrefix(f.addr, "["), "]")
+		if net.ParseIP(host) == nil && strings.ContainsAny(host, ":/ \t[]") {
+			return fmt.Errorf("invalid -addr %q, expected a host name, an ip address or an interface", f.addr)
+		}
+	}
+	return nil
+}
+
+// address returns the address to listen on. -listen wins over the configured
+// address, which wins over the default, and -addr and -port then replace the
+// host or the port of it, so -port 9090 keeps a configured 127.0.0.1.
+func (f listenFlags) address(configured string) (string, error) {
+	addr := configured
+	if f.listen != "" {
+		addr = f.listen
+	}
+	if addr == "" {
+		addr = defaultListen
+	}
+	if f.addr == "" && f.port == "" {
+		return addr, nil
+	}
+	if strings.HasPrefix(addr, unixPrefix) {
+		return "", fmt.Errorf("-addr and -port don't apply to the unix socket %s", addr)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("listen address %s: %w", addr, err)
+	}
+	if f.addr != "" {
+		host = strings.TrimSuffix(strings.TrimPrefix(f.addr, "["), "]")
+	}
+	if f.port != "" {
+		port = f.port
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// listen opens the listener for addr, turning the common failures into
+// messages that say how to fix them. an address like unix:/run/chill.sock
+// listens on a unix domain socket instead of a tcp port.
+func listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		return listenUnix(path)
+	}
+
+	addr, err := resolveInterface(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err == nil {
+		return ln, nil
+	}
+
+	// a second instance or another server already holds the port
+	if errors.Is(err, syscall.EADDRINUSE) {
+		_, port, _ := net.SplitHostPort(addr)
+		return nil, fmt.Errorf("port %s is already in use; set Listen in config or use -port", port)
+	}
+
+	return nil, err
+}
+
+// listenunix listens on a unix domain socket, replacing a socket left behind by
+// an earlier run.
+func listenUnix(path string) (net.Listener, error) {
+
+	// only remove the old file if it really is a socket, never a regular file
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// resolveinterface lets the host part of addr name a network interface, such as
+// eth0:8080, binding to that interface's address. hosts that are ip literals or
+// don't name an interface are left for the resolver.
+func resolveInterface(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	iface, err := net.InterfaceByName(host)
+	if err != nil {
+		return addr, nil
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("interface %s: %w", host, err)
+	}
+
+	// prefer ipv4, then a global ipv6 address, and only then a link-local one,
+	// which needs the interface as its zone
+	var v6, linkLocal string
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		switch {
+		case ip.To4() != nil:
+			return net.JoinHostPort(ip.String(), port), nil
+		case ip.IsLinkLocalUnicast():
+			if linkLocal == "" {
+				linkLocal = ip.String() + "%" + iface.Name
+			}
+		case v6 == "":
+			v6 = ip.String()
+		}
+	}
+	if v6 == "" {
+		v6 = linkLocal
+	}
+	if v6 == "" {
+		return "", fmt.Errorf("interface %s has no addresses", host)
+	}
+	return net.JoinHostPort(v6, port), nil
+}
+
+// listenurl returns a url for the banner that a browser can open, or the socket
+// address when listening on a unix socket. the url is built from the bound
+// address, so a port of 0 shows the port that was picked.
+func listenURL(ln net.Listener, basePath string, secure bool) string {
+	addr, ok := ln.Addr().(*net.TCPAddr)
+	if !ok {
+		return unixPrefix + ln.Addr().String()
+	}
+
+	// an unspecified host means every interface, so point at this machine
+	host := addr.IP.String()
+	if addr.IP.IsUnspecified() {
+		host = "localhost"
+	} else if addr.Zone != "" {
+		host += "%25" + addr.Zone
+	}
+
+	scheme := "http://"
+	if secure {
+		scheme = "https://"
+	}
+
+	// joinhostport brackets ipv6 literals, like http://[::1]:8080/
+	return scheme + net.JoinHostPort(host, strconv.Itoa(addr.Port)) + basePath + "/"
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// addrListener is a listener that only knows its address, for listenurl.
+type addrListener struct {
+	net.Listener
+	addr net.Addr
+}
+
+func (l addrListener) Addr() net.Addr { return l.addr }
+
+func TestListenOnOneAddress(t *testing.T) {
+	ln, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "chill")
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	// it answers on the address it was given, with the port that was picked
+	url := listenURL(ln, "", false)
+	if url != "http://127.0.0.1:"+strconv.Itoa(port)+"/" {
+		t.Errorf("banner url = %s", url)
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "chill" {
+		t.Errorf("GET %s = %q", url, body)
+	}
+
+	// and not on ::, which a bare :port binds and which ::1 reaches
+	addr := net.JoinHostPort("::1", strconv.Itoa(port))
+	if conn, err := net.DialTimeout("tcp6", addr, time.Second); err == nil {
+		conn.Close()
+		t.Errorf("%s accepted a connection, only 127.0.0.1 should", addr)
+	}
+}
+
+func TestListenURL(t *testing.T) {
+	tests := []struct {
+		addr   net.Addr
+		base   string
+		secure bool
+		want   string
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080}, "", false, "http://127.0.0.1:8080/"},
+		{&net.TCPAddr{IP: net.ParseIP("::1"), Port: 8080}, "", false, "http://[::1]:8080/"},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::5"), Port: 443}, "/media", true, "https://[2001:db8::5]:443/media/"},
+		{&net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 8080, Zone: "eth0"}, "", false, "http://[fe80::1%25eth0]:8080/"},
+		{&net.TCPAddr{IP: net.IPv4zero, Port: 8080}, "", false, "http://localhost:8080/"},
+		{&net.TCPAddr{IP: net.IPv6unspecified, Port: 8080}, "", false, "http://localhost:8080/"},
+		{&net.UnixAddr{Name: "/run/chill.sock", Net: "unix"}, "", false, "unix:/run/chill.sock"},
+	}
+	for _, tt := range tests {
+		if got := listenURL(addrListener{addr: tt.addr}, tt.base, tt.secure); got != tt.want {
+			t.Errorf("listenURL(%s) = %s, want %s", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestListenIPv6Literal(t *testing.T) {
+	ln, err := listen("[::1]:0")
+	if err != nil {
+		t.Skip("no ipv6 loopback here:", err)
+	}
+	defer ln.Close()
+	if url := listenURL(ln, "", false); url != "http://[::1]:"+strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)+"/" {
+		t.Errorf("banner url = %s", url)
+	}
+}
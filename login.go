@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// sitelogin is the login the whole server sits behind when AuthUser and
+// AuthHash are set in [Server].
+type siteLogin struct {
+	user string
+	hash bcryptHash
+
+	// checking a bcrypt hash is slow by design, and browsers send the login
+	// with every request, so the logins that matched are remembered by digest
+	mu      sync.Mutex
+	matched map[[sha256.Size]byte]bool
+}
+
+// checksitelogin makes sure AuthUser and AuthHash are set together.
+func checkSiteLogin(server ServerConfig) error {
+	if (server.AuthUser == "") != (server.AuthHash == "") {
+		return errors.New("AuthUser and AuthHash must be set together")
+	}
+	return nil
+}
+
+// newsitelogin returns the server's login, or nil when there isn't one. the
+// hash was checked when the config was read.
+func newSiteLogin(server ServerConfig) *siteLogin {
+	if server.AuthUser == "" || server.AuthHash == "" {
+		return nil
+	}
+	hash, err := parseBcrypt(server.AuthHash)
+	if err != nil {
+		return nil
+	}
+	return &siteLogin{user: server.AuthUser, hash: hash, matched: make(map[[sha256.Size]byte]bool)}
+}
+
+// matches reports whether user and pass are the server's login.
+func (l *siteLogin) matches(user, pass string) bool {
+	if user != l.user {
+		return false
+	}
+	digest := sha256.Sum256([]byte(user + "\x00" + pass))
+	l.mu.Lock()
+	ok := l.matched[digest]
+	l.mu.Unlock()
+	if ok {
+		return true
+	}
+	if !l.hash.matches(pass) {
+		return false
+	}
+	l.mu.Lock()
+	l.matched[digest] = true
+	l.mu.Unlock()
+	return true
+}
+
+// siteloginwarnings names the logins a site login leaves out of reach. a
+// browser sends one login and only the site's gets past it, so a private
+// category or the admin page behind it can only be used when its login is the
+// same user and password.
+func siteLoginWarnings(cfg *Config) []string {
+	login := newSiteLogin(cfg.Server)
+	if login == nil {
+		return nil
+	}
+	var warnings []string
+	for _, config := range cfg.Categories {
+		if config.private() && !login.matches(config.AuthUser, config.AuthPass) {
+			warnings = append(warnings, fmt.Sprintf("category %s has a login other than the site's AuthUser and password, so it can't be opened behind the site login", config.Name))
+		}
+	}
+	if cfg.Server.AdminUser != "" && cfg.Server.AdminPass != "" && !login.matches(cfg.Server.AdminUser, cfg.Server.AdminPass) {
+		warnings = append(warnings, "AdminUser and AdminPass aren't the site's login, so /admin/config can't be opened behind the site login")
+	}
+	return warnings
+}
+
+// requirelogin asks for the server's login before anything is served. only
+// that login gets in, a private category's or the admin's doesn't stand in
+// for it.
+func (s *server) requireLogin(next http.Handler) http.Handler {
+	if s.login == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); ok && s.login.matches(user, pass) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		challenge(w)
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// siteHash is the hash of the site login's password in the login tests.
+const siteHash = "$2b$04$abcdefghijklmnopqrstuu7EJV7kdjBBQxyb0HjTh9KS7.Lah/6CG"
+
+func TestOnlyTheSiteLoginGetsIn(t *testing.T) {
+	public := testTree(t, "cartoon.mp4")
+	private := testTree(t, "film.mkv")
+	h := testServer(t, "[Server]\nAuthUser=family\nAuthHash="+siteHash+"\nAdminUser=admin\nAdminPass=secret\n"+
+		"[Cartoons]\nDirectory="+public+"\nFileTypes=.mp4\n"+
+		"[Late]\nDirectory="+private+"\nFileTypes=.mkv\nAuthUser=grown\nAuthPass=up\n").routes()
+
+	tests := []struct {
+		user, pass string
+		want       int
+	}{
+		{"family", "correct horse battery staple", http.StatusOK},
+		{"family", "wrong", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+
+		// neither a private category's login nor the admin's stands in for the site's
+		{"grown", "up", http.StatusUnauthorized},
+		{"admin", "secret", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		for _, target := range []string{"/", "/cartoon.mp4", "/api/media"} {
+			if w := requestAs(h, http.MethodGet, target, tt.user, tt.pass); w.Code != tt.want {
+				t.Errorf("GET %s as %q = %d, want %d", target, tt.user, w.Code, tt.want)
+			}
+		}
+	}
+}
+
+func TestSiteLoginWarnings(t *testing.T) {
+	site := "[Server]\nAuthUser=family\nAuthHash=" + siteHash + "\n"
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{"no site login", "[Late]\nDirectory=/late\nAuthUser=grown\nAuthPass=up\n", nil},
+		{"public categories", site + "[Music]\nDirectory=/music\n", nil},
+		{"a category with the site's login", site + "[Late]\nDirectory=/late\nAuthUser=family\nAuthPass=correct horse battery staple\n", nil},
+		{"a category with its own login", site + "[Late]\nDirectory=/late\nAuthUser=grown\nAuthPass=up\n", []string{"category Late"}},
+		{"an admin with its own login", site + "AdminUser=admin\nAdminPass=secret\n", []string{"AdminUser"}},
+	}
+	for _, tt := range tests {
+		cfg, err := ParseConfig(strings.NewReader(tt.config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := siteLoginWarnings(cfg)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: warnings %q, want %d", tt.name, got, len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			if !strings.Contains(got[i], want) {
+				t.Errorf("%s: warning %q doesn't name %s", tt.name, got[i], want)
+			}
+		}
+	}
+}
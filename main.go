@@ -1,14 +1,16 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"flag"
 	"fmt"
-	"html/template"
 	"log"
-	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 )
 
 const Ascii = `
@@ -19,124 +21,258 @@ const Ascii = `
 
 const semVerInfo = "v1.0.0"
 
-// mediafile represents a media file with its name and path.
+// mediafile represents a media file with its name, path, size and modification time.
+// duration, title and artist are only filled in when the matching feature is
+// enabled and the file carries that information, and id only with StableIDs.
 type MediaFile struct {
-	Name string
-	Path string
+	ID        string
+	Name      string
+	Path      string
+	Size      int64
+	ModTime   time.Time
+	Duration  time.Duration
+	Title     string
+	Artist    string
+	Album     string
+	Track     int
+	Subtitles []Subtitle
+
+	// albumartist is who an album is filed under, set when it's tagged and
+	// differs from the artist, like on a compilation
+	AlbumArtist string
+}
+
+// displayname returns "artist — title" from the tags when available, falling
+// back to the title alone and then to the file name.
+func (f MediaFile) DisplayName() string {
+	switch {
+	case f.Title != "" && f.Artist != "":
+		return f.Artist + " — " + f.Title
+	case f.Title != "":
+		return f.Title
+	}
+	return f.Name
 }
 
 // mediagroup represents a group of media files within a specific directory.
+// synthetic groups such as recently added have a name but no directory.
 type MediaGroup struct {
-	Directory string
-	Files     []MediaFile
+	Name        string      `json:"name"`
+	Title       string      `json:"title,omitempty"`
+	Directory   string      `json:"-"`
+	Truncated   bool        `json:"truncated,omitempty"`
+	Cover       string      `json:"cover,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Offline     bool        `json:"offline,omitempty"`
+	FileCount   int         `json:"file_count"`
+	TotalSize   int64       `json:"total_bytes"`
+	Files       []MediaFile `json:"files"`
 }
 
-// categoryconfig represents the configuration for a media category.
-type CategoryConfig struct {
-	Name      string
-	Directory string
-	FileTypes []string
+// heading returns the group's title, falling back to its name and then the last
+// element of its directory. the full directory path is never shown to clients.
+func (g MediaGroup) Heading() string {
+	switch {
+	case g.Title != "":
+		return g.Title
+	case g.Name != "":
+		return g.Name
+	}
+	return filepath.Base(g.Directory)
+}
+
+// tally counts the group's files and their sizes. the counts stay with the
+// group when the api hands out its files a page at a time.
+func (g *MediaGroup) tally() {
+	g.FileCount, g.TotalSize = len(g.Files), 0
+	for _, file := range g.Files {
+		g.TotalSize += file.Size
+	}
 }
 
 func main() {
 
-	// define the configuration file path
-	configFile := "config.cfg"
+	// define the configuration file path, the extension selects the format
+	configFile := flag.String("config", "", "path to the config file (.cfg, .toml or .yaml), or - to read it from stdin (default config.cfg, config.toml or config.yaml)")
+	var listening listenFlags
+	flag.StringVar(&listening.listen, "listen", "", "address to listen on, like 127.0.0.1:8080 or unix:/run/chill.sock, overrides Listen in the config")
+	flag.StringVar(&listening.addr, "addr", "", "host, ip or interface to listen on, keeping the configured port")
+	flag.StringVar(&listening.port, "port", "", "port to listen on, keeping the configured host")
+	strict := flag.Bool("strict", false, "warn about FileTypes entries that aren't recognized media types")
+	dryRunFlag := flag.Bool("dry-run", false, "print each category and how many files it matches, then exit")
+	openFlag := flag.Bool("open", false, "open the listing in the default browser once the server is listening")
+	kioskFlag := flag.Bool("kiosk", false, "serve only KioskCategory, with the admin, download, transcode and browse endpoints off")
+	singleFile := flag.String("file", "", "serve just this file at /, without a config")
+	dbFile := flag.String("db", "", "keep the scanned library and play counts in this file, overrides Database in the config")
+	flag.Usage = usage
+	flag.Parse()
+
+	// bad flag values are reported before the config is even read
+	if err := listening.check(); err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if flag.NArg() > 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "unexpected argument %q\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// ctrl-c or a service manager's sigterm shuts down gracefully, and a
+	// second one stops at once
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// -file shares one file and skips the config altogether
+	if *singleFile != "" {
+		set := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if err := checkSingleFile(*singleFile, set); err != nil {
+			log.Fatal(err)
+		}
+		if err := serveSingleFile(ctx, *singleFile, listening, *openFlag); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	// load the media directories from the config file
-	mediaConfigs, err := LoadMediaDirectories(configFile)
+	// load the server settings and media directories from the config file,
+	// whichever format is in the working directory when none is named.
+	// CHILL_ variables override the file, and flags override both
+	loading := configFlags{path: configPath(*configFile), named: *configFile != "", kiosk: *kioskFlag, dbFile: *dbFile}
+	cfg, err := loading.load(os.Environ())
 	if err != nil {
 		log.Fatal("Failed to load media configurations:", err)
 	}
 
-	// create file server handlers for each directory
-	fileServers := make(map[string]http.Handler)
-	for _, config := range mediaConfigs {
-		fileServers[config.Directory] = http.FileServer(http.Dir(config.Directory))
-	}
+	// serve media with playable content types whatever the host's mime database has
+	registerMIMETypes(cfg.Server.MIMETypes)
+	registerIcons(cfg.Server.Icons)
 
-	// create a custom handler for serving the media files and generating the file list
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-
-		// check if the request is a specific file
-		for _, config := range mediaConfigs {
-			filePath := filepath.Join(config.Directory, r.URL.Path[1:])
-			if fileInfo, err := os.Stat(filePath); err == nil && !fileInfo.IsDir() {
-				fs := fileServers[config.Directory]
-				fs.ServeHTTP(w, r)
-				return
-			}
+	// point out likely typos in the file types, without refusing to start
+	if *strict {
+		for _, warning := range unknownFileTypes(cfg.Categories) {
+			log.Println("Warning:", warning)
 		}
+	}
 
-		// generate the list of media from all directories based on the provided mediaconfigs.
-		// each directory is processed separately, and the resulting media files are grouped within mediagroup.
-		fileList := make([]MediaGroup, 0)
-		for _, config := range mediaConfigs {
-			group := MediaGroup{Directory: config.Directory, Files: []MediaFile{}}
-
-			// walk through the files in the directory and its subdirectories
-			err := filepath.Walk(config.Directory, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
+	// show what would be served without starting the server
+	if *dryRunFlag {
+		if err := dryRun(cfg, os.Stdout); err != nil {
+			log.Fatal("Dry run found problems:\n", err)
+		}
+		return
+	}
 
-					// handle the error and continue traversal
-					log.Println("Error accessing file:", err)
-					return nil
-				}
+	// build the handlers from the loaded configuration
+	srv := newServer(cfg)
 
-				// check if the file is not a directory and has an allowed file type
-				if !info.IsDir() && isAllowedFileType(path, config.FileTypes) {
+	// load the playlists up front, so a file that can't be read stops startup
+	if cfg.Server.PlaylistFile != "" {
+		playlists, err := openPlaylists(cfg.Server.PlaylistFile)
+		if err != nil {
+			log.Fatal("Failed to load the playlists:", err)
+		}
+		srv.playlists = playlists
+	}
 
-					// get the relative path to the directory
-					relPath, _ := filepath.Rel(config.Directory, path)
+	// open the access log up front, so a path that can't be written stops startup
+	if cfg.Server.AccessLogFile != "" {
+		accessLog, err := openAccessLog(cfg.Server.AccessLogFile, cfg.Server.AccessLogMaxSize)
+		if err != nil {
+			log.Fatal("Failed to open the access log:", err)
+		}
+		srv.accessLog = accessLog
+	}
 
-					// append the mediafile to the group's files
-					group.Files = append(group.Files, MediaFile{Name: info.Name(), Path: relPath})
-				}
-				return nil
-			})
+	// pick up the last scan and the play counts from the library database
+	if cfg.Server.Database != "" {
+		db, err := openLibraryDB(cfg.Server.Database, cfg.Server)
+		if err != nil {
+			log.Fatal("Failed to open the library database:", err)
+		}
+		db.seed(cfg.Categories, cfg.Server)
+		database = db
+		go database.run(ctx)
+	}
 
-			if err != nil {
+	// keep the library in memory and rescan it in the background, instead of
+	// walking it for every request
+	if cfg.Server.IndexInterval > 0 {
+		library = newLibraryIndex(cfg.Categories, cfg.Server)
+		library.seed(database.walks(cfg.Categories))
+		go library.run(ctx)
+	}
 
-				// handle the error and return an internal server error response
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+	// pick up added, renamed and deleted files as they happen, and a changed
+	// config on SIGHUP or with WatchConfig
+	reloads := newReloader(srv, loading)
+	go reloads.run(ctx)
 
-			// append the group to the list of mediagroup
-			fileList = append(fileList, group)
-		}
+	// the flags win over the environment and the config, which win over the default
+	addr, err := listening.address(cfg.Server.Listen)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		// render the template with the generated list of media groups
-		tmpl, err := template.New("index").Parse(indexTemplate)
-		if err != nil {
+	// a certificate that can't be loaded is reported before binding, like a bad address
+	tlsCfg, err := tlsConfig(cfg.Server)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-			// handle the error and return an internal server error response
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	// bind before printing the banner so a taken port is reported clearly
+	ln, err := listen(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		// prepare the data to be passed to the template
-		data := struct{ Groups []MediaGroup }{Groups: fileList}
+	// start the server on the bound address
+	url := listenURL(ln, cfg.Server.BasePath, tlsCfg != nil)
+	fmt.Println(Ascii + url)
 
-		// execute the template with the provided data and write the response to the client
-		err = tmpl.Execute(w, data)
-		if err != nil {
+	// the socket is already listening, so the browser's request waits for serve
+	if *openFlag {
+		openBrowser(url)
+	}
+	err = runServer(ctx, httpServer(cfg.Server, reloads), ln, tlsCfg, cfg.Server.ShutdownTimeout)
 
-			// handle the error and log it
-			log.Println("Error executing template:", err)
-			return
+	// write out what the requests left behind before exiting
+	if err := database.close(); err != nil {
+		log.Println("Error saving the library database:", err)
+	}
+	if accessLog := reloads.current().accessLog; accessLog != nil {
+		if err := accessLog.close(); err != nil {
+			log.Println("Error closing the access log:", err)
 		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Stopped")
+}
 
-	})
-
-	// start the server on port 8080
-	fmt.Println(Ascii + "http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+// usage prints what chill does and its flags, for -h and bad flags.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [flags]\n\n", filepath.Base(os.Args[0]))
+	fmt.Fprintln(out, "serves the media folders listed in the config file over http.")
+	fmt.Fprintf(out, "the config is read from config.cfg, config.toml or config.yaml in the working directory unless -config says otherwise.\n\nflags:\n")
+	flag.PrintDefaults()
 }
 
 // check if the file has an allowed media file type
+// the file types are already normalized at config load, so only the extension
+// needs the same treatment here
 func isAllowedFileType(path string, fileTypes []string) bool {
-	ext := strings.ToLower(filepath.Ext(path))
+	ext := normalizeFileType(filepath.Ext(path))
+	if ext == "" {
+		return false
+	}
 
 	// iterate over each file type in the list
 	for _, fileType := range fileTypes {
@@ -151,92 +287,22 @@ func isAllowedFileType(path string, fileTypes []string) bool {
 	return false
 }
 
-func LoadMediaDirectories(configFile string) ([]CategoryConfig, error) {
-
-	// initialize an empty slice to store the media configurations
-	var mediaConfigs []CategoryConfig
-
-	// open the configuration file
-	file, err := os.Open(configFile)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	// initialize the current category index
-	var currentCategoryIndex int
-
-	// create a scanner to read the file line by line
-	scanner := bufio.NewScanner(file)
-
-	// iterate over each line in the file
-	for scanner.Scan() {
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
-
-		// skip empty lines and lines starting with '#' (comments)
-		if len(line) == 0 || line[0] == '#' {
-			continue
-		}
-
-		// check if the line represents a new category
-		if line[0] == '[' && line[len(line)-1] == ']' {
-			currentCategory := line[1 : len(line)-1]
-			mediaConfigs = append(mediaConfigs, CategoryConfig{Name: currentCategory})
-
-			// create a new categoryconfig for the category
-			currentCategoryIndex = len(mediaConfigs) - 1
-
-			// update the current category index
-		} else {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) != 2 {
-				continue
-			}
-
-			// extract the key and value from the line
-			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
-
-			// process the key-value pair based on the key
-			switch key {
-			case "Directory":
-
-				// set the directory for the current category
-				mediaConfigs[currentCategoryIndex].Directory = value
-			case "FileTypes":
-
-				// split the file types into a slice
-				fileTypes := strings.Split(value, ",")
-				for i := range fileTypes {
-
-					// trim spaces from each file type
-					fileTypes[i] = strings.TrimSpace(fileTypes[i])
-				}
-
-				// set the file types for the current category
-				mediaConfigs[currentCategoryIndex].FileTypes = fileTypes
-			}
-		}
-	}
-
-	// check for any scanner errors
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
-	// return the populated media configurations
-	return mediaConfigs, nil
+// normalizefiletype lowercases an extension so .JPG, .Mp4 and .jpg compare equal.
+// strings.ToLower maps every unicode letter, not just ascii, so the config entries
+// and the extensions on disk go through exactly the same folding.
+func normalizeFileType(ext string) string {
+	return strings.ToLower(strings.TrimSpace(ext))
 }
 
-// html template for rendering the file list
+// html template for rendering the file list. it is split into a header, one
+// group at a time and a footer, so large listings reach the browser as they render.
 const indexTemplate = `
-<!DOCTYPE html>
-<html lang="en">
+{{define "header"}}<!DOCTYPE html>
+<html lang="en"{{if ne .Theme "auto"}} data-bs-theme="{{.Theme}}"{{end}}>
 <head>
     <meta charset="utf-8">
     <meta name="viewport" content="width=device-width, initial-scale=1">
-    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0-alpha3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-KK94CHFLLe+nY2dmCWGMq91rCGa5gtU4mk92HdvYe+M/SXH301p5ILy+dN9+nJOZ" crossorigin="anonymous">
+    {{if .Offline}}<link href="{{.BasePath}}/assets/bootstrap.min.css" rel="stylesheet">{{else}}<link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0-alpha3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-KK94CHFLLe+nY2dmCWGMq91rCGa5gtU4mk92HdvYe+M/SXH301p5ILy+dN9+nJOZ" crossorigin="anonymous">{{end}}
 
     <style>
         @media (orientation: portrait) {
@@ -250,35 +316,567 @@ const indexTemplate = `
                 column-count: 3;
             }
         }
+
+        .description {
+            white-space: pre-line;
+            margin-bottom: 0.25rem;
+        }
+
+        .cover {
+            height: 3rem;
+            width: 3rem;
+            object-fit: cover;
+            border-radius: 0.25rem;
+            vertical-align: middle;
+        }
+
+        #player {
+            position: sticky;
+            bottom: 0;
+            padding: 0.5rem;
+            background: #f8f9fa;
+            border-top: 1px solid #dee2e6;
+        }
+
+        #player audio {
+            width: 100%;
+        }
+
+        #media-list a.playing {
+            font-weight: bold;
+        }
+
+        .thumb {
+            display: none;
+        }
+
+        #media-list.grid ul {
+            display: grid;
+            grid-template-columns: repeat(auto-fill, minmax(12rem, 1fr));
+            gap: 0.75rem;
+            padding-left: 0;
+            list-style: none;
+        }
+
+        #media-list.grid .thumb {
+            display: block;
+            width: 100%;
+            aspect-ratio: 16 / 9;
+            object-fit: cover;
+            border-radius: 0.25rem;
+            background: #000;
+        }
+
+        [data-bs-theme=dark] #player {
+            background: #2b3035;
+            border-top-color: #495057;
+        }
     </style>
-		<title>Chill Media Player</title>
+    {{template "icons"}}
+    {{template "theme" .}}
+    {{if .CustomCSS}}<link href="{{.BasePath}}/assets/custom.css" rel="stylesheet">{{end}}
+		<title>{{.SiteTitle}}</title>
 </head>
 <body>
 <div class="container-fluid">
     <div class="row">
         <div class="col">
-            <h1>Chill Media Player</h1>
+            <h1>{{.SiteTitle}}</h1>
+            {{template "theme-switch"}}
+            <small class="text-muted d-none" id="sort">sort: <a href="#" data-sort="">default</a> · <a href="#" data-sort="name">name</a> · <a href="#" data-sort="size">size</a> · <a href="#" data-sort="date">date</a></small>
+            {{if .Thumbnails}}<small class="text-muted d-none" id="view">view: <a href="#" data-view="">list</a> · <a href="#" data-view="grid">grid</a></small>{{end}}
+            {{if .Tags}}<small><a href="{{.BasePath}}/artists">artists</a> · <a href="{{.BasePath}}/albums">albums</a></small>{{end}}
+            {{if .Playlists}}<small><a href="{{.BasePath}}/playlists">playlists</a> <select id="playlist" class="form-select form-select-sm d-inline-block w-auto d-none" aria-label="the playlist + adds to"></select></small>{{template "playlist-picker" .}}{{end}}
+            <small><a href="{{.BasePath}}/playlist.m3u" title="open everything in a player">m3u</a></small>
+            {{if .Login}}<small><a href="{{.BasePath}}/login">sign in</a></small>{{end}}
+            {{template "search-box" .}}
         </div>
     </div>
     <div class="row">
         <div class="col column-count">
-            <ul>
-                {{range .Groups}}
+            <ul id="media-list">
+                <li id="loading" class="text-muted">Loading…</li>
+{{end}}
+{{define "cover"}}{{if .Cover}}<a href="{{$.BasePath}}/{{.Cover}}" target="_blank"><img src="{{$.BasePath}}/{{.Cover}}" alt="" class="cover" loading="lazy"></a> {{end}}{{end}}
+{{define "error"}}
+                <li><strong>{{.}}</strong></li>
+{{end}}
+{{define "group"}}
+                {{if and .Lazy .Directory}}
                 <li>
-                    <strong>{{.Directory}}</strong>
+                    <details data-category="{{.Name}}">
+                        <summary>{{template "cover" .}}<strong>{{.Heading}}</strong> <span class="badge bg-secondary">{{len .Files}}</span>{{if .Offline}} <small class="text-muted">offline</small>{{end}}{{if .Truncated}} <small class="text-muted">truncated</small>{{end}}</summary>
+                        {{if not $.Kiosk}}<small><a href="{{$.BasePath}}/browse/{{.Name}}/">browse</a></small>{{end}}
+                        <small><a href="{{$.BasePath}}/playlist.m3u?category={{.Name}}" title="open in a player">m3u</a></small>
+                        {{if .Description}}<p class="description text-muted">{{.Description}}</p>{{end}}
+                        <ul></ul>
+                    </details>
+                </li>
+                {{else}}
+                <li>
+                    {{template "cover" .}}<strong>{{.Heading}}</strong> <span class="badge bg-secondary">{{len .Files}}</span>
+                    {{if .Offline}}<small class="text-muted">offline</small>{{end}}
+                    {{if .Truncated}}<small class="text-muted">truncated, only the first {{len .Files}} files are listed</small>{{end}}
+                    {{if and .Directory (not $.Kiosk)}}<small><a href="{{$.BasePath}}/browse/{{.Name}}/">browse</a></small>{{end}}
+                    <small><a href="{{$.BasePath}}/playlist.m3u?category={{.Name}}" title="open in a player">m3u</a></small>
+                    {{if .Description}}<p class="description text-muted">{{.Description}}</p>{{end}}
                     <ul>
                         {{range .Files}}
-                        <li>
-                            <a href="{{.Path}}" name="{{.Path}}" title="{{.Path}}" target="_blank">{{.Name}}</a>
+                        <li data-name="{{.DisplayName}}" data-size="{{.Size}}" data-modtime="{{.ModTime.Unix}}">
+                            {{if and $.Thumbnails (eq .Kind "video")}}<a href="{{$.BasePath}}/{{.Path}}" target="_blank" tabindex="-1"><img class="thumb" data-src="{{$.BasePath}}/thumbs/{{$.Category}}/{{.Path}}.jpg" alt=""></a>{{end}}
+                            <i class="{{icon .Path}}" aria-hidden="true"></i><a href="{{$.BasePath}}/{{.Path}}" name="{{.Path}}" title="{{.Path}}" target="_blank" data-kind="{{.Kind}}">{{.DisplayName}}</a>
+                            {{if and $.Category (or (eq .Kind "audio") (eq .Kind "video"))}}<small><a href="{{$.BasePath}}/play/{{$.Category}}/{{.Path}}">play</a></small>{{end}}
+                            {{if and $.Playlists $.Category (or (eq .Kind "audio") (eq .Kind "video"))}}<button type="button" class="btn btn-link btn-sm p-0 align-baseline add-to-playlist" data-category="{{$.Category}}" data-path="{{.Path}}" title="add to the playlist">+</button>{{end}}
+                            {{if and $.Transcode (eq .Kind "video")}}<small><a href="{{$.BasePath}}/transcode/{{.Path}}" target="_blank">mp4</a></small>{{end}}
+                            {{if .ID}}<small><a href="{{$.BasePath}}/id/{{.ID}}" title="permanent link">link</a></small>{{end}}
+                            {{if $.External}}<small><a href="{{$.External.Link .Path}}">open</a></small>{{end}}
+                            {{if .Duration}}<small class="text-muted">{{duration .Duration}}</small>{{end}}
+                            {{range .Subtitles}}<small><a href="{{$.BasePath}}/subtitles/{{.Path}}" target="_blank">cc{{if .Language}} {{.Language}}{{end}}</a></small>{{end}}
                         </li>
                         {{end}}
                     </ul>
                 </li>
                 {{end}}
+{{end}}
+{{define "footer"}}
             </ul>
+            <style>#loading { display: none; }</style>
         </div>
     </div>
 </div>
+<div id="player" class="d-none">
+    <div>
+        <strong id="now-playing"></strong>
+        <button type="button" id="shuffle" class="btn btn-sm btn-outline-secondary" aria-pressed="false">shuffle</button>
+        <button type="button" id="repeat" class="btn btn-sm btn-outline-secondary" aria-pressed="false">repeat</button>
+    </div>
+    <audio id="audio" controls></audio>
+</div>
+<script>
+    // play audio links in the page and carry on with the next file in the same
+    // group. without javascript the links simply open the files.
+    (function () {
+        var list = document.getElementById("media-list");
+        var player = document.getElementById("player");
+        var audio = document.getElementById("audio");
+        var nowPlaying = document.getElementById("now-playing");
+        var shuffleButton = document.getElementById("shuffle");
+        var repeatButton = document.getElementById("repeat");
+        if (!list || !audio.canPlayType) {
+            return;
+        }
+
+        var queue = [];
+        var position = -1;
+        var shuffle = false;
+        var repeat = false;
+
+        function play() {
+            var link = queue[position];
+            Array.prototype.forEach.call(list.querySelectorAll("a.playing"), function (a) {
+                a.classList.remove("playing");
+            });
+            link.classList.add("playing");
+            nowPlaying.textContent = link.textContent;
+            player.classList.remove("d-none");
+            audio.src = link.href;
+            audio.play().catch(function () {});
+        }
+
+        function next() {
+            if (shuffle && queue.length > 1) {
+                var pick = position;
+                while (pick === position) {
+                    pick = Math.floor(Math.random() * queue.length);
+                }
+                position = pick;
+            } else if (position + 1 < queue.length) {
+                position++;
+            } else if (repeat) {
+                position = 0;
+            } else {
+                return;
+            }
+            play();
+        }
+
+        function toggle(button, on) {
+            button.setAttribute("aria-pressed", on ? "true" : "false");
+            button.classList.toggle("active", on);
+            return on;
+        }
+
+        // the queue is the group's audio files in the order they're listed
+        list.addEventListener("click", function (event) {
+            var link = event.target.closest("a[data-kind=audio]");
+            if (!link || event.ctrlKey || event.metaKey || event.shiftKey || event.button !== 0) {
+                return;
+            }
+            event.preventDefault();
+            queue = Array.prototype.slice.call(link.closest("ul").querySelectorAll("a[data-kind=audio]"));
+            position = queue.indexOf(link);
+            play();
+        });
+
+        audio.addEventListener("ended", next);
+        shuffleButton.addEventListener("click", function () {
+            shuffle = toggle(shuffleButton, !shuffle);
+        });
+        repeatButton.addEventListener("click", function () {
+            repeat = toggle(repeatButton, !repeat);
+        });
+    })();
+</script>
+<script>
+    // reload the listing from the api whenever the server reports a library
+    // change, and fill in collapsed categories when they're first opened
+    (function () {
+        var base = {{.BasePath}};
+        var lazy = {{.Lazy}};
+        var transcode = {{.Transcode}};
+        var recentName = {{.RecentName}};
+        var thumbCategories = {{.Thumbnails}} || [];
+        var playlists = {{.Playlists}};
+        var external = {{with .External}}{scheme: {{.Scheme}}, root: {{.Root}}}{{else}}null{{end}};
+        var list = document.getElementById("media-list");
+        var sortLinks = document.getElementById("sort");
+        var viewLinks = document.getElementById("view");
+        if (!list) {
+            return;
+        }
+
+        // the grid swaps the plain list for video thumbnails, which are only
+        // fetched once it's switched on
+        var view = "";
+        try {
+            view = localStorage.getItem("chill_view") || "";
+        } catch (e) {}
+
+        function showThumbs(root) {
+            if (view !== "grid") {
+                return;
+            }
+            Array.prototype.forEach.call(root.querySelectorAll("img.thumb:not([src])"), function (img) {
+                img.src = img.dataset.src;
+            });
+        }
+
+        function applyView() {
+            var grid = view === "grid";
+            list.classList.toggle("grid", grid);
+            list.parentNode.classList.toggle("column-count", !grid);
+            showThumbs(list);
+            Array.prototype.forEach.call(viewLinks.querySelectorAll("a"), function (link) {
+                link.classList.toggle("fw-bold", link.dataset.view === view);
+            });
+        }
+
+        if (viewLinks) {
+            viewLinks.classList.remove("d-none");
+            viewLinks.addEventListener("click", function (event) {
+                if (event.target.dataset.view === undefined) {
+                    return;
+                }
+                event.preventDefault();
+                view = event.target.dataset.view;
+                try {
+                    localStorage.setItem("chill_view", view);
+                } catch (e) {}
+                applyView();
+            });
+            applyView();
+        }
+
+        // the sort order is only applied here, so without javascript the
+        // server's order stays and the links stay hidden
+        var sortKey = "";
+        try {
+            sortKey = localStorage.getItem("chill_sort") || "";
+        } catch (e) {}
+
+        var compare = {
+            name: function (a, b) { return a.dataset.name.localeCompare(b.dataset.name, undefined, {numeric: true, sensitivity: "base"}); },
+            size: function (a, b) { return b.dataset.size - a.dataset.size; },
+            date: function (a, b) { return b.dataset.modtime - a.dataset.modtime; },
+            "": function (a, b) { return a.dataset.order - b.dataset.order; }
+        };
+
+        function sortFiles(files) {
+            var items = Array.prototype.filter.call(files.children, function (item) { return item.dataset.size !== undefined; });
+            items.forEach(function (item, i) {
+                if (item.dataset.order === undefined) {
+                    item.dataset.order = i;
+                }
+            });
+            items.sort(compare[sortKey] || compare[""]).forEach(function (item) { files.appendChild(item); });
+        }
+
+        function sortAll() {
+            Array.prototype.forEach.call(list.querySelectorAll("ul"), sortFiles);
+            Array.prototype.forEach.call(sortLinks.querySelectorAll("a"), function (link) {
+                link.classList.toggle("fw-bold", link.dataset.sort === sortKey);
+            });
+        }
+
+        sortLinks.classList.remove("d-none");
+        sortLinks.addEventListener("click", function (event) {
+            if (event.target.dataset.sort === undefined) {
+                return;
+            }
+            event.preventDefault();
+            sortKey = event.target.dataset.sort;
+            try {
+                localStorage.setItem("chill_sort", sortKey);
+            } catch (e) {}
+            sortAll();
+        });
+        sortAll();
+
+        if (!window.fetch) {
+            return;
+        }
+
+        function formatDuration(seconds) {
+            var total = Math.round(seconds);
+            var h = Math.floor(total / 3600), m = Math.floor(total / 60) % 60, s = total % 60;
+            var pad = function (n) { return n < 10 ? "0" + n : "" + n; };
+            return h > 0 ? h + ":" + pad(m) + ":" + pad(s) : m + ":" + pad(s);
+        }
+
+        function fileItem(file, category) {
+            var entry = document.createElement("li");
+            entry.dataset.name = file.display_name;
+            entry.dataset.size = file.size;
+            entry.dataset.modtime = Math.floor(Date.parse(file.modtime) / 1000) || 0;
+            var link = document.createElement("a");
+            link.href = base + "/" + file.path;
+            link.name = file.path;
+            link.title = file.path;
+            link.target = "_blank";
+            link.dataset.kind = file.kind || "";
+            link.textContent = file.display_name;
+            if (category && file.kind === "video" && thumbCategories.indexOf(category) >= 0) {
+                var thumbLink = document.createElement("a");
+                thumbLink.href = link.href;
+                thumbLink.target = "_blank";
+                thumbLink.tabIndex = -1;
+                var thumb = document.createElement("img");
+                thumb.className = "thumb";
+                thumb.alt = "";
+                thumb.dataset.src = base + "/thumbs/" + encodeURIComponent(category) + "/" + file.path + ".jpg";
+                thumbLink.appendChild(thumb);
+                entry.appendChild(thumbLink);
+            }
+            var icon = document.createElement("i");
+            icon.className = "icon icon-" + (file.icon || "file");
+            icon.setAttribute("aria-hidden", "true");
+            entry.appendChild(icon);
+            entry.appendChild(link);
+            if (category && (file.kind === "audio" || file.kind === "video")) {
+                var player = document.createElement("a");
+                player.href = base + "/play/" + encodeURIComponent(category) + "/" + file.path;
+                player.textContent = "play";
+                var playing = document.createElement("small");
+                playing.appendChild(document.createTextNode(" "));
+                playing.appendChild(player);
+                entry.appendChild(playing);
+            }
+            if (playlists && category && (file.kind === "audio" || file.kind === "video")) {
+                var add = document.createElement("button");
+                add.type = "button";
+                add.className = "btn btn-link btn-sm p-0 align-baseline add-to-playlist";
+                add.dataset.category = category;
+                add.dataset.path = file.path;
+                add.title = "add to the playlist";
+                add.textContent = "+";
+                entry.appendChild(document.createTextNode(" "));
+                entry.appendChild(add);
+            }
+            if (transcode && file.kind === "video") {
+                var converted = document.createElement("a");
+                converted.href = base + "/transcode/" + file.path;
+                converted.target = "_blank";
+                converted.textContent = "mp4";
+                var wrapped = document.createElement("small");
+                wrapped.appendChild(document.createTextNode(" "));
+                wrapped.appendChild(converted);
+                entry.appendChild(wrapped);
+            }
+            if (file.id) {
+                var permalink = document.createElement("a");
+                permalink.href = base + "/id/" + file.id;
+                permalink.title = "permanent link";
+                permalink.textContent = "link";
+                var small = document.createElement("small");
+                small.appendChild(document.createTextNode(" "));
+                small.appendChild(permalink);
+                entry.appendChild(small);
+            }
+            if (external) {
+                var open = document.createElement("a");
+                open.href = external.scheme.split("{url}").join(external.root + file.path.split("/").map(encodeURIComponent).join("/"));
+                open.textContent = "open";
+                var wrap = document.createElement("small");
+                wrap.appendChild(document.createTextNode(" "));
+                wrap.appendChild(open);
+                entry.appendChild(wrap);
+            }
+            if (file.duration) {
+                var length = document.createElement("small");
+                length.className = "text-muted";
+                length.textContent = " " + formatDuration(file.duration);
+                entry.appendChild(length);
+            }
+            (file.subtitles || []).forEach(function (subtitle) {
+                var track = document.createElement("a");
+                track.href = base + "/subtitles/" + subtitle.path;
+                track.target = "_blank";
+                track.textContent = subtitle.language ? "cc " + subtitle.language : "cc";
+                var small = document.createElement("small");
+                small.appendChild(document.createTextNode(" "));
+                small.appendChild(track);
+                entry.appendChild(small);
+            });
+            return entry;
+        }
+
+        function fillFiles(files, items, category) {
+            files.textContent = "";
+            items.forEach(function (file) {
+                files.appendChild(fileItem(file, category));
+            });
+            sortFiles(files);
+            showThumbs(files);
+        }
+
+        function render(groups) {
+            list.textContent = "";
+            groups.forEach(function (group) {
+                var item = document.createElement("li");
+                if (group.cover) {
+                    var coverLink = document.createElement("a");
+                    coverLink.href = base + "/" + group.cover;
+                    coverLink.target = "_blank";
+                    var cover = document.createElement("img");
+                    cover.src = coverLink.href;
+                    cover.alt = "";
+                    cover.className = "cover";
+                    coverLink.appendChild(cover);
+                    item.appendChild(coverLink);
+                    item.appendChild(document.createTextNode(" "));
+                }
+                var heading = document.createElement("strong");
+                heading.textContent = group.title || group.name;
+                item.appendChild(heading);
+
+                var count = document.createElement("span");
+                count.className = "badge bg-secondary";
+                count.textContent = group.files.length;
+                item.appendChild(document.createTextNode(" "));
+                item.appendChild(count);
+                if (group.offline) {
+                    var state = document.createElement("small");
+                    state.className = "text-muted";
+                    state.textContent = " offline";
+                    item.appendChild(state);
+                }
+                if (group.truncated) {
+                    var notice = document.createElement("small");
+                    notice.className = "text-muted";
+                    notice.textContent = " truncated, only the first " + group.files.length + " files are listed";
+                    item.appendChild(notice);
+                }
+                var m3uLink = document.createElement("a");
+                m3uLink.href = base + "/playlist.m3u?category=" + encodeURIComponent(group.name);
+                m3uLink.title = "open in a player";
+                m3uLink.textContent = "m3u";
+                var exported = document.createElement("small");
+                exported.appendChild(document.createTextNode(" "));
+                exported.appendChild(m3uLink);
+                item.appendChild(exported);
+
+                var files = document.createElement("ul");
+                fillFiles(files, group.files, group.name === recentName ? "" : group.name);
+                item.appendChild(files);
+                list.appendChild(item);
+            });
+        }
+
+        function loadCategory(details) {
+            details.dataset.loaded = "true";
+            fetch(base + "/api/media?category=" + encodeURIComponent(details.dataset.category))
+                .then(function (response) { return response.json(); })
+                .then(function (data) {
+                    fillFiles(details.querySelector("ul"), data.groups && data.groups.length ? data.groups[0].files : [], details.dataset.category);
+                })
+                .catch(function () { delete details.dataset.loaded; });
+        }
+
+        // toggle events don't bubble, so listen for them on the way down
+        if (lazy) {
+            list.addEventListener("toggle", function (event) {
+                var details = event.target;
+                if (details.open && details.dataset.category && !details.dataset.loaded) {
+                    loadCategory(details);
+                }
+            }, true);
+        }
+
+        function refresh() {
+
+            // collapsed categories only reload what's open, the rest load when next opened
+            if (lazy) {
+                Array.prototype.forEach.call(list.querySelectorAll("details[data-loaded]"), function (details) {
+                    if (details.open) {
+                        loadCategory(details);
+                    } else {
+                        delete details.dataset.loaded;
+                    }
+                });
+                return;
+            }
+            fetch(base + "/api/media")
+                .then(function (response) { return response.json(); })
+                .then(function (data) { render(data.groups); })
+                .catch(function () {});
+        }
+
+        function connect() {
+            var scheme = location.protocol === "https:" ? "wss:" : "ws:";
+            var socket = new WebSocket(scheme + "//" + location.host + base + "/ws");
+            socket.onmessage = function (event) {
+                if (JSON.parse(event.data).type === "library-changed") {
+                    refresh();
+                }
+            };
+            socket.onclose = function () {
+                setTimeout(connect, 5000);
+            };
+        }
+
+        if (window.WebSocket) {
+            connect();
+        }
+    })();
+</script>
+</body>
+</html>
+{{end}}
+`
+
+// html template for the page shown when a path matches nothing
+const notFoundTemplate = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+		<title>Not Found - {{.SiteTitle}}</title>
+</head>
+<body>
+    <h1>Not Found</h1>
+    <p>Nothing is available at <code>{{.Path}}</code>.</p>
+    <p><a href="{{.BasePath}}/">Back to the media listing</a></p>
 </body>
 </html>
 `
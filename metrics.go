@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsregistry holds the counters and gauges exposed at /metrics.
+type metricsRegistry struct {
+	requestsTotal    atomic.Uint64
+	bytesServed      atomic.Uint64
+	lastWalkDuration atomic.Int64
+
+	mu            sync.Mutex
+	categoryFiles map[string]int
+	caches        []countedCache
+}
+
+// countedcache is a cache whose hits and misses are exposed.
+type countedCache interface {
+	cacheName() string
+	lookups() (hits, misses uint64)
+}
+
+// metrics is the process-wide metrics registry.
+var metrics = &metricsRegistry{categoryFiles: make(map[string]int)}
+
+// observewalk records how long the last media walk took.
+func (m *metricsRegistry) observeWalk(d time.Duration) {
+	m.lastWalkDuration.Store(int64(d))
+}
+
+// setcategoryfiles records the current number of media files in a category.
+func (m *metricsRegistry) setCategoryFiles(category string, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.categoryFiles[category] = count
+}
+
+// keepcategories drops the file counts of categories that aren't in configs,
+// so the ones a reload removed aren't reported any more.
+func (m *metricsRegistry) keepCategories(configs []CategoryConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := make(map[string]int, len(configs))
+	for _, config := range configs {
+		if count, ok := m.categoryFiles[config.Name]; ok {
+			kept[config.Name] = count
+		}
+	}
+	m.categoryFiles = kept
+}
+
+// addcache exposes a cache's hit and miss counts.
+func (m *metricsRegistry) addCache(c countedCache) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.caches = append(m.caches, c)
+}
+
+// middleware counts every request and the bytes written in response to it.
+func (m *metricsRegistry) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.requestsTotal.Add(1)
+		cw := &countingWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+		m.bytesServed.Add(cw.written)
+	})
+}
+
+// servehttp writes the metrics in the prometheus text exposition format.
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(w, "# HELP chill_requests_total Total number of HTTP requests handled.")
+	fmt.Fprintln(w, "# TYPE chill_requests_total counter")
+	fmt.Fprintf(w, "chill_requests_total %d\n", m.requestsTotal.Load())
+
+	fmt.Fprintln(w, "# HELP chill_bytes_served_total Total number of response bytes written.")
+	fmt.Fprintln(w, "# TYPE chill_bytes_served_total counter")
+	fmt.Fprintf(w, "chill_bytes_served_total %d\n", m.bytesServed.Load())
+
+	fmt.Fprintln(w, "# HELP chill_last_walk_duration_seconds Duration of the most recent media walk.")
+	fmt.Fprintln(w, "# TYPE chill_last_walk_duration_seconds gauge")
+	fmt.Fprintf(w, "chill_last_walk_duration_seconds %g\n", time.Duration(m.lastWalkDuration.Load()).Seconds())
+
+	// copy the category counts so the lock isn't held while writing
+	m.mu.Lock()
+	categories := make([]string, 0, len(m.categoryFiles))
+	counts := make(map[string]int, len(m.categoryFiles))
+	for name, count := range m.categoryFiles {
+		categories = append(categories, name)
+		counts[name] = count
+	}
+	caches := append([]countedCache(nil), m.caches...)
+	m.mu.Unlock()
+	sort.Strings(categories)
+	sort.Slice(caches, func(i, j int) bool { return caches[i].cacheName() < caches[j].cacheName() })
+
+	fmt.Fprintln(w, "# HELP chill_media_files Current number of media files per category.")
+	fmt.Fprintln(w, "# TYPE chill_media_files gauge")
+	for _, name := range categories {
+		fmt.Fprintf(w, "chill_media_files{category=%q} %d\n", name, counts[name])
+	}
+
+	fmt.Fprintln(w, "# HELP chill_cache_hits_total Lookups answered from a per-file cache.")
+	fmt.Fprintln(w, "# TYPE chill_cache_hits_total counter")
+	for _, c := range caches {
+		hits, _ := c.lookups()
+		fmt.Fprintf(w, "chill_cache_hits_total{cache=%q} %d\n", c.cacheName(), hits)
+	}
+
+	fmt.Fprintln(w, "# HELP chill_cache_misses_total Lookups a per-file cache had to load, because the file was new or had changed.")
+	fmt.Fprintln(w, "# TYPE chill_cache_misses_total counter")
+	for _, c := range caches {
+		_, misses := c.lookups()
+		fmt.Fprintf(w, "chill_cache_misses_total{cache=%q} %d\n", c.cacheName(), misses)
+	}
+}
+
+// countingwriter wraps a responsewriter and counts the bytes written through it.
+type countingWriter struct {
+	http.ResponseWriter
+	written uint64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(p)
+	cw.written += uint64(n)
+	return n, err
+}
+
+// unwrap exposes the underlying responsewriter to http.ResponseController.
+func (cw *countingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
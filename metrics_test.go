@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// metricValue returns the value of the named series in the /metrics output.
+func metricValue(t *testing.T, series string) int {
+	t.Helper()
+	body := request(metrics, http.MethodGet, "/metrics").Body.String()
+	for _, line := range strings.Split(body, "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				t.Fatalf("%s: %v", series, err)
+			}
+			return n
+		}
+	}
+	t.Fatalf("no %s in:\n%s", series, body)
+	return 0
+}
+
+func TestCacheMetrics(t *testing.T) {
+	dir := testTree(t, "song.mp3")
+	h := testServer(t, "[Server]\nTags=true\n[Music]\nDirectory="+dir+"\nFileTypes=.mp3\n").routes()
+	hits, misses := `chill_cache_hits_total{cache="tags"}`, `chill_cache_misses_total{cache="tags"}`
+
+	// the first listing reads the new file's tags, the second finds them cached
+	hitsBefore, missesBefore := metricValue(t, hits), metricValue(t, misses)
+	request(h, http.MethodGet, "/api/media")
+	if got := metricValue(t, misses) - missesBefore; got != 1 {
+		t.Errorf("the first listing missed %d times, want 1", got)
+	}
+	request(h, http.MethodGet, "/api/media")
+	if got := metricValue(t, hits) - hitsBefore; got != 1 {
+		t.Errorf("the second listing hit %d times, want 1", got)
+	}
+
+	// a changed file has to be read again
+	if err := os.WriteFile(filepath.Join(dir, "song.mp3"), []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	request(h, http.MethodGet, "/api/media")
+	if got := metricValue(t, misses) - missesBefore; got != 2 {
+		t.Errorf("after the change the listings missed %d times, want 2", got)
+	}
+}
+
+func TestReloadDropsRemovedCategories(t *testing.T) {
+	captureLog(t)
+	music := testTree(t, "song.mp3")
+	removed := testTree(t, "gone.mp3", "going.mp3")
+	path := filepath.Join(t.TempDir(), "config.cfg")
+	write := func(config string) {
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("[Music]\nDirectory=" + music + "\nFileTypes=.mp3\n[Removed]\nDirectory=" + removed + "\nFileTypes=.mp3\n")
+	flags := configFlags{path: path, named: true}
+	cfg, err := flags.load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := newReloader(newServer(cfg), flags)
+	request(rl, http.MethodGet, "/api/media")
+	if got := metricValue(t, `chill_media_files{category="Removed"}`); got != 2 {
+		t.Fatalf("Removed has %d files, want 2", got)
+	}
+
+	write("[Music]\nDirectory=" + music + "\nFileTypes=.mp3\n")
+	if err := rl.reload(); err != nil {
+		t.Fatal(err)
+	}
+	body := request(metrics, http.MethodGet, "/metrics").Body.String()
+	if strings.Contains(body, `category="Removed"`) {
+		t.Errorf("the removed category is still counted:\n%s", body)
+	}
+	if !strings.Contains(body, `chill_media_files{category="Music"} 1`) {
+		t.Errorf("the category that stayed lost its count:\n%s", body)
+	}
+}
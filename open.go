@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// openbrowser asks the desktop to open url in the default browser. it's best
+// effort: a missing opener is logged and the server carries on regardless.
+func openBrowser(url string) {
+
+	// a unix socket has no address a browser could open
+	if strings.HasPrefix(url, unixPrefix) {
+		return
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Println("Could not open a browser:", err)
+		return
+	}
+
+	// reap the opener in the background, it may outlive startup
+	go cmd.Wait()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// orderfilename is the file in a directory that lists its files in the order
+// they should be shown, one name per line.
+const orderFileName = ".chillorder"
+
+// orderindex maps a directory, relative to the category, to the file names
+// its order file lists.
+type orderIndex map[string][]string
+
+// readorder reads the names from an order file, skipping blank lines and
+// lines starting with #.
+func readOrder(r io.Reader) []string {
+	var names []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" || name[0] == '#' {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// add reads the order file at relpath, a slash-separated path in the category.
+func (idx orderIndex) add(config CategoryConfig, relPath string) {
+	f, err := openCategoryFile(config, relPath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if names := readOrder(f); len(names) > 0 {
+		idx[path.Dir(relPath)] = names
+	}
+}
+
+// apply puts the files of every directory with an order file in that order.
+// listed files come first and the rest follow in the order they were in. the
+// files keep the slots their directory had, so other directories don't move.
+func (idx orderIndex) apply(files []MediaFile) {
+	for dir, names := range idx {
+		rank := make(map[string]int, len(names))
+		for i, name := range names {
+			if _, ok := rank[name]; !ok {
+				rank[name] = i
+			}
+		}
+
+		var slots []int
+		var listed, unlisted []MediaFile
+		for i, file := range files {
+			if path.Dir(file.Path) != dir {
+				continue
+			}
+			slots = append(slots, i)
+			if _, ok := rank[file.Name]; ok {
+				listed = append(listed, file)
+			} else {
+				unlisted = append(unlisted, file)
+			}
+		}
+
+		sort.SliceStable(listed, func(i, j int) bool { return rank[listed[i].Name] < rank[listed[j].Name] })
+		for i, file := range append(listed, unlisted...) {
+			files[slots[i]] = file
+		}
+	}
+}
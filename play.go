@@ -0,0 +1,211 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// playdata is what the player page renders.
+type playData struct {
+	SiteTitle string
+	BasePath  string
+	Offline   bool
+	Theme     string
+	Category  string
+	Title     string
+	Browse    bool
+	File      MediaFile
+	Video     bool
+	Prev      *playLink
+	Next      *playLink
+	Transcode bool
+	Sprites   bool
+	HLS       bool
+
+	// playlist is set when the file is played from a playlist, which then
+	// decides what comes before and after it
+	Playlist *playlist
+}
+
+// playlink is a file the player links to, with the category it's played from
+// and the playlist it's played on, if any.
+type playLink struct {
+	Category string
+	Playlist string
+	File     MediaFile
+}
+
+// isplayable reports whether a file can go in the player.
+func isPlayable(file MediaFile) bool {
+	kind := file.Kind()
+	return kind == kindAudio || kind == kindVideo
+}
+
+// playcategory returns the category a group's files are played from, or an
+// empty string for recently added, whose files come from every category.
+func playCategory(group MediaGroup) string {
+	if group.Directory == "" && group.Name == recentlyAddedName {
+		return ""
+	}
+	return group.Name
+}
+
+// handleplay shows a single audio or video file in a player at
+// /play/{category}/{path}, with links to the previous and next playable files
+// of the category in the order the listing has them. with ?playlist={id} the
+// links step through the playlist instead.
+func (s *server) handlePlay(w http.ResponseWriter, r *http.Request) {
+	name, relPath, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/play/"), "/")
+	if relPath == "" || (!s.cfg.Server.ShowHidden && hasHiddenSegment("/"+relPath)) {
+		s.notFound(w, r)
+		return
+	}
+	config, ok := s.category(name)
+	if !ok {
+		s.notFound(w, r)
+		return
+	}
+	if !s.authorize(w, r, config) {
+		return
+	}
+
+	ctx, cancel := walkContext(r.Context(), s.cfg.Server)
+	group, err := walkCategory(ctx, config, s.cfg.Server)
+	cancel()
+	if err != nil {
+		message, status := libraryError(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	// the neighbours skip anything the player can't play, like covers or pdfs
+	var playable []MediaFile
+	current := -1
+	for _, file := range group.Files {
+		if !isPlayable(file) {
+			continue
+		}
+		if file.Path == relPath {
+			current = len(playable)
+		}
+		playable = append(playable, file)
+	}
+	if current < 0 {
+		s.notFound(w, r)
+		return
+	}
+
+	title := config.Title
+	if title == "" {
+		title = config.Name
+	}
+	file := playable[current]
+	data := playData{
+		SiteTitle: s.cfg.Server.SiteTitle,
+		BasePath:  s.cfg.Server.BasePath,
+		Offline:   s.cfg.Server.Offline,
+		Theme:     s.theme(w, r),
+		Category:  config.Name,
+		Title:     title,
+		Browse:    !isCollection(config) && !s.cfg.Server.Kiosk,
+		File:      file,
+		Video:     file.Kind() == kindVideo,
+		Transcode: s.cfg.Server.Transcode && !isArchive(config.Directory),
+		HLS:       s.cfg.Server.HLS && !isArchive(config.Directory),
+
+		// a collection's files have their previews under their own category
+		Sprites: s.cfg.Server.Sprites && !isCollection(config) && !isArchive(config.Directory),
+	}
+
+	// a playlist that doesn't have the file is ignored, and the category decides
+	if id := r.URL.Query().Get("playlist"); id != "" && s.playlists != nil {
+		if list, ok := s.playlists.get(id); ok {
+			prev, next, found, err := s.playlistNeighbours(r, list, playlistItem{Category: config.Name, Path: file.Path})
+			if err != nil {
+				message, status := libraryError(err)
+				http.Error(w, message, status)
+				return
+			}
+			if found {
+				data.Prev, data.Next, data.Playlist = prev, next, &list
+			}
+		}
+	}
+	if data.Playlist == nil {
+		if current > 0 {
+			data.Prev = &playLink{Category: config.Name, File: playable[current-1]}
+		}
+		if current+1 < len(playable) {
+			data.Next = &playLink{Category: config.Name, File: playable[current+1]}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.playTmpl.Execute(w, data); err != nil {
+		log.Println("Error executing template:", err)
+	}
+}
+
+// html template for playing a single file
+const playTemplate = `
+<!DOCTYPE html>
+<html lang="en"{{if ne .Theme "auto"}} data-bs-theme="{{.Theme}}"{{end}}>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    {{if .Offline}}<link href="{{.BasePath}}/assets/bootstrap.min.css" rel="stylesheet">{{else}}<link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0-alpha3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-KK94CHFLLe+nY2dmCWGMq91rCGa5gtU4mk92HdvYe+M/SXH301p5ILy+dN9+nJOZ" crossorigin="anonymous">{{end}}
+		<title>{{.File.DisplayName}} - {{.SiteTitle}}</title>
+    <style>
+        #player {
+            width: 100%;
+            max-height: 80vh;
+        }
+    </style>
+    {{template "theme" .}}
+</head>
+<body>
+<div class="container">
+    <p class="mt-3 mb-1"><a href="{{.BasePath}}/">{{.SiteTitle}}</a> / {{with .Playlist}}<a href="{{$.BasePath}}/playlists/{{.ID}}">{{.Name}}</a>{{else}}{{if .Browse}}<a href="{{.BasePath}}/browse/{{.Category}}/">{{.Title}}</a>{{else}}{{.Title}}{{end}}{{end}}</p>
+    <h1 class="h4" id="now-playing">{{.File.DisplayName}}</h1>
+    {{if .Video}}
+    <video id="player" src="{{.BasePath}}/{{.File.Path}}" controls autoplay preload="metadata">
+        {{range .File.Subtitles}}<track kind="subtitles" src="{{$.BasePath}}/subtitles/{{.Path}}"{{if .Language}} srclang="{{.Language}}" label="{{.Language}}"{{else}} label="subtitles"{{end}}>{{end}}
+        {{if .Sprites}}<track kind="metadata" label="thumbnails" src="{{.BasePath}}/thumbs/{{.Category}}/{{.File.Path}}.vtt">{{end}}
+    </video>
+    {{else}}
+    <audio id="player" src="{{.BasePath}}/{{.File.Path}}" controls autoplay preload="metadata"></audio>
+    {{end}}
+    <nav class="d-flex justify-content-between my-2">
+        <span>{{with .Prev}}<a id="prev" href="{{$.BasePath}}/play/{{.Category}}/{{.File.Path}}{{with .Playlist}}?playlist={{.}}{{end}}">&larr; {{.File.DisplayName}}</a>{{end}}</span>
+        <span>{{with .Next}}<a id="next" href="{{$.BasePath}}/play/{{.Category}}/{{.File.Path}}{{with .Playlist}}?playlist={{.}}{{end}}">{{.File.DisplayName}} &rarr;</a>{{end}}</span>
+    </nav>
+    <p><small><a href="{{.BasePath}}/{{.File.Path}}" target="_blank">open the file</a>{{if and .Transcode .Video}} · <a href="{{.BasePath}}/transcode/{{.File.Path}}" target="_blank">mp4</a>{{end}}{{if and .HLS .Video}} · <a id="hls" href="{{.BasePath}}/hls/{{.File.Path}}/master.m3u8">hls</a>{{end}}</small></p>
+</div>
+<script>
+    // carry on with the next file once this one ends
+    (function () {
+        var player = document.getElementById("player");
+        var next = document.getElementById("next");
+        var hls = document.getElementById("hls");
+
+        // a browser that plays hls itself, like safari, falls back to it when
+        // it can't play the file as it is
+        if (player && hls && player.canPlayType("application/vnd.apple.mpegurl")) {
+            player.addEventListener("error", function () {
+                if (player.src !== hls.href) {
+                    player.src = hls.href;
+                    player.play();
+                }
+            });
+        }
+        if (player && next) {
+            player.addEventListener("ended", function () {
+                window.location.href = next.href;
+            });
+        }
+    })();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// m3uline keeps a name on one line of a playlist.
+var m3uLine = strings.NewReplacer("\r", " ", "\n", " ")
+
+// limits for how many files /shuffle.m3u returns
+const (
+	defaultShuffleLimit = 100
+	maxShuffleLimit     = 10000
+)
+
+// writem3u writes files as an extended m3u playlist of absolute urls under
+// root, with each file's length and display name for the player to show. a
+// title names the playlist for players that show one.
+func writeM3U(w http.ResponseWriter, root url.URL, title string, files []MediaFile) {
+	w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+	out := bufio.NewWriter(w)
+	out.WriteString("#EXTM3U\n")
+	if title != "" {
+		fmt.Fprintf(out, "#PLAYLIST:%s\n", m3uLine.Replace(title))
+	}
+	for _, file := range files {
+
+		// -1 is the m3u way of saying the length isn't known
+		seconds := -1
+		if file.Duration > 0 {
+			seconds = int(math.Round(file.Duration.Seconds()))
+		}
+		link := root
+		link.Path += file.Path
+
+		// a newline in a name would end the entry early
+		name := m3uLine.Replace(file.DisplayName())
+		fmt.Fprintf(out, "#EXTINF:%d,%s\n%s\n", seconds, name, link.String())
+	}
+	if err := out.Flush(); err != nil {
+		log.Println("Error writing playlist:", err)
+	}
+}
+
+// handleshuffle returns a random playlist drawn from every category at
+// /shuffle.m3u. it takes audio files unless ?types=.mp3,.flac picks the
+// extensions, and ?limit= sets how many, 100 by default.
+func (s *server) handleShuffle(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultShuffleLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+		if limit > maxShuffleLimit {
+			limit = maxShuffleLimit
+		}
+	}
+	var types []string
+	if v := query.Get("types"); v != "" {
+		types = normalizeFileTypes(strings.Split(v, ","))
+	}
+
+	groups, err := buildMediaList(r.Context(), s.categories(r), s.cfg.Server)
+	if err != nil {
+		message, status := libraryError(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	// a collection repeats files from other categories, so each path is taken once
+	var files []MediaFile
+	seen := make(map[string]bool)
+	for _, group := range groups {
+		for _, file := range group.Files {
+			if seen[file.Path] {
+				continue
+			}
+			if types != nil && !isAllowedFileType(file.Path, types) {
+				continue
+			}
+			if types == nil && file.Kind() != kindAudio {
+				continue
+			}
+			seen[file.Path] = true
+			files = append(files, file)
+		}
+	}
+
+	// a new seed every request, so every request gets a different order
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	random.Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+	if len(files) > limit {
+		files = files[:limit]
+	}
+
+	// nothing should hold on to a shuffle, or every play would be the same
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+		return
+	}
+	writeM3U(w, rootURL(r, s.cfg.Server.BasePath), "", files)
+}
+
+// handleplaylistm3u exports the library as a playlist at /playlist.m3u, or
+// /playlist.m3u8, to open it in a player like vlc or mpv. it has every audio
+// and video file of every category, or one group's with ?category=, or a
+// playlist's from /playlists with ?playlist=.
+func (s *server) handlePlaylistM3U(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var title string
+	var files []MediaFile
+
+	if id := query.Get("playlist"); id != "" {
+		var list playlist
+		ok := false
+		if s.playlists != nil {
+			list, ok = s.playlists.get(id)
+		}
+		if !ok {
+			http.Error(w, "no such playlist", http.StatusNotFound)
+			return
+		}
+		list = s.playlistJSON(r, list)
+		found, err := s.playlistFiles(r, list.Items)
+		if err != nil {
+			message, status := libraryError(err)
+			http.Error(w, message, status)
+			return
+		}
+
+		// a file that's gone is skipped, the way the player skips it
+		for _, item := range list.Items {
+			if file, ok := found[item]; ok {
+				files = append(files, file)
+			}
+		}
+		title = list.Name
+	} else {
+		groups, err := s.mediaList(r)
+		if err != nil {
+			message, status := libraryError(err)
+			http.Error(w, message, status)
+			return
+		}
+		name := query.Get("category")
+		matched := false
+		seen := make(map[string]bool)
+		for _, group := range groups {
+			if name != "" && group.Name != name {
+				continue
+			}
+
+			// the whole library leaves out recently added, whose files are all
+			// in their categories, and takes a collection's files only once
+			if name == "" && playCategory(group) == "" {
+				continue
+			}
+			matched = true
+			if name != "" {
+				title = group.Heading()
+			}
+			for _, file := range group.Files {
+				if isPlayable(file) && !seen[file.Path] {
+					seen[file.Path] = true
+					files = append(files, file)
+				}
+			}
+		}
+		if name != "" && !matched {
+			http.Error(w, "no such category", http.StatusNotFound)
+			return
+		}
+		if name == "" {
+			title = s.cfg.Server.SiteTitle
+		}
+	}
+
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+		return
+	}
+	writeM3U(w, rootURL(r, s.cfg.Server.BasePath), title, files)
+}
@@ -0,0 +1,938 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// limits that keep one client from filling PlaylistFile without end
+const (
+	maxPlaylists        = 1000
+	maxPlaylistItems    = 10000
+	maxPlaylistName     = 200
+	maxPlaylistBodySize = 1 << 20
+)
+
+// errnoplaylist is returned for a playlist id that isn't in the store.
+var errNoPlaylist = errors.New("no such playlist")
+
+// errbadindex is returned for an item position past the end of a playlist.
+var errBadIndex = errors.New("no item at that position")
+
+// playlistitem is one file on a playlist, named by the category it's played
+// from and its path, the same pair as a /play/ link.
+type playlistItem struct {
+	Category string `json:"category"`
+	Path     string `json:"path"`
+}
+
+// playlist is a named, ordered list of files, shared by everyone using the server.
+type playlist struct {
+	ID      string         `json:"id"`
+	Name    string         `json:"name"`
+	Items   []playlistItem `json:"items"`
+	Created time.Time      `json:"created"`
+	Updated time.Time      `json:"updated"`
+}
+
+// playlistfile is what PlaylistFile holds.
+type playlistFile struct {
+	Playlists []playlist `json:"playlists"`
+}
+
+// playliststore keeps the playlists in memory and writes them all back to
+// PlaylistFile after every change, so a restart picks up where it left off.
+type playlistStore struct {
+	mu        sync.Mutex
+	path      string
+	playlists []playlist
+}
+
+// openplaylists loads the playlists in path. a file that doesn't exist yet is
+// an empty store, it's created with the first playlist.
+func openPlaylists(path string) (*playlistStore, error) {
+	p := &playlistStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file playlistFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	p.playlists = file.Playlists
+	return p, nil
+}
+
+// save writes playlists over PlaylistFile.
+func (p *playlistStore) save(playlists []playlist) error {
+	data, err := json.MarshalIndent(playlistFile{Playlists: playlists}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p.path, append(data, '\n'))
+}
+
+// list returns every playlist, in the order they were made.
+func (p *playlistStore) list() []playlist {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]playlist, len(p.playlists))
+	for i, list := range p.playlists {
+		out[i] = list.clone()
+	}
+	return out
+}
+
+// get returns the playlist with the given id.
+func (p *playlistStore) get(id string) (playlist, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, list := range p.playlists {
+		if list.ID == id {
+			return list.clone(), true
+		}
+	}
+	return playlist{}, false
+}
+
+// create adds a new playlist and saves the store.
+func (p *playlistStore) create(name string, items []playlistItem) (playlist, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.playlists) >= maxPlaylists {
+		return playlist{}, fmt.Errorf("there are already %d playlists", maxPlaylists)
+	}
+	id, err := newPlaylistID()
+	if err != nil {
+		return playlist{}, err
+	}
+	now := time.Now().UTC()
+	list := playlist{ID: id, Name: name, Items: items, Created: now, Updated: now}
+	if list.Items == nil {
+		list.Items = []playlistItem{}
+	}
+	playlists := append(append([]playlist(nil), p.playlists...), list)
+	if err := p.save(playlists); err != nil {
+		return playlist{}, err
+	}
+	p.playlists = playlists
+	return list.clone(), nil
+}
+
+// update runs change on a copy of a playlist and saves the store. the copy only
+// replaces the original once it's on disk, so a failed save changes nothing.
+func (p *playlistStore) update(id string, change func(list *playlist) error) (playlist, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, list := range p.playlists {
+		if list.ID != id {
+			continue
+		}
+		list = list.clone()
+		if err := change(&list); err != nil {
+			return playlist{}, err
+		}
+		if len(list.Items) > maxPlaylistItems {
+			return playlist{}, fmt.Errorf("a playlist can't have more than %d items", maxPlaylistItems)
+		}
+		list.Updated = time.Now().UTC()
+		playlists := append([]playlist(nil), p.playlists...)
+		playlists[i] = list
+		if err := p.save(playlists); err != nil {
+			return playlist{}, err
+		}
+		p.playlists = playlists
+		return list.clone(), nil
+	}
+	return playlist{}, errNoPlaylist
+}
+
+// remove deletes a playlist and saves the store.
+func (p *playlistStore) remove(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, list := range p.playlists {
+		if list.ID != id {
+			continue
+		}
+		playlists := append(append([]playlist(nil), p.playlists[:i]...), p.playlists[i+1:]...)
+		if err := p.save(playlists); err != nil {
+			return err
+		}
+		p.playlists = playlists
+		return nil
+	}
+	return errNoPlaylist
+}
+
+// clone copies a playlist with its own items, so callers can't change the store's.
+func (list playlist) clone() playlist {
+	list.Items = append([]playlistItem{}, list.Items...)
+	return list
+}
+
+// newplaylistid returns a random id for a new playlist.
+func newPlaylistID() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// playlistname checks a name for a playlist, returning it without the spaces around it.
+func playlistName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("a playlist needs a name")
+	}
+	if utf8.RuneCountInString(name) > maxPlaylistName {
+		return "", fmt.Errorf("a playlist name can't be longer than %d characters", maxPlaylistName)
+	}
+	return name, nil
+}
+
+// visibleitems returns the positions of the items on a playlist whose
+// categories the request may see. playlists are shared, so the rest are
+// left out of what the request is shown, and positions it sends count only
+// the items it was shown.
+func (s *server) visibleItems(r *http.Request, items []playlistItem) []int {
+	var visible []int
+	for i, item := range items {
+		if config, ok := s.category(item.Category); ok && canAccess(r, config) {
+			visible = append(visible, i)
+		}
+	}
+	return visible
+}
+
+// playlistfiles finds the files behind a playlist's items, walking each
+// category once. items whose file is gone, or can't be played, are left out.
+func (s *server) playlistFiles(r *http.Request, items []playlistItem) (map[playlistItem]MediaFile, error) {
+	files := make(map[playlistItem]MediaFile)
+	walked := make(map[string]bool)
+	for _, item := range items {
+		if walked[item.Category] {
+			continue
+		}
+		walked[item.Category] = true
+		config, ok := s.category(item.Category)
+		if !ok || !canAccess(r, config) {
+			continue
+		}
+		ctx, cancel := walkContext(r.Context(), s.cfg.Server)
+		group, err := walkCategory(ctx, config, s.cfg.Server)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range group.Files {
+			if isPlayable(file) {
+				files[playlistItem{Category: config.Name, Path: file.Path}] = file
+			}
+		}
+	}
+	return files, nil
+}
+
+// playlistjson is a playlist as the request may see it.
+func (s *server) playlistJSON(r *http.Request, list playlist) playlist {
+	visible := s.visibleItems(r, list.Items)
+	items := make([]playlistItem, len(visible))
+	for i, j := range visible {
+		items[i] = list.Items[j]
+	}
+	list.Items = items
+	return list
+}
+
+// playlistsresponse is the body of /api/playlists.
+type playlistsResponse struct {
+	APIVersion int        `json:"api_version"`
+	Playlists  []playlist `json:"playlists"`
+}
+
+// playlistresponse is the body of /api/playlists/{id}.
+type playlistResponse struct {
+	APIVersion int      `json:"api_version"`
+	Playlist   playlist `json:"playlist"`
+}
+
+// playlistrequest is the body sent to make or change a playlist. fields that
+// are left out stay as they are.
+type playlistRequest struct {
+	Name  *string         `json:"name"`
+	Items *[]playlistItem `json:"items"`
+}
+
+// itemrequest is the body sent to add a file to a playlist, at the end unless
+// position says where.
+type itemRequest struct {
+	playlistItem
+	Position *int `json:"position"`
+}
+
+// moverequest is the body sent to move an item to another position.
+type moveRequest struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// handleapiplaylists serves the playlists at /api/playlists:
+//
+//	GET    /api/playlists                     every playlist
+//	POST   /api/playlists                     a new one, from {"name", "items"}
+//	GET    /api/playlists/{id}                one playlist
+//	PUT    /api/playlists/{id}                rename it or replace its items
+//	DELETE /api/playlists/{id}                delete it
+//	POST   /api/playlists/{id}/items          add {"category", "path"}
+//	DELETE /api/playlists/{id}/items/{index}  remove an item
+//	POST   /api/playlists/{id}/move           move {"from", "to"}
+func (s *server) handleAPIPlaylists(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIVersion(w, r) {
+		return
+	}
+	rest := strings.TrimPrefix(strings.TrimPrefix(apiPath(r.URL.Path), "playlists"), "/")
+	parts := strings.Split(rest, "/")
+
+	switch {
+	case rest == "":
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			resp := playlistsResponse{APIVersion: apiVersion, Playlists: []playlist{}}
+			for _, list := range s.playlists.list() {
+				resp.Playlists = append(resp.Playlists, s.playlistJSON(r, list))
+			}
+			writeJSON(w, http.StatusOK, resp)
+		case http.MethodPost:
+			s.createPlaylist(w, r)
+		default:
+			methodNotAllowed(w, "GET, HEAD, POST")
+		}
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			list, ok := s.playlists.get(parts[0])
+			if !ok {
+				writeJSONError(w, errNoPlaylist.Error(), http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, playlistResponse{APIVersion: apiVersion, Playlist: s.playlistJSON(r, list)})
+		case http.MethodPut:
+			s.changePlaylist(w, r, parts[0])
+		case http.MethodDelete:
+			if err := s.playlists.remove(parts[0]); err != nil {
+				writePlaylistError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			methodNotAllowed(w, "GET, HEAD, PUT, DELETE")
+		}
+	case len(parts) == 2 && parts[1] == "items":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		s.addPlaylistItem(w, r, parts[0])
+	case len(parts) == 3 && parts[1] == "items":
+		if r.Method != http.MethodDelete {
+			methodNotAllowed(w, "DELETE")
+			return
+		}
+		index, err := strconv.Atoi(parts[2])
+		if err != nil {
+			writeJSONError(w, errBadIndex.Error(), http.StatusBadRequest)
+			return
+		}
+		s.removePlaylistItem(w, r, parts[0], index)
+	case len(parts) == 2 && parts[1] == "move":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		s.movePlaylistItem(w, r, parts[0])
+	default:
+		writeJSONError(w, "not found", http.StatusNotFound)
+	}
+}
+
+// createplaylist makes a playlist from the request body.
+func (s *server) createPlaylist(w http.ResponseWriter, r *http.Request) {
+	var req playlistRequest
+	if !readPlaylistBody(w, r, &req) {
+		return
+	}
+	if req.Name == nil {
+		writeJSONError(w, "a playlist needs a name", http.StatusBadRequest)
+		return
+	}
+	name, err := playlistName(*req.Name)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var items []playlistItem
+	if req.Items != nil {
+		items = *req.Items
+		if !s.checkItems(w, r, items) {
+			return
+		}
+	}
+	list, err := s.playlists.create(name, items)
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, playlistResponse{APIVersion: apiVersion, Playlist: s.playlistJSON(r, list)})
+}
+
+// changeplaylist renames a playlist or replaces its items. items the request
+// can't see aren't its to drop, so they're kept after the new ones.
+func (s *server) changePlaylist(w http.ResponseWriter, r *http.Request, id string) {
+	var req playlistRequest
+	if !readPlaylistBody(w, r, &req) {
+		return
+	}
+	var name string
+	if req.Name != nil {
+		var err error
+		if name, err = playlistName(*req.Name); err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Items != nil && !s.checkItems(w, r, *req.Items) {
+		return
+	}
+	s.writeUpdate(w, r, id, func(list *playlist) error {
+		if req.Name != nil {
+			list.Name = name
+		}
+		if req.Items != nil {
+			hidden := hiddenItems(list.Items, s.visibleItems(r, list.Items))
+			list.Items = append(append([]playlistItem{}, *req.Items...), hidden...)
+		}
+		return nil
+	})
+}
+
+// addplaylistitem adds a file to a playlist, at the end or at the position asked for.
+func (s *server) addPlaylistItem(w http.ResponseWriter, r *http.Request, id string) {
+	var req itemRequest
+	if !readPlaylistBody(w, r, &req) {
+		return
+	}
+	if !s.checkItems(w, r, []playlistItem{req.playlistItem}) {
+		return
+	}
+	s.writeUpdate(w, r, id, func(list *playlist) error {
+		visible := s.visibleItems(r, list.Items)
+		at := len(list.Items)
+		if req.Position != nil {
+			switch p := *req.Position; {
+			case p < 0 || p > len(visible):
+				return errBadIndex
+			case p < len(visible):
+				at = visible[p]
+			}
+		}
+		items := append(append([]playlistItem{}, list.Items[:at]...), req.playlistItem)
+		list.Items = append(items, list.Items[at:]...)
+		return nil
+	})
+}
+
+// removeplaylistitem takes the item at a position off a playlist.
+func (s *server) removePlaylistItem(w http.ResponseWriter, r *http.Request, id string, index int) {
+	s.writeUpdate(w, r, id, func(list *playlist) error {
+		visible := s.visibleItems(r, list.Items)
+		if index < 0 || index >= len(visible) {
+			return errBadIndex
+		}
+		at := visible[index]
+		list.Items = append(list.Items[:at:at], list.Items[at+1:]...)
+		return nil
+	})
+}
+
+// moveplaylistitem moves an item to another position, shifting the ones
+// between along.
+func (s *server) movePlaylistItem(w http.ResponseWriter, r *http.Request, id string) {
+	var req moveRequest
+	if !readPlaylistBody(w, r, &req) {
+		return
+	}
+	s.writeUpdate(w, r, id, func(list *playlist) error {
+		visible := s.visibleItems(r, list.Items)
+		if req.From < 0 || req.From >= len(visible) || req.To < 0 || req.To >= len(visible) {
+			return errBadIndex
+		}
+
+		// only the items the request sees are reordered, the rest keep their places
+		order := make([]playlistItem, len(visible))
+		for i, j := range visible {
+			order[i] = list.Items[j]
+		}
+		item := order[req.From]
+		order = append(order[:req.From], order[req.From+1:]...)
+		order = append(order[:req.To], append([]playlistItem{item}, order[req.To:]...)...)
+		for i, j := range visible {
+			list.Items[j] = order[i]
+		}
+		return nil
+	})
+}
+
+// hiddenitems returns the items that aren't at one of the visible positions.
+func hiddenItems(items []playlistItem, visible []int) []playlistItem {
+	shown := make(map[int]bool, len(visible))
+	for _, i := range visible {
+		shown[i] = true
+	}
+	var hidden []playlistItem
+	for i, item := range items {
+		if !shown[i] {
+			hidden = append(hidden, item)
+		}
+	}
+	return hidden
+}
+
+// writeupdate changes a playlist and answers with the result.
+func (s *server) writeUpdate(w http.ResponseWriter, r *http.Request, id string, change func(list *playlist) error) {
+	list, err := s.playlists.update(id, change)
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, playlistResponse{APIVersion: apiVersion, Playlist: s.playlistJSON(r, list)})
+}
+
+// checkitems answers with a 400 unless every item is a playable file in a
+// category the request may see.
+func (s *server) checkItems(w http.ResponseWriter, r *http.Request, items []playlistItem) bool {
+	if len(items) > maxPlaylistItems {
+		writeJSONError(w, fmt.Sprintf("a playlist can't have more than %d items", maxPlaylistItems), http.StatusBadRequest)
+		return false
+	}
+	files, err := s.playlistFiles(r, items)
+	if err != nil {
+		message, status := libraryError(err)
+		writeJSONError(w, message, status)
+		return false
+	}
+	for _, item := range items {
+		if _, ok := files[item]; !ok {
+			writeJSONError(w, fmt.Sprintf("%s has no playable file %s", item.Category, item.Path), http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// readplaylistbody decodes a json request body into v. only bodies sent as
+// application/json are taken, which a form on another site can't send
+// without the browser asking first, so other sites can't change playlists.
+func readPlaylistBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		writeJSONError(w, "the body must be sent as application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPlaylistBodySize))
+	if err := dec.Decode(v); err != nil {
+		writeJSONError(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		writeJSONError(w, "invalid json: more than one value in the body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeplaylisterror turns an error from the store into a json error. saving
+// errors are only logged, since they contain the path of PlaylistFile.
+func writePlaylistError(w http.ResponseWriter, err error) {
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+	switch {
+	case errors.Is(err, errNoPlaylist):
+		writeJSONError(w, err.Error(), http.StatusNotFound)
+	case errors.As(err, &pathErr), errors.As(err, &linkErr):
+		log.Println("Error saving playlists:", err)
+		writeJSONError(w, "the playlists could not be saved", http.StatusInternalServerError)
+	default:
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// methodnotallowed answers with a 405 and the methods that are.
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+// playlistentry is one item on a playlist page, with the file behind it when
+// it's still there.
+type playlistEntry struct {
+	Index int
+	Last  bool
+	playlistItem
+	File  MediaFile
+	Found bool
+}
+
+// playlistsdata is what the playlists pages render: every playlist, or one
+// playlist's items.
+type playlistsData struct {
+	SiteTitle string
+	BasePath  string
+	Offline   bool
+	Theme     string
+	Playlists []playlist
+	Playlist  *playlist
+	Entries   []playlistEntry
+}
+
+// handleplaylists lists the playlists at /playlists, and one playlist's files,
+// each a link into the player, at /playlists/{id}.
+func (s *server) handlePlaylists(w http.ResponseWriter, r *http.Request) {
+	data := playlistsData{
+		SiteTitle: s.cfg.Server.SiteTitle,
+		BasePath:  s.cfg.Server.BasePath,
+		Offline:   s.cfg.Server.Offline,
+		Theme:     s.theme(w, r),
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/playlists"), "/")
+	if id == "" {
+		for _, list := range s.playlists.list() {
+			data.Playlists = append(data.Playlists, s.playlistJSON(r, list))
+		}
+	} else {
+		list, ok := s.playlists.get(id)
+		if !ok {
+			s.notFound(w, r)
+			return
+		}
+		list = s.playlistJSON(r, list)
+		files, err := s.playlistFiles(r, list.Items)
+		if err != nil {
+			message, status := libraryError(err)
+			http.Error(w, message, status)
+			return
+		}
+		for i, item := range list.Items {
+			file, found := files[item]
+			data.Entries = append(data.Entries, playlistEntry{Index: i, Last: i == len(list.Items)-1, playlistItem: item, File: file, Found: found})
+		}
+		data.Playlist = &list
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.playlistsTmpl.Execute(w, data); err != nil {
+		log.Println("Error executing template:", err)
+	}
+}
+
+// playlistneighbours returns the files before and after an item on a
+// playlist, skipping any that are gone, the way the player steps through a
+// category. it reports false when the item isn't on the playlist.
+func (s *server) playlistNeighbours(r *http.Request, list playlist, current playlistItem) (*playLink, *playLink, bool, error) {
+	list = s.playlistJSON(r, list)
+	at := -1
+	for i, item := range list.Items {
+		if item == current {
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		return nil, nil, false, nil
+	}
+	files, err := s.playlistFiles(r, list.Items)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	link := func(i int) *playLink {
+		return &playLink{Category: list.Items[i].Category, Playlist: list.ID, File: files[list.Items[i]]}
+	}
+	var prev, next *playLink
+	for i := at - 1; i >= 0 && prev == nil; i-- {
+		if _, ok := files[list.Items[i]]; ok {
+			prev = link(i)
+		}
+	}
+	for i := at + 1; i < len(list.Items) && next == nil; i++ {
+		if _, ok := files[list.Items[i]]; ok {
+			next = link(i)
+		}
+	}
+	return prev, next, true, nil
+}
+
+// html template for the playlists pages
+const playlistsTemplate = `
+<!DOCTYPE html>
+<html lang="en"{{if ne .Theme "auto"}} data-bs-theme="{{.Theme}}"{{end}}>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    {{if .Offline}}<link href="{{.BasePath}}/assets/bootstrap.min.css" rel="stylesheet">{{else}}<link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0-alpha3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-KK94CHFLLe+nY2dmCWGMq91rCGa5gtU4mk92HdvYe+M/SXH301p5ILy+dN9+nJOZ" crossorigin="anonymous">{{end}}
+		<title>{{with .Playlist}}{{.Name}}{{else}}Playlists{{end}} - {{.SiteTitle}}</title>
+    <style>
+        .edit { display: none; }
+        .editable .edit { display: inline; }
+    </style>
+    {{template "icons"}}
+    {{template "theme" .}}
+</head>
+<body>
+<div class="container-fluid">
+    <div class="row">
+        <div class="col">
+            <h1><a href="{{.BasePath}}/">{{.SiteTitle}}</a></h1>
+            {{template "theme-switch"}}
+            {{with .Playlist}}
+            <h2 class="h4"><a href="{{$.BasePath}}/playlists">Playlists</a> / <span id="name">{{.Name}}</span>
+                <small><a href="{{$.BasePath}}/playlist.m3u?playlist={{.ID}}" title="open in a player">m3u</a></small>
+                <small class="edit"><button type="button" class="btn btn-link btn-sm" id="rename">rename</button><button type="button" class="btn btn-link btn-sm" id="delete">delete</button></small></h2>
+            {{else}}
+            <h2 class="h4">Playlists <small class="edit"><button type="button" class="btn btn-link btn-sm" id="create">new playlist</button></small></h2>
+            {{end}}
+        </div>
+    </div>
+    <div class="row">
+        <div class="col">
+            {{with .Playlist}}
+            {{if $.Entries}}
+            <ol id="items">
+                {{range $.Entries}}
+                <li>
+                    {{if .Found}}
+                    <i class="{{icon .File.Path}}" aria-hidden="true"></i><a href="{{$.BasePath}}/play/{{.Category}}/{{.File.Path}}?playlist={{$.Playlist.ID}}">{{.File.DisplayName}}</a>
+                    <small class="text-muted">{{.Category}} / {{.Path}}</small>
+                    {{if .File.Duration}}<small class="text-muted">{{duration .File.Duration}}</small>{{end}}
+                    {{else}}
+                    <span class="text-muted">{{.Path}}</span> <small class="text-muted">{{.Category}}, missing</small>
+                    {{end}}
+                    <small class="edit">
+                        {{if gt .Index 0}}<button type="button" class="btn btn-link btn-sm p-0" data-move="{{.Index}}" data-to="-1" title="move up">&uarr;</button>{{end}}
+                        {{if not .Last}}<button type="button" class="btn btn-link btn-sm p-0" data-move="{{.Index}}" data-to="1" title="move down">&darr;</button>{{end}}
+                        <button type="button" class="btn btn-link btn-sm p-0" data-remove="{{.Index}}" title="remove from the playlist">&times;</button>
+                    </small>
+                </li>
+                {{end}}
+            </ol>
+            {{else}}
+            <p class="text-muted">nothing on this playlist yet, add files with the + next to them in the listing</p>
+            {{end}}
+            {{else}}
+            {{if .Playlists}}
+            <ul>
+                {{range .Playlists}}
+                <li><a href="{{$.BasePath}}/playlists/{{.ID}}"><strong>{{.Name}}</strong></a> <small class="text-muted">{{len .Items}} {{if eq (len .Items) 1}}file{{else}}files{{end}}</small></li>
+                {{end}}
+            </ul>
+            {{else}}
+            <p class="text-muted">no playlists yet</p>
+            {{end}}
+            {{end}}
+        </div>
+    </div>
+</div>
+<script>
+    // editing goes through the api, so the buttons only show with javascript
+    (function () {
+        if (!window.fetch) {
+            return;
+        }
+        var base = {{.BasePath}};
+        var id = {{with .Playlist}}{{.ID}}{{else}}""{{end}};
+        var api = base + "/api/playlists" + (id ? "/" + encodeURIComponent(id) : "");
+        document.body.classList.add("editable");
+
+        function send(method, url, body) {
+            var options = {method: method, headers: {}};
+            if (body !== undefined) {
+                options.headers["Content-Type"] = "application/json";
+                options.body = JSON.stringify(body);
+            }
+            return fetch(url, options).then(function (response) {
+                if (!response.ok) {
+                    return response.json().then(function (e) { throw new Error(e.error || response.statusText); });
+                }
+                return response.status === 204 ? null : response.json();
+            }).catch(function (e) {
+                alert(e.message);
+                throw e;
+            });
+        }
+
+        function click(id, handler) {
+            var button = document.getElementById(id);
+            if (button) {
+                button.addEventListener("click", handler);
+            }
+        }
+
+        click("create", function () {
+            var name = prompt("name of the new playlist");
+            if (name) {
+                send("POST", api, {name: name}).then(function (resp) {
+                    window.location.href = base + "/playlists/" + encodeURIComponent(resp.playlist.id);
+                });
+            }
+        });
+        click("rename", function () {
+            var name = prompt("new name", document.getElementById("name").textContent);
+            if (name) {
+                send("PUT", api, {name: name}).then(function () { window.location.reload(); });
+            }
+        });
+        click("delete", function () {
+            if (confirm("delete this playlist?")) {
+                send("DELETE", api).then(function () { window.location.href = base + "/playlists"; });
+            }
+        });
+
+        var items = document.getElementById("items");
+        if (items) {
+            items.addEventListener("click", function (event) {
+                var data = event.target.dataset;
+                if (data.remove !== undefined) {
+                    send("DELETE", api + "/items/" + data.remove).then(function () { window.location.reload(); });
+                } else if (data.move !== undefined) {
+                    var from = +data.move;
+                    send("POST", api + "/move", {from: from, to: from + (+data.to)}).then(function () { window.location.reload(); });
+                }
+            });
+        }
+    })();
+</script>
+</body>
+</html>
+`
+
+// playlistpicker is the playlist the listing's + buttons add to, chosen from a
+// menu in the header and remembered by the browser.
+const playlistPicker = `
+{{define "playlist-picker"}}
+{{if .Playlists}}
+<style>
+    .add-to-playlist { display: none; }
+    .playlists-ready .add-to-playlist { display: inline; }
+</style>
+<script>
+    (function () {
+        if (!window.fetch) {
+            return;
+        }
+        var base = {{.BasePath}};
+        var picker = document.getElementById("playlist");
+        var chosen = "";
+        try {
+            chosen = localStorage.getItem("chill_playlist") || "";
+        } catch (e) {}
+
+        function remember(id) {
+            chosen = id;
+            try {
+                localStorage.setItem("chill_playlist", id);
+            } catch (e) {}
+        }
+
+        function addOption(list) {
+            var option = document.createElement("option");
+            option.value = list.id;
+            option.textContent = list.name;
+            picker.insertBefore(option, picker.lastChild);
+            return option;
+        }
+
+        function post(url, body) {
+            return fetch(url, {method: "POST", headers: {"Content-Type": "application/json"}, body: JSON.stringify(body)}).then(function (response) {
+                return response.json().then(function (resp) {
+                    if (!response.ok) {
+                        throw new Error(resp.error || response.statusText);
+                    }
+                    return resp;
+                });
+            });
+        }
+
+        // a new playlist is made the first time something is added to it
+        function target() {
+            if (picker.value) {
+                return Promise.resolve(picker.value);
+            }
+            var name = prompt("name of the new playlist");
+            if (!name) {
+                return Promise.reject(null);
+            }
+            return post(base + "/api/playlists", {name: name}).then(function (resp) {
+                addOption(resp.playlist).selected = true;
+                remember(resp.playlist.id);
+                return resp.playlist.id;
+            });
+        }
+
+        fetch(base + "/api/playlists").then(function (response) {
+            return response.json();
+        }).then(function (resp) {
+            var fresh = document.createElement("option");
+            fresh.value = "";
+            fresh.textContent = "new playlist…";
+            picker.appendChild(fresh);
+            (resp.playlists || []).forEach(function (list) {
+                addOption(list).selected = list.id === chosen;
+            });
+            picker.classList.remove("d-none");
+            picker.addEventListener("change", function () {
+                remember(picker.value);
+            });
+            document.body.classList.add("playlists-ready");
+        });
+
+        document.addEventListener("click", function (event) {
+            var button = event.target.closest && event.target.closest(".add-to-playlist");
+            if (!button) {
+                return;
+            }
+            event.preventDefault();
+            target().then(function (id) {
+                return post(base + "/api/playlists/" + encodeURIComponent(id) + "/items", {category: button.dataset.category, path: button.dataset.path});
+            }).then(function () {
+                button.textContent = "✓";
+                button.title = "added to " + picker.options[picker.selectedIndex].textContent;
+            }, function (e) {
+                if (e) {
+                    button.textContent = "!";
+                    button.title = e.message;
+                }
+            });
+        });
+    })();
+</script>
+{{end}}
+{{end}}
+`
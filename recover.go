@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverpanics turns a panic in a handler into a 500 for that request instead
+// of a dropped connection, logging it with the path and stack so it can be fixed.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+
+			// net/http uses this panic on purpose to abort a response, so let it through
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Printf("panic serving %s: %v\n%s", r.URL.Path, err, debug.Stack())
+
+			// once the status is out a clean error can't be sent any more
+			if !sw.wroteHeader {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// statuswriter wraps a responsewriter and records whether the header was sent.
+type statusWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	sw.wroteHeader = true
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(p)
+}
+
+// unwrap exposes the underlying responsewriter to http.ResponseController.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
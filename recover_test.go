@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	logged := captureLog(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["boom"]++
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "still here")
+	})
+	srv := httptest.NewServer(recoverPanics(mux))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/panic")
+	if err != nil {
+		t.Fatalf("GET /panic: %v, want a response", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("GET /panic = %d, want 500", resp.StatusCode)
+	}
+	if strings.Contains(string(body), "goroutine") {
+		t.Errorf("the 500 shows the stack to the client:\n%s", body)
+	}
+	if !strings.Contains(logged.String(), "panic serving /panic") {
+		t.Errorf("the panic wasn't logged with its path, log:\n%s", logged.String())
+	}
+
+	// the server carries on answering after the panic
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(srv.URL + "/ok")
+		if err != nil {
+			t.Fatalf("GET /ok after the panic: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "still here" {
+			t.Errorf("GET /ok after the panic = %d %q", resp.StatusCode, body)
+		}
+	}
+}
+
+func TestRecoverPanicsAfterTheHeader(t *testing.T) {
+	captureLog(t)
+	h := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("late")
+	}))
+
+	// the status already sent stands, a second one can't be written
+	w := request(h, http.MethodGet, "/")
+	if w.Code != http.StatusAccepted {
+		t.Errorf("status = %d, want the %d already sent", w.Code, http.StatusAccepted)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// reloadpollinterval is how often the config file is checked for changes
+// with WatchConfig.
+const reloadPollInterval = 2 * time.Second
+
+// restartsettings are the [Server] settings a reload leaves as they were: the
+// listener and its timeouts are set up once, and the index, the database and
+// the mime types and icons are shared by everything that's running. a change
+// to one of them is logged as needing a restart.
+var restartSettings = []string{
+	"Listen", "TLSCert", "TLSKey",
+	"ReadHeaderTimeout", "ReadTimeout", "WriteTimeout", "IdleTimeout", "ShutdownTimeout",
+	"IndexInterval", "Database", "MIMETypes", "Icons",
+}
+
+// reloader serves the routes built from the config, and builds them again
+// from the config file on SIGHUP or, with WatchConfig, whenever the file
+// changes. requests already being served finish with the config they started
+// with.
+type reloader struct {
+	flags configFlags
+
+	// reloading is held for the whole of a reload, so two never overlap
+	reloading sync.Mutex
+
+	mu      sync.RWMutex
+	srv     *server
+	handler http.Handler
+
+	// stopwatch stops the library watcher, nil while Watch is off
+	stopWatch context.CancelFunc
+}
+
+// newreloader serves srv's routes. the flags apply to every reload just as
+// they did at startup.
+func newReloader(srv *server, flags configFlags) *reloader {
+	rl := &reloader{flags: flags, srv: srv, handler: srv.routes()}
+	rl.watch(srv.cfg)
+	return rl
+}
+
+// servehttp hands the request to the routes of the current config.
+func (rl *reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rl.mu.RLock()
+	srv, handler := rl.srv, rl.handler
+	srv.inFlight.Add(1)
+	rl.mu.RUnlock()
+	defer srv.inFlight.Done()
+	handler.ServeHTTP(w, r)
+}
+
+// current returns the server built from the current config.
+func (rl *reloader) current() *server {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return rl.srv
+}
+
+// watch starts following the category folders when cfg has Watch on,
+// stopping the watcher of the config before.
+func (rl *reloader) watch(cfg *Config) {
+	if rl.stopWatch != nil {
+		rl.stopWatch()
+		rl.stopWatch = nil
+	}
+	if !cfg.Server.Watch {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rl.stopWatch = cancel
+	w := newWatcher(cfg.Categories, cfg.Server)
+	go func() {
+		if err := w.run(ctx); err != nil {
+			log.Println("Stopped watching the library:", err)
+		}
+	}()
+}
+
+// run reloads the config on every SIGHUP, and when the config file changes
+// while WatchConfig is on, until ctx is done. a config that fails to load is
+// logged and the running one kept.
+func (rl *reloader) run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(reloadPollInterval)
+	defer ticker.Stop()
+
+	path := rl.flags.path
+	last, _ := os.Stat(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-ticker.C:
+			if !rl.current().cfg.Server.WatchConfig || path == stdinConfig {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil || (last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size()) {
+				continue
+			}
+		}
+
+		// a signal also moves last on, so the poll doesn't load the same file twice
+		if info, err := os.Stat(path); err == nil {
+			last = info
+		}
+		if err := rl.reload(); err != nil {
+			log.Println("Kept the running config, the new one failed to load:", err)
+		}
+	}
+}
+
+// reload reads the config file again and swaps in handlers built from it: the
+// file servers of added categories, the pages for every setting, and the
+// library index, which keeps the categories that didn't change and scans the
+// others in the background.
+func (rl *reloader) reload() error {
+	rl.reloading.Lock()
+	defer rl.reloading.Unlock()
+	if rl.flags.path == stdinConfig {
+		return errors.New("the config was read from stdin, so there's no file to read it from again")
+	}
+	cfg, err := rl.flags.load(os.Environ())
+	if err != nil {
+		return err
+	}
+	old := rl.current()
+	if changed := keepRestartSettings(&old.cfg.Server, &cfg.Server); len(changed) > 0 {
+		log.Printf("Changing %s takes a restart, the running values are kept until then", strings.Join(changed, ", "))
+	}
+
+	srv := newServer(cfg)
+	if err := srv.carryOver(old); err != nil {
+		return err
+	}
+
+	// the library is only told once the new handlers are certain
+	database.configure(cfg.Server)
+	stale := library.reconfigure(cfg.Categories, cfg.Server)
+	if len(stale) > 0 {
+		go library.scan(context.Background(), stale)
+	}
+
+	rl.mu.Lock()
+	rl.srv, rl.handler = srv, srv.routes()
+	rl.mu.Unlock()
+	rl.watch(cfg)
+
+	// requests that started before the swap still write to the old access log
+	if old.accessLog != nil && old.accessLog != srv.accessLog {
+		go func() {
+			old.inFlight.Wait()
+			if err := old.accessLog.close(); err != nil {
+				log.Println("Error closing the old access log:", err)
+			}
+		}()
+	}
+	metrics.keepCategories(cfg.Categories)
+
+	log.Println("Reloaded the config:", categoryChanges(old.cfg.Categories, cfg.Categories))
+	libraryEvents.publish()
+	return nil
+}
+
+// configflags are the command line flags the config is loaded with: the
+// -config file, whether it was named or found by default, -kiosk and -db.
+type configFlags struct {
+	path   string
+	named  bool
+	kiosk  bool
+	dbFile string
+}
+
+// load loads the config the same way at startup and on every reload: the
+// file, the CHILL_ variables in environ over it and the flags over both, and
+// then the login checked and kiosk mode applied.
+func (f configFlags) load(environ []string) (*Config, error) {
+	cfg, err := LoadConfig(f.path)
+
+	// a container can do without the file, its folders coming from CHILL_DIRECTORIES
+	if err != nil && !f.named && errors.Is(err, os.ErrNotExist) && envValue(environ, envPrefix+envDirectories) != "" {
+		cfg, err = ParseConfig(strings.NewReader(""))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := applyEnv(cfg, environ); err != nil {
+		return nil, fmt.Errorf("applying the environment: %w", err)
+	}
+	if f.dbFile != "" {
+		cfg.Server.Database = f.dbFile
+	}
+
+	// a login that's half set up would leave the server open, so refuse it
+	if err := checkSiteLogin(cfg.Server); err != nil {
+		return nil, err
+	}
+	for _, warning := range siteLoginWarnings(cfg) {
+		log.Println("Warning:", warning)
+	}
+
+	// lock the server down before anything is built from the config
+	if f.kiosk || cfg.Server.Kiosk {
+		if err := applyKiosk(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// carryover takes the playlists and the access log over from the server of
+// the config before when they're kept in the same files, and opens them
+// afresh when they moved. the old access log is left open for reload to
+// close once the requests still using it are done.
+func (s *server) carryOver(old *server) error {
+	if path := s.cfg.Server.PlaylistFile; path != "" {
+		if old.playlists != nil && path == old.cfg.Server.PlaylistFile {
+			s.playlists = old.playlists
+		} else {
+			playlists, err := openPlaylists(path)
+			if err != nil {
+				return fmt.Errorf("loading the playlists: %w", err)
+			}
+			s.playlists = playlists
+		}
+	}
+
+	path := s.cfg.Server.AccessLogFile
+	if old.accessLog != nil && path == old.cfg.Server.AccessLogFile {
+		s.accessLog = old.accessLog
+		s.accessLog.resize(s.cfg.Server.AccessLogMaxSize)
+		return nil
+	}
+	if path != "" {
+		accessLog, err := openAccessLog(path, s.cfg.Server.AccessLogMaxSize)
+		if err != nil {
+			return fmt.Errorf("opening the access log: %w", err)
+		}
+		s.accessLog = accessLog
+	}
+	return nil
+}
+
+// keeprestartsettings sets the restartsettings of loaded back to the running
+// ones, so what's shown and used matches what's actually running, and returns
+// the names of those that differed.
+func keepRestartSettings(running, loaded *ServerConfig) []string {
+	var changed []string
+	from, to := reflect.ValueOf(running).Elem(), reflect.ValueOf(loaded).Elem()
+	for _, name := range restartSettings {
+		was, is := from.FieldByName(name), to.FieldByName(name)
+		if !reflect.DeepEqual(was.Interface(), is.Interface()) {
+			changed = append(changed, name)
+			is.Set(was)
+		}
+	}
+	return changed
+}
+
+// categorychanges describes which categories a reload added, removed and
+// changed.
+func categoryChanges(before, after []CategoryConfig) string {
+	old := make(map[string]CategoryConfig)
+	for _, config := range before {
+		old[config.Name] = config
+	}
+	var added, changed, removed []string
+	for _, config := range after {
+		was, ok := old[config.Name]
+		switch {
+		case !ok:
+			added = append(added, config.Name)
+		case !reflect.DeepEqual(was, config):
+			changed = append(changed, config.Name)
+		}
+		delete(old, config.Name)
+	}
+	for _, config := range before {
+		if _, ok := old[config.Name]; ok {
+			removed = append(removed, config.Name)
+		}
+	}
+
+	var parts []string
+	for _, part := range []struct {
+		verb  string
+		names []string
+	}{{"added", added}, {"changed", changed}, {"removed", removed}} {
+		if len(part.names) > 0 {
+			parts = append(parts, part.verb+" "+strings.Join(part.names, ", "))
+		}
+	}
+	if len(parts) == 0 {
+		return "no categories changed"
+	}
+	return strings.Join(parts, "; ")
+}
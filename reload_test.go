@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConfigFlagsLoad(t *testing.T) {
+	music := testTree(t, "song.mp3")
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.cfg")
+	if err := os.WriteFile(file, []byte("[Server]\nDatabase=/from/the/file\n[Music]\nDirectory="+music+"\nFileTypes=.mp3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.cfg")
+	films := "CHILL_DIRECTORIES=Films=" + music
+
+	tests := []struct {
+		name       string
+		flags      configFlags
+		environ    []string
+		wantErr    bool
+		categories []string
+		database   string
+	}{
+		{"the file", configFlags{path: file, named: true}, nil, false, []string{"Music"}, "/from/the/file"},
+		{"the env adds to the file", configFlags{path: file}, []string{films, "CHILL_DATABASE=/from/the/env"}, false, []string{"Music", "Films"}, "/from/the/env"},
+		{"-db wins over both", configFlags{path: file, dbFile: "/from/the/flag"}, []string{"CHILL_DATABASE=/from/the/env"}, false, []string{"Music"}, "/from/the/flag"},
+		{"no file, folders from the env", configFlags{path: missing}, []string{films}, false, []string{"Films"}, ""},
+		{"no file or folders", configFlags{path: missing}, nil, true, nil, ""},
+		{"a named file must be there", configFlags{path: missing, named: true}, []string{films}, true, nil, ""},
+		{"a bad env value", configFlags{path: file}, []string{"CHILL_METRICS=maybe"}, true, nil, ""},
+		{"a half set login", configFlags{path: file}, []string{"CHILL_AUTH_USER=me"}, true, nil, ""},
+	}
+	for _, tt := range tests {
+		cfg, err := tt.flags.load(tt.environ)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: load succeeded, want an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: load: %v", tt.name, err)
+			continue
+		}
+		var names []string
+		for _, config := range cfg.Categories {
+			names = append(names, config.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(tt.categories, ",") || cfg.Server.Database != tt.database {
+			t.Errorf("%s: categories %v and database %q, want %v and %q", tt.name, names, cfg.Server.Database, tt.categories, tt.database)
+		}
+	}
+}
+
+func TestReloadWithoutAConfigFile(t *testing.T) {
+	captureLog(t)
+	music := testTree(t, "song.mp3")
+	films := testTree(t, "film.mkv")
+
+	// a container run with only CHILL_DIRECTORIES reloads the same way it started
+	flags := configFlags{path: filepath.Join(t.TempDir(), "config.cfg")}
+	t.Setenv("CHILL_DIRECTORIES", "Music="+music)
+	cfg, err := flags.load(os.Environ())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := newReloader(newServer(cfg), flags)
+
+	t.Setenv("CHILL_DIRECTORIES", "Music="+music+string(os.PathListSeparator)+"Films="+films)
+	if err := rl.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	body := request(rl, http.MethodGet, "/api/media").Body.String()
+	if !strings.Contains(body, "song.mp3") || !strings.Contains(body, "film.mkv") {
+		t.Errorf("after the reload /api/media = %s", body)
+	}
+}
+
+// stalledWriter holds a response at its first write until release is closed.
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	writing chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.writing)
+		<-w.release
+	})
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestReloadMovesTheAccessLog(t *testing.T) {
+	captureLog(t)
+	music := testTree(t, "song.mp3")
+	logs := t.TempDir()
+	before, after := filepath.Join(logs, "before.log"), filepath.Join(logs, "after.log")
+	path := filepath.Join(t.TempDir(), "config.cfg")
+	write := func(accessLog string) {
+		if err := os.WriteFile(path, []byte("[Server]\nAccessLogFile="+accessLog+"\n[Music]\nDirectory="+music+"\nFileTypes=.mp3\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(before)
+	flags := configFlags{path: path, named: true}
+	cfg, err := flags.load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(cfg)
+	if err := srv.carryOver(&server{cfg: &Config{}}); err != nil {
+		t.Fatal(err)
+	}
+	rl := newReloader(srv, flags)
+
+	// a download is still going when the reload moves the log
+	w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), writing: make(chan struct{}), release: make(chan struct{})}
+	served := make(chan struct{})
+	go func() {
+		rl.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/song.mp3", nil))
+		close(served)
+	}()
+	<-w.writing
+	write(after)
+	if err := rl.reload(); err != nil {
+		t.Fatal(err)
+	}
+	request(rl, http.MethodGet, "/song.mp3")
+	close(w.release)
+	<-served
+
+	// the download finishes in the log it started with, which is closed after
+	old := srv.accessLog
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		old.mu.Lock()
+		closed := old.closed
+		old.mu.Unlock()
+		if closed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the old access log is still open after its last request finished")
+		}
+	}
+	for name, want := range map[string]int{before: 1, after: 1} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Count(string(data), `"path":"/song.mp3"`); got != want {
+			t.Errorf("%s has %d entries, want %d:\n%s", filepath.Base(name), got, want, data)
+		}
+	}
+	rl.current().accessLog.close()
+}
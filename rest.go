@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// categoryinfo describes a category in /api/categories, without its files.
+type categoryInfo struct {
+	Name        string `json:"name"`
+	Title       string `json:"title,omitempty"`
+	Cover       string `json:"cover,omitempty"`
+	Description string `json:"description,omitempty"`
+	Offline     bool   `json:"offline,omitempty"`
+	FileCount   int    `json:"file_count"`
+	TotalSize   int64  `json:"total_bytes"`
+}
+
+// categoriesresponse is the body of /api/categories.
+type categoriesResponse struct {
+	APIVersion int            `json:"api_version"`
+	Categories []categoryInfo `json:"categories"`
+}
+
+// filesresponse is the body of /api/categories/{name}/files.
+type filesResponse struct {
+	APIVersion int         `json:"api_version"`
+	Category   string      `json:"category"`
+	Files      []MediaFile `json:"files"`
+}
+
+// fileresponse is the body of /api/files/{id}, with the url the file is served at.
+type fileResponse struct {
+	APIVersion int       `json:"api_version"`
+	Category   string    `json:"category"`
+	URL        string    `json:"url"`
+	File       MediaFile `json:"file"`
+}
+
+// apipath returns what follows /api/ or /api/v1/ in a request path.
+func apiPath(urlPath string) string {
+	if rest, ok := strings.CutPrefix(urlPath, "/api/v1/"); ok {
+		return rest
+	}
+	return strings.TrimPrefix(urlPath, "/api/")
+}
+
+// handleapicategories lists the categories at /api/categories, and a single
+// category's files at /api/categories/{name}/files.
+func (s *server) handleAPICategories(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIVersion(w, r) {
+		return
+	}
+	rest := strings.TrimPrefix(strings.TrimPrefix(apiPath(r.URL.Path), "categories"), "/")
+	if rest == "" {
+		s.apiCategories(w, r)
+		return
+	}
+	name, ok := strings.CutSuffix(rest, "/files")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		writeJSONError(w, "not found", http.StatusNotFound)
+		return
+	}
+	s.apiCategoryFiles(w, r, name)
+}
+
+// apicategories lists the categories the request may see, in listing order,
+// with their totals but not their files.
+func (s *server) apiCategories(w http.ResponseWriter, r *http.Request) {
+	groups, err := buildMediaList(r.Context(), s.categories(r), s.cfg.Server)
+	if err != nil {
+		message, status := libraryError(err)
+		writeJSONError(w, message, status)
+		return
+	}
+	if s.cfg.Server.HideEmpty {
+		groups = withoutEmpty(groups)
+	}
+
+	resp := categoriesResponse{APIVersion: apiVersion, Categories: make([]categoryInfo, 0, len(groups))}
+	for _, group := range groups {
+		resp.Categories = append(resp.Categories, categoryInfo{
+			Name:        group.Name,
+			Title:       group.Title,
+			Cover:       group.Cover,
+			Description: group.Description,
+			Offline:     group.Offline,
+			FileCount:   group.FileCount,
+			TotalSize:   group.TotalSize,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// apicategoryfiles lists every file of one category.
+func (s *server) apiCategoryFiles(w http.ResponseWriter, r *http.Request, name string) {
+	config, ok := s.category(name)
+	if !ok {
+		writeJSONError(w, "no such category", http.StatusNotFound)
+		return
+	}
+	if !s.authorize(w, r, config) {
+		return
+	}
+
+	ctx, cancel := walkContext(r.Context(), s.cfg.Server)
+	group, err := walkCategory(ctx, config, s.cfg.Server)
+	cancel()
+	if err != nil {
+		message, status := libraryError(err)
+		writeJSONError(w, message, status)
+		return
+	}
+	writeJSON(w, http.StatusOK, filesResponse{APIVersion: apiVersion, Category: config.Name, Files: group.Files})
+}
+
+// handleapifile describes a single file by its stable id at /api/files/{id}.
+// like /id/, an id that isn't known yet, or whose file moved since the last
+// walk, triggers a fresh walk.
+func (s *server) handleAPIFile(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIVersion(w, r) {
+		return
+	}
+	id := strings.TrimPrefix(apiPath(r.URL.Path), "files/")
+	if id == "" {
+		writeJSONError(w, "no such file", http.StatusNotFound)
+		return
+	}
+	configs := s.categories(r)
+
+	// the index only says where an id was, the category's walk has the file itself
+	config, _, ok := fileIDs.lookup(configs, id)
+	if ok {
+		file, found, err := s.fileByID(r, config, id)
+		if err != nil {
+			message, status := libraryError(err)
+			writeJSONError(w, message, status)
+			return
+		}
+		if found {
+			s.writeAPIFile(w, r, config, file)
+			return
+		}
+	}
+
+	groups, err := buildMediaList(r.Context(), configs, s.cfg.Server)
+	if err != nil {
+		message, status := libraryError(err)
+		writeJSONError(w, message, status)
+		return
+	}
+	for i, group := range groups {
+		if isCollection(configs[i]) {
+			continue
+		}
+		for _, file := range group.Files {
+			if file.ID == id {
+				s.writeAPIFile(w, r, configs[i], file)
+				return
+			}
+		}
+	}
+	writeJSONError(w, "no such file", http.StatusNotFound)
+}
+
+// filebyid walks one category for the file with the given id.
+func (s *server) fileByID(r *http.Request, config CategoryConfig, id string) (MediaFile, bool, error) {
+	ctx, cancel := walkContext(r.Context(), s.cfg.Server)
+	defer cancel()
+	group, err := walkCategory(ctx, config, s.cfg.Server)
+	if err != nil {
+		return MediaFile{}, false, err
+	}
+	for _, file := range group.Files {
+		if file.ID == id {
+			return file, true, nil
+		}
+	}
+	return MediaFile{}, false, nil
+}
+
+// writeapifile answers with a file and the url to fetch it from, its
+// permanent /id/ link.
+func (s *server) writeAPIFile(w http.ResponseWriter, r *http.Request, config CategoryConfig, file MediaFile) {
+	if !s.authorize(w, r, config) {
+		return
+	}
+	writeJSON(w, http.StatusOK, fileResponse{
+		APIVersion: apiVersion,
+		Category:   config.Name,
+		URL:        s.cfg.Server.BasePath + "/id/" + file.ID,
+		File:       file,
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// maxsearchresults caps how many matches a search shows, best first.
+const maxSearchResults = 200
+
+// how well a file matches a search, best first
+const (
+	matchName = iota
+	matchPath
+	matchFuzzy
+)
+
+// searchresult is a file that matched a search, with the category it's in.
+type searchResult struct {
+	Category string
+	Title    string
+	File     MediaFile
+	rank     int
+}
+
+// searchdata is what the search page renders.
+type searchData struct {
+	SiteTitle string
+	BasePath  string
+	Offline   bool
+	Theme     string
+	Query     string
+	Results   []searchResult
+	Truncated bool
+	External  *externalLink
+	Transcode bool
+}
+
+// matchfile reports how well a file matches the lowercased query, if at all.
+// the whole query in the file's name is the best match, then every word of it
+// somewhere in the path, then the query's letters appearing in order, so
+// "bhit" still finds best/hit.mp3.
+func matchFile(query string, file MediaFile) (int, bool) {
+	name := strings.ToLower(file.DisplayName())
+	p := strings.ToLower(file.Path)
+	if strings.Contains(name, query) || strings.Contains(strings.ToLower(file.Name), query) {
+		return matchName, true
+	}
+	all := true
+	for _, word := range strings.Fields(query) {
+		if !strings.Contains(p, word) {
+			all = false
+			break
+		}
+	}
+	if all {
+		return matchPath, true
+	}
+	if inOrder(strings.Join(strings.Fields(query), ""), p) {
+		return matchFuzzy, true
+	}
+	return 0, false
+}
+
+// inorder reports whether every rune of want appears in s, in order.
+func inOrder(want, s string) bool {
+	for _, r := range want {
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(string(r)):]
+	}
+	return true
+}
+
+// searchgroups finds the files matching query in groups, best matches first
+// and shorter paths before longer ones, reporting whether there were more than
+// maxsearchresults.
+func searchGroups(groups []MediaGroup, query string) ([]searchResult, bool) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil, false
+	}
+	var results []searchResult
+	for _, group := range groups {
+		for _, file := range group.Files {
+			if rank, ok := matchFile(query, file); ok {
+				results = append(results, searchResult{Category: group.Name, Title: group.Heading(), File: file, rank: rank})
+			}
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		return len(a.File.Path) < len(b.File.Path)
+	})
+	if len(results) > maxSearchResults {
+		return results[:maxSearchResults], true
+	}
+	return results, false
+}
+
+// handlesearch looks for files by name and path across every category the
+// request may see, at /search?q=. collections are left out, since their files
+// are already found in the categories they come from.
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	data := searchData{
+		SiteTitle: s.cfg.Server.SiteTitle,
+		BasePath:  s.cfg.Server.BasePath,
+		Offline:   s.cfg.Server.Offline,
+		Theme:     s.theme(w, r),
+		Query:     query,
+		External:  s.external(r),
+		Transcode: s.cfg.Server.Transcode,
+	}
+
+	if strings.TrimSpace(query) != "" {
+		var configs []CategoryConfig
+		for _, config := range s.categories(r) {
+			if !isCollection(config) {
+				configs = append(configs, config)
+			}
+		}
+		groups, err := buildMediaList(r.Context(), configs, s.cfg.Server)
+		if err != nil {
+			message, status := libraryError(err)
+			http.Error(w, message, status)
+			return
+		}
+		data.Results, data.Truncated = searchGroups(groups, query)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.searchTmpl.Execute(w, data); err != nil {
+		log.Println("Error executing template:", err)
+	}
+}
+
+// html template for search results
+const searchTemplate = `
+<!DOCTYPE html>
+<html lang="en"{{if ne .Theme "auto"}} data-bs-theme="{{.Theme}}"{{end}}>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    {{if .Offline}}<link href="{{.BasePath}}/assets/bootstrap.min.css" rel="stylesheet">{{else}}<link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.0-alpha3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-KK94CHFLLe+nY2dmCWGMq91rCGa5gtU4mk92HdvYe+M/SXH301p5ILy+dN9+nJOZ" crossorigin="anonymous">{{end}}
+		<title>{{if .Query}}{{.Query}} - {{end}}{{.SiteTitle}}</title>
+    {{template "icons"}}
+    {{template "theme" .}}
+</head>
+<body>
+<div class="container-fluid">
+    <div class="row">
+        <div class="col">
+            <h1><a href="{{.BasePath}}/">{{.SiteTitle}}</a></h1>
+            {{template "theme-switch"}}
+            {{template "search-box" .}}
+        </div>
+    </div>
+    <div class="row">
+        <div class="col">
+            {{if .Query}}
+            <p class="text-muted">{{if .Truncated}}the first {{len .Results}} matches{{else}}{{len .Results}} {{if eq (len .Results) 1}}match{{else}}matches{{end}}{{end}}</p>
+            {{end}}
+            <ul>
+                {{range .Results}}
+                <li>
+                    <i class="{{icon .File.Path}}" aria-hidden="true"></i><a href="{{$.BasePath}}/{{.File.Path}}" name="{{.File.Path}}" title="{{.File.Path}}" target="_blank">{{.File.DisplayName}}</a>
+                    <small class="text-muted">{{.Title}} / {{.File.Path}}</small>
+                    {{if or (eq .File.Kind "audio") (eq .File.Kind "video")}}<small><a href="{{$.BasePath}}/play/{{.Category}}/{{.File.Path}}">play</a></small>{{end}}
+                    {{if and $.Transcode (eq .File.Kind "video")}}<small><a href="{{$.BasePath}}/transcode/{{.File.Path}}" target="_blank">mp4</a></small>{{end}}
+                    {{if .File.ID}}<small><a href="{{$.BasePath}}/id/{{.File.ID}}" title="permanent link">link</a></small>{{end}}
+                    {{if $.External}}<small><a href="{{$.External.Link .File.Path}}">open</a></small>{{end}}
+                    {{if .File.Duration}}<small class="text-muted">{{duration .File.Duration}}</small>{{end}}
+                </li>
+                {{end}}
+            </ul>
+        </div>
+    </div>
+</div>
+</body>
+</html>
+`
+
+// searchbox is the search form, at the top of the listing and the results.
+const searchBox = `
+{{define "search-box"}}
+<form action="{{.BasePath}}/search" method="get" role="search" class="my-2">
+    <input type="search" name="q" value="{{.Query}}" class="form-control form-control-sm" placeholder="search" aria-label="search">
+</form>
+{{end}}
+`
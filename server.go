@@ -0,0 +1,610 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// server holds the loaded configuration and the handlers built from it.
+type server struct {
+	cfg            *Config
+	fileServers    map[string]http.Handler
+	indexTmpl      *template.Template
+	browseTmpl     *template.Template
+	notFoundTmpl   *template.Template
+	duplicatesTmpl *template.Template
+	playTmpl       *template.Template
+	searchTmpl     *template.Template
+	tagsTmpl       *template.Template
+	playlistsTmpl  *template.Template
+
+	// streams holds a slot per file transfer in progress, nil without MaxConcurrentStreams
+	streams chan struct{}
+
+	// accesslog records every file served, nil without AccessLogFile
+	accessLog *accessLog
+
+	// login is the login every request needs, nil without AuthUser and AuthHash
+	login *siteLogin
+
+	// playlists holds the playlists people made, nil without PlaylistFile
+	playlists *playlistStore
+
+	// inflight counts the requests the reloader is serving with this server,
+	// so what a reload replaced is only closed once they're done
+	inFlight sync.WaitGroup
+}
+
+// libraryerrormessage is shown to clients when a walk fails. the underlying error is
+// only logged, since it contains absolute paths from the config.
+const libraryErrorMessage = "the media library could not be read"
+
+// librarytimeoutmessage is shown to clients when a walk runs past WalkTimeout.
+const libraryTimeoutMessage = "the media library took too long to read"
+
+// libraryerror logs a failed walk and returns the message and status to send
+// the client, a 504 when the walk timed out and a 500 otherwise.
+func libraryError(err error) (string, int) {
+	log.Println("Error reading media library:", err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return libraryTimeoutMessage, http.StatusGatewayTimeout
+	}
+	return libraryErrorMessage, http.StatusInternalServerError
+}
+
+// templatefuncs are the helper functions available to the html templates.
+var templateFuncs = template.FuncMap{
+	"duration": formatDuration,
+	"size":     formatSize,
+	"icon":     iconClass,
+}
+
+// newserver creates a server for the given configuration.
+func newServer(cfg *Config) *server {
+	s := &server{
+		cfg:            cfg,
+		fileServers:    make(map[string]http.Handler),
+		indexTmpl:      template.Must(template.Must(template.Must(template.Must(template.Must(template.New("index").Funcs(templateFuncs).Parse(indexTemplate)).Parse(themeHead)).Parse(iconStyle)).Parse(searchBox)).Parse(playlistPicker)),
+		browseTmpl:     template.Must(template.Must(template.Must(template.New("browse").Funcs(templateFuncs).Parse(browseTemplate)).Parse(themeHead)).Parse(iconStyle)),
+		notFoundTmpl:   template.Must(template.New("notfound").Parse(notFoundTemplate)),
+		duplicatesTmpl: template.Must(template.Must(template.New("duplicates").Funcs(templateFuncs).Parse(duplicatesTemplate)).Parse(themeHead)),
+		playTmpl:       template.Must(template.Must(template.New("play").Parse(playTemplate)).Parse(themeHead)),
+		searchTmpl:     template.Must(template.Must(template.Must(template.Must(template.New("search").Funcs(templateFuncs).Parse(searchTemplate)).Parse(themeHead)).Parse(iconStyle)).Parse(searchBox)),
+		tagsTmpl:       template.Must(template.Must(template.Must(template.New("tags").Funcs(templateFuncs).Parse(tagsTemplate)).Parse(themeHead)).Parse(iconStyle)),
+		playlistsTmpl:  template.Must(template.Must(template.Must(template.New("playlists").Funcs(templateFuncs).Parse(playlistsTemplate)).Parse(themeHead)).Parse(iconStyle)),
+		login:          newSiteLogin(cfg.Server),
+	}
+
+	if n := cfg.Server.MaxConcurrentStreams; n > 0 {
+		s.streams = make(chan struct{}, n)
+	}
+
+	// create file server handlers for each directory, archives serve their own
+	// members and collections have no directory
+	for _, config := range cfg.Categories {
+		if isArchive(config.Directory) || isCollection(config) {
+			continue
+		}
+		for _, dir := range config.roots() {
+			s.fileServers[dir] = http.FileServer(http.Dir(dir))
+		}
+	}
+
+	return s
+}
+
+// routes registers every endpoint and returns the root handler.
+func (s *server) routes() http.Handler {
+
+	// create the mux that all handlers are registered on
+	mux := http.NewServeMux()
+
+	// expose prometheus metrics when enabled in the config
+	if s.cfg.Server.Metrics {
+		mux.Handle("/metrics", metrics)
+	}
+
+	// serve the user's stylesheet so it can override the defaults
+	if s.cfg.Server.CustomCSS != "" {
+		mux.HandleFunc("/assets/custom.css", s.handleCustomCSS)
+	}
+
+	// serve the embedded stylesheets for offline use
+	mux.Handle("/assets/", assetHandler())
+
+	// serve the favicon directly instead of walking the library for it
+	mux.HandleFunc("/favicon.ico", handleFavicon)
+
+	// expose the listing as json and push library changes over a websocket,
+	// /api/v1/media names the version for clients that want to pin it
+	mux.HandleFunc("/api/media", s.cors(s.handleAPIMedia))
+	mux.HandleFunc("/api/v1/media", s.cors(s.handleAPIMedia))
+	mux.HandleFunc("/ws", s.handleWebSocket)
+
+	// the same library a piece at a time, for clients that bring their own pages
+	for _, prefix := range []string{"/api/", "/api/v1/"} {
+		mux.HandleFunc(prefix+"categories", s.cors(s.handleAPICategories))
+		mux.HandleFunc(prefix+"categories/", s.cors(s.handleAPICategories))
+		if s.cfg.Server.StableIDs {
+			mux.HandleFunc(prefix+"files/", s.cors(s.handleAPIFile))
+		}
+	}
+
+	// show the parsed config to the admin, only when an admin login is configured
+	if s.cfg.Server.AdminUser != "" && s.cfg.Server.AdminPass != "" {
+		mux.HandleFunc("/admin/config", s.handleAdminConfig)
+	}
+
+	// play a single file in the page, with its neighbours a click away
+	mux.HandleFunc("/play/", s.handlePlay)
+
+	// find files by name across every category
+	mux.HandleFunc("/search", s.handleSearch)
+
+	// browse audio by its tags rather than its folders, which needs the tags read
+	if s.cfg.Server.Tags {
+		mux.HandleFunc("/artists", s.handleArtists)
+		mux.HandleFunc("/albums", s.handleAlbums)
+	}
+
+	// make playlists and keep them in PlaylistFile, played through the player
+	if s.playlists != nil {
+		mux.HandleFunc("/playlists", s.cors(s.handlePlaylists))
+		mux.HandleFunc("/playlists/", s.cors(s.handlePlaylists))
+		for _, prefix := range []string{"/api/", "/api/v1/"} {
+			mux.HandleFunc(prefix+"playlists", s.cors(s.handleAPIPlaylists))
+			mux.HandleFunc(prefix+"playlists/", s.cors(s.handleAPIPlaylists))
+		}
+	}
+
+	// let the browser send credentials for private categories
+	mux.HandleFunc("/login", s.handleLogin)
+
+	// let whole categories be downloaded as a zip, except on a kiosk
+	if !s.cfg.Server.Kiosk {
+		mux.HandleFunc("/download/", s.handleDownload)
+	}
+
+	// serve sidecar subtitles as webvtt for video players
+	if s.cfg.Server.Subtitles {
+		mux.HandleFunc("/subtitles/", s.cors(s.handleSubtitles))
+	}
+
+	// publish the newest files as a podcast-style feed
+	mux.HandleFunc("/feed.xml", s.cors(s.handleFeed))
+
+	// list every file's url as plain text for download scripts
+	mux.HandleFunc("/list.txt", s.cors(s.handleList))
+
+	// list a category's sha-256 sums for sha256sum -c, hashing is expensive so it's opt-in
+	if s.cfg.Server.Checksums && !s.cfg.Server.Kiosk {
+		mux.HandleFunc("/checksums/", s.handleChecksums)
+	}
+
+	// hand out a random playlist across every category
+	mux.HandleFunc("/shuffle.m3u", s.cors(s.handleShuffle))
+
+	// export the library, a group or a playlist for players like vlc
+	mux.HandleFunc("/playlist.m3u", s.cors(s.handlePlaylistM3U))
+	mux.HandleFunc("/playlist.m3u8", s.cors(s.handlePlaylistM3U))
+
+	// convert files to mp4 or audio formats on the fly, which only works with ffmpeg installed
+	if s.cfg.Server.Transcode {
+		if _, ok := ffmpegPath(s.cfg.Server); !ok {
+			log.Println("Transcode is enabled but ffmpeg was not found, install it or set FFmpeg")
+		}
+		mux.HandleFunc("/transcode/", s.handleTranscode)
+	}
+
+	// cut videos into hls segments for safari and tvs, made with ffmpeg as they're asked for
+	if s.cfg.Server.HLS {
+		if _, ok := ffmpegPath(s.cfg.Server); !ok {
+			log.Println("HLS is enabled but ffmpeg was not found, install it or set FFmpeg")
+		}
+		mux.HandleFunc("/hls/", s.cors(s.handleHLS))
+	}
+
+	// video thumbnails for the grid and hover previews for video players, also
+	// made with ffmpeg
+	if s.cfg.Server.Sprites || s.cfg.Server.Thumbnails {
+		if _, ok := ffmpegPath(s.cfg.Server); !ok {
+			log.Println("Sprites or Thumbnails is enabled but ffmpeg was not found, install it or set FFmpeg")
+		}
+		mux.HandleFunc("/thumbs/", s.cors(s.handleThumbs))
+	}
+
+	// permanent links to files by their stable id
+	if s.cfg.Server.StableIDs {
+		mux.HandleFunc("/id/", s.handleID)
+	}
+
+	// browse a category one directory at a time, or look for duplicate files
+	// across them. a kiosk only shows the player
+	if !s.cfg.Server.Kiosk {
+		mux.HandleFunc("/browse/", s.handleBrowse)
+		mux.HandleFunc("/index/", s.cors(s.handleDirIndex))
+		mux.HandleFunc("/duplicates", s.handleDuplicates)
+	}
+
+	// everything else is either a media file, the listing, or not found
+	mux.HandleFunc("/", s.handleRoot)
+
+	// serve everything under the base path when hosted on a reverse proxy subpath
+	var handler http.Handler = mux
+	if base := s.cfg.Server.BasePath; base != "" {
+		prefixed := http.NewServeMux()
+		prefixed.Handle(base+"/", http.StripPrefix(base, mux))
+		prefixed.Handle(base, http.RedirectHandler(base+"/", http.StatusMovedPermanently))
+		handler = prefixed
+	}
+
+	// the server's login, when there is one, comes before every route but
+	// the cors preflights, which browsers send without credentials
+	return metrics.middleware(s.withHeaders(recoverPanics(s.preflight(s.requireLogin(handler)))))
+}
+
+// handleroot serves media files, the listing at /, and a 404 for anything else.
+func (s *server) handleRoot(w http.ResponseWriter, r *http.Request) {
+
+	// check if the request is a specific file
+	if s.serveFile(w, r) {
+		return
+	}
+
+	// only the root path renders the listing, other directories go by DirectoryRequests
+	if r.URL.Path != "/" {
+		if !s.serveDirectory(w, r) {
+			s.notFound(w, r)
+		}
+		return
+	}
+
+	s.handleIndex(w, r)
+}
+
+// servefile serves the requested path from the first category that has it.
+// it reports whether a file was found and served.
+func (s *server) serveFile(w http.ResponseWriter, r *http.Request) bool {
+
+	// hidden files are not listed, so they are not served either
+	if !s.cfg.Server.ShowHidden && hasHiddenSegment(r.URL.Path) {
+		return false
+	}
+
+	config, _, ok := s.locate(r, r.URL.Path)
+	if !ok {
+		return false
+	}
+	if !s.authorize(w, r, config) {
+		return true
+	}
+	return s.serveFrom(w, r, config)
+}
+
+// servefrom serves r.URL.Path from one category, reporting false when an
+// archive doesn't have it. the transfer counts against MaxConcurrentStreams.
+func (s *server) serveFrom(w http.ResponseWriter, r *http.Request, config CategoryConfig) bool {
+	withoutDeadline(w)
+	if isArchive(config.Directory) {
+		if a, err := openArchive(config.Directory); err == nil {
+			if member, ok := a.member(r.URL.Path); ok {
+				if release, ok := s.startStream(w, r); ok {
+					defer release()
+					w, done := s.track(w, r)
+					defer done()
+					if isPlay(r) && isPlayable(MediaFile{Path: r.URL.Path}) {
+						database.played(r.URL.Path)
+					}
+					a.serve(w, r, member)
+				}
+				return true
+			}
+		}
+		return false
+	}
+	release, ok := s.startStream(w, r)
+	if !ok {
+		return true
+	}
+	defer release()
+	w, done := s.track(w, r)
+	defer done()
+	if isPlay(r) && isPlayable(MediaFile{Path: r.URL.Path}) {
+		database.played(r.URL.Path)
+	}
+	fs := s.fileServers[config.partWith(strings.TrimPrefix(r.URL.Path, "/")).Directory]
+	fs.ServeHTTP(w, r)
+	return true
+}
+
+// locate finds the first category with a regular file at urlpath, returning the
+// category and the file's path on disk, or inside the archive. categories the request may access are
+// preferred, and a private one is only returned, for the caller to refuse, when
+// no other category has the file.
+func (s *server) locate(r *http.Request, urlPath string) (CategoryConfig, string, bool) {
+	return s.find(r, urlPath, s.hasFile)
+}
+
+// find is locate with the test for what counts as found left to has.
+func (s *server) find(r *http.Request, urlPath string, has func(config CategoryConfig, filePath, urlPath string) bool) (CategoryConfig, string, bool) {
+	var denied CategoryConfig
+	deniedPath := ""
+	for _, config := range s.cfg.Categories {
+
+		// a collection's files are found in the categories it draws on, and a
+		// category outside its visibility window serves nothing
+		if isCollection(config) || !config.visible() {
+			continue
+		}
+
+		// a glob category looks in its directories in turn, and the one
+		// that has the path is what's returned
+		for _, part := range config.parts() {
+			filePath, ok := resolveInCategory(part.Directory, urlPath)
+			if !ok || !has(part, filePath, urlPath) {
+				continue
+			}
+			if canAccess(r, part) {
+				return part, filePath, true
+			}
+			if deniedPath == "" {
+				denied, deniedPath = part, filePath
+			}
+			break
+		}
+	}
+	if deniedPath != "" {
+		return denied, deniedPath, true
+	}
+	return CategoryConfig{}, "", false
+}
+
+// hasfile reports whether a category has a regular file at urlpath, which is
+// filepath on disk for a directory category.
+func (s *server) hasFile(config CategoryConfig, filePath, urlPath string) bool {
+	if isArchive(config.Directory) {
+		a, err := openArchive(config.Directory)
+		if err != nil {
+			return false
+		}
+		_, ok := a.member(urlPath)
+		return ok
+	}
+	fileInfo, err := os.Stat(filePath)
+	return err == nil && !fileInfo.IsDir()
+}
+
+// hasdir reports whether a category has a directory at urlpath that browse
+// would list, so not the category root, hidden or past its depth limit.
+func (s *server) hasDir(config CategoryConfig, filePath, urlPath string) bool {
+	sub := strings.Trim(path.Clean("/"+urlPath), "/")
+	if sub == "" || (config.MaxDepth > 0 && pathDepth(sub) > config.MaxDepth) {
+		return false
+	}
+	if isArchive(config.Directory) {
+		a, err := openArchive(config.Directory)
+		if err != nil {
+			return false
+		}
+		info, err := fs.Stat(a.reader, archiveName(sub))
+		return err == nil && info.IsDir()
+	}
+	info, err := os.Stat(filePath)
+	return err == nil && info.IsDir()
+}
+
+// medialist builds the groups shown by the listing and the api, including the
+// synthetic recently added group when it's enabled. only the categories the
+// request may see are walked, so private files never reach recently added.
+func (s *server) mediaList(r *http.Request) ([]MediaGroup, error) {
+
+	// each directory is walked separately, and the resulting media files are grouped within mediagroup.
+	groups, err := buildMediaList(r.Context(), s.categories(r), s.cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	// show the most recently modified files first when enabled
+	if n := s.cfg.Server.RecentCount; n > 0 {
+		groups = append([]MediaGroup{recentlyAdded(groups, n)}, groups...)
+	}
+
+	// leave out categories with nothing to show when enabled
+	if s.cfg.Server.HideEmpty {
+		groups = withoutEmpty(groups)
+	}
+
+	return groups, nil
+}
+
+// indexdata is what the listing header and footer render.
+type indexData struct {
+	SiteTitle string
+	BasePath  string
+	Offline   bool
+	CustomCSS bool
+	Theme     string
+	Lazy      bool
+	Login     bool
+	External  *externalLink
+	Transcode bool
+
+	// recentname is the heading of recently added, whose files aren't played
+	// from a category of their own
+	RecentName string
+
+	// tags links to the artists and albums pages, which need Tags
+	Tags bool
+
+	// playlists adds the playlist menu and a + to add each file, which need PlaylistFile
+	Playlists bool
+
+	// query fills the search box, which always starts empty on the listing
+	Query string
+
+	// thumbnails names the categories whose videos have thumbnails for the
+	// grid, the ones read from a folder on disk. it's empty without Thumbnails
+	Thumbnails []string
+}
+
+// groupdata is what the listing renders for each group.
+type groupData struct {
+	BasePath  string
+	Category  string
+	Lazy      bool
+	Kiosk     bool
+	External  *externalLink
+	Transcode bool
+
+	// thumbnails is set when the group's videos have thumbnails for the grid
+	Thumbnails bool
+
+	// playlists is set when files can be added to playlists
+	Playlists bool
+	MediaGroup
+}
+
+// handleindex renders the full media listing. the head of the page goes out
+// before the library is walked, and every group is flushed as soon as it's
+// ready, so the browser can start drawing a large library right away. with
+// BufferListing the page is rendered in full first and sent with its length.
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+
+	// prepare the data to be passed to the template
+	data := indexData{
+		SiteTitle: s.cfg.Server.SiteTitle,
+		BasePath:  s.cfg.Server.BasePath,
+		Offline:   s.cfg.Server.Offline,
+		CustomCSS: s.cfg.Server.CustomCSS != "",
+		Theme:     s.theme(w, r),
+		Lazy:      s.cfg.Server.LazyLoad,
+		Login:     s.locked(r),
+		External:  s.external(r),
+		Transcode: s.cfg.Server.Transcode,
+
+		RecentName: recentlyAddedName,
+		Tags:       s.cfg.Server.Tags,
+		Playlists:  s.playlists != nil,
+	}
+	if s.cfg.Server.Thumbnails {
+		for _, config := range s.cfg.Categories {
+			if hasThumbnails(config) {
+				data.Thumbnails = append(data.Thumbnails, config.Name)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	// the streamed page is sent before the library is walked, so a head request
+	// needs nothing more. a buffered one is rendered to learn its length
+	var buf *bytes.Buffer
+	if s.cfg.Server.BufferListing {
+		buf = new(bytes.Buffer)
+	} else if r.Method == http.MethodHead {
+		return
+	}
+
+	// everything below writes to out and flushes, which does nothing when buffered
+	var out io.Writer = w
+	flush := func() { http.NewResponseController(w).Flush() }
+	if buf != nil {
+		out = buf
+		flush = func() {}
+	}
+
+	// a buffered page hasn't sent anything yet, so a failed render can still be a 500
+	fail := func(err error) {
+		log.Println("Error executing template:", err)
+		if buf != nil {
+			http.Error(w, "the listing could not be rendered", http.StatusInternalServerError)
+		}
+	}
+
+	// send the head and a loading notice straight away, so a slow library
+	// doesn't leave a blank page while it's walked
+	if err := s.indexTmpl.ExecuteTemplate(out, "header", data); err != nil {
+		fail(err)
+		return
+	}
+	flush()
+
+	// each group is written and flushed as soon as it's walked. a writer that
+	// can't flush still gets the whole page, just all at once
+	var renderErr error
+	writeGroup := func(group MediaGroup) error {
+		if s.cfg.Server.HideEmpty && len(group.Files) == 0 && !group.Offline {
+			return nil
+		}
+		renderErr = s.indexTmpl.ExecuteTemplate(out, "group", groupData{BasePath: data.BasePath, Category: playCategory(group), Lazy: data.Lazy, Kiosk: s.cfg.Server.Kiosk, External: data.External, Transcode: data.Transcode, Thumbnails: thumbnailsFor(data.Thumbnails, group), Playlists: data.Playlists, MediaGroup: group})
+		flush()
+		return renderErr
+	}
+
+	// recently added goes first and needs every category, so then the whole
+	// library is walked before any group is shown
+	var err error
+	if s.cfg.Server.RecentCount > 0 {
+		var groups []MediaGroup
+		if groups, err = s.mediaList(r); err == nil {
+			for _, group := range groups {
+				if err = writeGroup(group); err != nil {
+					break
+				}
+			}
+		}
+	} else {
+		err = walkInOrder(r.Context(), s.categories(r), s.cfg.Server, writeGroup)
+	}
+	if renderErr != nil {
+		fail(renderErr)
+		return
+	}
+
+	// a streamed page has already sent its status, so a failed walk can only be
+	// reported on the page. the real error, which names directories on disk, is
+	// only logged
+	status := http.StatusOK
+	if err != nil {
+		var message string
+		message, status = libraryError(err)
+		if err := s.indexTmpl.ExecuteTemplate(out, "error", message); err != nil {
+			fail(err)
+			return
+		}
+	}
+	if err := s.indexTmpl.ExecuteTemplate(out, "footer", data); err != nil {
+		fail(err)
+		return
+	}
+
+	// a buffered page goes out in one piece, with its length and the walk's status
+	if buf != nil {
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			w.Write(buf.Bytes())
+		}
+	}
+}
+
+// notfound writes a small 404 page for paths that match nothing.
+func (s *server) notFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+
+	data := struct{ SiteTitle, BasePath, Path string }{SiteTitle: s.cfg.Server.SiteTitle, BasePath: s.cfg.Server.BasePath, Path: r.URL.Path}
+	if err := s.notFoundTmpl.Execute(w, data); err != nil {
+		log.Println("Error executing template:", err)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testTree creates the named files, with any folders they're in, below a new
+// temporary directory and returns it. a name ending in / is an empty folder.
+func testTree(t testing.TB, names ...string) string {
+	t.Helper()
+	return testTreeAt(t, t.TempDir(), names...)
+}
+
+// testtreeat creates the named files below root, as testtree does, and
+// returns root.
+func testTreeAt(t testing.TB, root string, names ...string) string {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// testServer builds a server from an ini-style config, the way main does
+// after loading the file.
+func testServer(t testing.TB, config string) *server {
+	t.Helper()
+	cfg, err := ParseConfig(strings.NewReader(config))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	if err := checkSiteLogin(cfg.Server); err != nil {
+		t.Fatal(err)
+	}
+	registerMIMETypes(cfg.Server.MIMETypes)
+	return newServer(cfg)
+}
+
+// captureLog collects what's logged until the test ends, rather than
+// printing it.
+func captureLog(t testing.TB) *bytes.Buffer {
+	t.Helper()
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &logged
+}
+
+// request sends a request to h and returns the recorded response.
+func request(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestMissingFileIsNotFound(t *testing.T) {
+	dir := testTree(t, "song.mp3")
+	h := testServer(t, "[Music]\nDirectory="+dir+"\nFileTypes=.mp3\n").routes()
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/", http.StatusOK},
+		{"/song.mp3", http.StatusOK},
+		{"/nonexistent.mp3", http.StatusNotFound},
+		{"/no/such/folder/nonexistent.mp3", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := request(h, http.MethodGet, tt.target)
+		if w.Code != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.target, w.Code, tt.want)
+		}
+	}
+
+	// the 404 is a page of its own rather than the listing
+	w := request(h, http.MethodGet, "/nonexistent.mp3")
+	if strings.Contains(w.Body.String(), "song.mp3") {
+		t.Errorf("the 404 for /nonexistent.mp3 lists the library:\n%s", w.Body)
+	}
+}
+
+func TestResponsesHideDirectories(t *testing.T) {
+	captureLog(t)
+	music := testTree(t, "album/song.mp3")
+	gone := filepath.Join(t.TempDir(), "unplugged")
+	h := testServer(t, "[Music]\nDirectory="+music+"\nFileTypes=.mp3\n"+
+		"[Films]\nDirectory="+gone+"\nFileTypes=.mkv\n").routes()
+
+	for _, target := range []string{"/", "/api/media", "/api/categories", "/api/categories/Music", "/api/categories/Films", "/browse/Music/", "/browse/Music/album/", "/search?q=song", "/nonexistent.mp3"} {
+		body := request(h, http.MethodGet, target).Body.String()
+		for _, dir := range []string{music, gone} {
+			if strings.Contains(body, dir) {
+				t.Errorf("GET %s shows the directory %s:\n%s", target, dir, body)
+			}
+		}
+	}
+
+	// the category is still there by name, just not by path
+	if body := request(h, http.MethodGet, "/").Body.String(); !strings.Contains(body, "Music") || !strings.Contains(body, "song.mp3") {
+		t.Errorf("the listing lost the category along with its path:\n%s", body)
+	}
+}
+
+func TestHideEmpty(t *testing.T) {
+	music := testTree(t, "song.mp3")
+	empty := testTree(t, "notes.txt")
+	config := "[Music]\nDirectory=" + music + "\nFileTypes=.mp3\n[Podcasts]\nDirectory=" + empty + "\nFileTypes=.mp3\n"
+
+	for _, hide := range []bool{false, true} {
+		h := testServer(t, "[Server]\nHideEmpty="+strconv.FormatBool(hide)+"\n"+config).routes()
+		for _, target := range []string{"/", "/api/media", "/api/categories"} {
+			body := request(h, http.MethodGet, target).Body.String()
+			if !strings.Contains(body, "Music") {
+				t.Errorf("HideEmpty=%v: GET %s dropped the populated category:\n%s", hide, target, body)
+			}
+			if strings.Contains(body, "Podcasts") == hide {
+				t.Errorf("HideEmpty=%v: GET %s shows the empty category = %v:\n%s", hide, target, !hide, body)
+			}
+		}
+	}
+}
+
+func TestHead(t *testing.T) {
+	dir := testTree(t, "album/song.mp3")
+	h := testServer(t, "[Music]\nDirectory="+dir+"\nFileTypes=.mp3\n").routes()
+
+	// a file reports its size and date without sending them
+	w := request(h, http.MethodHead, "/album/song.mp3")
+	if w.Code != http.StatusOK {
+		t.Fatalf("HEAD /album/song.mp3 = %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len("album/song.mp3")) {
+		t.Errorf("Content-Length = %q, want the file's size %d", got, len("album/song.mp3"))
+	}
+	if w.Header().Get("Last-Modified") == "" {
+		t.Error("no Last-Modified")
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("HEAD sent a body:\n%s", w.Body)
+	}
+
+	// the listing and a zip answer as their get would, without the body
+	for _, target := range []string{"/", "/download/Music.zip"} {
+		get := request(h, http.MethodGet, target)
+		head := request(h, http.MethodHead, target)
+		if head.Code != get.Code || head.Header().Get("Content-Type") != get.Header().Get("Content-Type") {
+			t.Errorf("HEAD %s = %d %q, GET = %d %q", target, head.Code, head.Header().Get("Content-Type"), get.Code, get.Header().Get("Content-Type"))
+		}
+		if head.Body.Len() != 0 {
+			t.Errorf("HEAD %s sent a body:\n%s", target, head.Body)
+		}
+	}
+
+	// a missing file is still missing, it doesn't fall through to the listing
+	if w := request(h, http.MethodHead, "/album/nonexistent.mp3"); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD /album/nonexistent.mp3 = %d, want 404", w.Code)
+	}
+}
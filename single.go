@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// singlefileflags can't be combined with -file, which has no config to act on.
+var singleFileFlags = []string{"config", "dry-run", "kiosk", "strict"}
+
+// singlefilehandler serves one file at / to play in place, and at /download
+// as an attachment under its own name. the file is opened on every request,
+// so a file that's replaced while sharing is served as it is now.
+func singleFileHandler(path string) http.Handler {
+	name := filepath.Base(path)
+	serve := func(w http.ResponseWriter, r *http.Request, disposition string) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			http.Error(w, "file not available", http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			http.Error(w, "file not available", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": name}))
+		withoutDeadline(w)
+		http.ServeContent(w, r, name, info.ModTime(), f)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		serve(w, r, "inline")
+	})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, "attachment")
+	})
+	return mux
+}
+
+// servesinglefile shares path on the address from the flags, or the default
+// one, until the server stops or ctx is done. [Server] settings from the
+// environment still apply.
+func serveSingleFile(ctx context.Context, path string, listening listenFlags, open bool) error {
+	cfg := newConfigBuilder().cfg
+	if err := applyEnv(cfg, os.Environ()); err != nil {
+		return err
+	}
+	addr, err := listening.address(cfg.Server.Listen)
+	if err != nil {
+		return err
+	}
+	tlsCfg, err := tlsConfig(cfg.Server)
+	if err != nil {
+		return err
+	}
+	ln, err := listen(addr)
+	if err != nil {
+		return err
+	}
+
+	url := listenURL(ln, "", tlsCfg != nil)
+	fmt.Println(Ascii + url)
+
+	// a unix socket has no url to add the download path to
+	if !strings.HasPrefix(url, unixPrefix) {
+		fmt.Println("download: " + url + "download")
+	}
+	if open {
+		openBrowser(url)
+	}
+	return runServer(ctx, httpServer(cfg.Server, singleFileHandler(path)), ln, tlsCfg, cfg.Server.ShutdownTimeout)
+}
+
+// checksinglefile makes sure -file names a readable regular file and wasn't
+// given together with a flag that needs a config.
+func checkSingleFile(path string, set map[string]bool) error {
+	for _, name := range singleFileFlags {
+		if set[name] {
+			return fmt.Errorf("-file can't be used with -%s, it serves one file without a config", name)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// the orders a category can be sorted in. path is the order of the walk, with
+// folders in name order, and is what an unset SortBy means.
+const (
+	sortPath = "path"
+	sortName = "name"
+	sortDate = "date"
+	sortSize = "size"
+)
+
+// isvalidsort reports whether by is one of the known sort orders.
+func isValidSort(by string) bool {
+	switch by {
+	case sortPath, sortName, sortDate, sortSize:
+		return true
+	}
+	return false
+}
+
+// sortorder returns how a category is sorted. a category that sets SortBy
+// brings its own Reverse with it, otherwise the server's SortBy applies and
+// Reverse on either side turns it around.
+func sortOrder(config CategoryConfig, server ServerConfig) (string, bool) {
+	if config.SortBy != "" {
+		return config.SortBy, config.Reverse
+	}
+	return server.SortBy, server.Reverse || config.Reverse
+}
+
+// sortmedia puts a category's files in its configured order. ties keep the
+// walk order, so files with the same date or size stay in path order.
+func sortMedia(files []MediaFile, config CategoryConfig, server ServerConfig) {
+	by, reverse := sortOrder(config, server)
+	var less func(a, b MediaFile) bool
+	switch by {
+	case sortName:
+		less = func(a, b MediaFile) bool { return strings.ToLower(a.DisplayName()) < strings.ToLower(b.DisplayName()) }
+	case sortDate:
+		less = func(a, b MediaFile) bool { return a.ModTime.Before(b.ModTime) }
+	case sortSize:
+		less = func(a, b MediaFile) bool { return a.Size < b.Size }
+	default:
+		if reverse {
+			for i, j := 0, len(files)-1; i < j; i, j = i+1, j-1 {
+				files[i], files[j] = files[j], files[i]
+			}
+		}
+		return
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if reverse {
+			return less(files[j], files[i])
+		}
+		return less(files[i], files[j])
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// touch sets the modification times of files below dir, the first oldest.
+func touch(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range names {
+		mtime := start.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(name)), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSortOrder(t *testing.T) {
+	tests := []struct {
+		name        string
+		category    CategoryConfig
+		server      ServerConfig
+		wantBy      string
+		wantReverse bool
+	}{
+		{"nothing set", CategoryConfig{}, ServerConfig{}, "", false},
+		{"server order", CategoryConfig{}, ServerConfig{SortBy: sortDate, Reverse: true}, sortDate, true},
+		{"category order wins", CategoryConfig{SortBy: sortName}, ServerConfig{SortBy: sortDate, Reverse: true}, sortName, false},
+		{"category brings its reverse", CategoryConfig{SortBy: sortSize, Reverse: true}, ServerConfig{SortBy: sortDate}, sortSize, true},
+		{"category reverses the server", CategoryConfig{Reverse: true}, ServerConfig{SortBy: sortDate}, sortDate, true},
+	}
+	for _, tt := range tests {
+		by, reverse := sortOrder(tt.category, tt.server)
+		if by != tt.wantBy || reverse != tt.wantReverse {
+			t.Errorf("%s: sortOrder = %q, %v, want %q, %v", tt.name, by, reverse, tt.wantBy, tt.wantReverse)
+		}
+	}
+}
+
+func TestMixedSortOrders(t *testing.T) {
+	music := testTree(t, "b.mp3", "C.mp3", "a.mp3")
+	podcasts := testTree(t, "episode 1.mp3", "episode 3.mp3", "episode 2.mp3")
+	touch(t, podcasts, "episode 1.mp3", "episode 2.mp3", "episode 3.mp3")
+	films := testTree(t, "x.mkv", "y.mkv", "z.mkv")
+	touch(t, films, "y.mkv", "z.mkv", "x.mkv")
+
+	// music sorts by name ascending, podcasts newest first, and films flip
+	// the server's date order to newest first too
+	h := testServer(t, "[Server]\nSortBy=date\n"+
+		"[Music]\nDirectory="+music+"\nFileTypes=.mp3\nSortBy=name\n"+
+		"[Podcasts]\nDirectory="+podcasts+"\nFileTypes=.mp3\nSortBy=date\nReverse=true\n"+
+		"[Films]\nDirectory="+films+"\nFileTypes=.mkv\nReverse=true\n"+
+		"[Oldest]\nDirectory="+films+"\nFileTypes=.mkv\n").routes()
+
+	want := []listed{
+		{"Music", "a.mp3"}, {"Music", "b.mp3"}, {"Music", "C.mp3"},
+		{"Podcasts", "episode 3.mp3"}, {"Podcasts", "episode 2.mp3"}, {"Podcasts", "episode 1.mp3"},
+		{"Films", "x.mkv"}, {"Films", "z.mkv"}, {"Films", "y.mkv"},
+		{"Oldest", "y.mkv"}, {"Oldest", "z.mkv"}, {"Oldest", "x.mkv"},
+	}
+	if got := filesOf(getMedia(t, h, nil).Groups); !reflect.DeepEqual(got, want) {
+		t.Errorf("listing order\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestCategoryOrder(t *testing.T) {
+	var config string
+	for _, c := range []struct{ name, order string }{
+		{"Music", ""},
+		{"Movies", "-1"},
+		{"Podcasts", "2"},
+		{"Books", ""},
+		{"Shows", "-1"},
+		{"Audiobooks", "0"},
+		{"Home", "2"},
+	} {
+		config += "[" + c.name + "]\nDirectory=" + testTree(t, c.name+".mp3") + "\nFileTypes=.mp3\n"
+		if c.order != "" {
+			config += "Order=" + c.order + "\n"
+		}
+	}
+
+	// lower first, and within an order, defaults included, in config order
+	want := []string{"Movies", "Shows", "Music", "Books", "Audiobooks", "Podcasts", "Home"}
+	h := testServer(t, config).routes()
+	for i := 0; i < 5; i++ {
+		var got []string
+		for _, group := range getMedia(t, h, nil).Groups {
+			got = append(got, group.Name)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("categories in %v, want %v", got, want)
+		}
+	}
+
+	// the page lists them the same way
+	body := request(h, http.MethodGet, "/").Body.String()
+	last := -1
+	for _, name := range want {
+		at := strings.Index(body, name+".mp3")
+		if at < 0 || at < last {
+			t.Errorf("the page lists %s out of order", name)
+		}
+		last = at
+	}
+}
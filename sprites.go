@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sprite sheet layout: frames are fitted into cells of spriteWidth by
+// spriteHeight, spriteColumns to a row, and a sheet holds at most maxSprites
+// frames, taken at least minSpriteInterval apart
+const (
+	spriteWidth       = 160
+	spriteHeight      = 90
+	spriteColumns     = 10
+	maxSprites        = 100
+	minSpriteInterval = 10 * time.Second
+
+	// a sheet that takes longer than this is given up on
+	spriteTimeout = 5 * time.Minute
+
+	// the sheet is served next to the video's thumbnail, under the same name
+	spriteSheetExt = ".sprites.jpg"
+)
+
+// spritesheet is a grid of frames from one video, with the time between them.
+type spriteSheet struct {
+	jpeg     []byte
+	interval time.Duration
+	count    int
+	duration time.Duration
+}
+
+// sprites caches the sheets by path, size and modification time. a video
+// ffmpeg couldn't read caches an empty sheet until the file changes.
+var sprites = newFileCache[spriteSheet]("sprites")
+
+// spritemu makes sheets one at a time, since each one keeps ffmpeg busy
+// decoding the whole video.
+var spriteMu sync.Mutex
+
+// spritelayout picks how far apart the frames are and how many there are.
+// the frames are spread over the whole video, so longer videos have them
+// further apart; when the length isn't known they're minspriteinterval apart.
+func spriteLayout(duration time.Duration) (time.Duration, int) {
+	if duration <= 0 {
+		return minSpriteInterval, maxSprites
+	}
+	interval := (duration/maxSprites + time.Second - 1).Truncate(time.Second)
+	if interval < minSpriteInterval {
+		interval = minSpriteInterval
+	}
+	count := int((duration + interval - 1) / interval)
+	if count < 1 {
+		count = 1
+	}
+	return interval, count
+}
+
+// spriteargs returns the ffmpeg arguments that tile count frames from the
+// video at path, one every interval, into a single jpeg on stdout. only
+// keyframes are decoded, which is close enough for a preview and much faster.
+func spriteArgs(path string, interval time.Duration, count int) []string {
+	rows := (count + spriteColumns - 1) / spriteColumns
+	filter := fmt.Sprintf("fps=1/%d,scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,tile=%dx%d",
+		int(interval/time.Second), spriteWidth, spriteHeight, spriteWidth, spriteHeight, spriteColumns, rows)
+	return []string{
+		"-nostdin", "-loglevel", "error",
+		"-skip_frame", "nokey", "-i", path,
+		"-an", "-sn", "-vf", filter, "-frames:v", "1",
+		"-q:v", "5", "-f", "mjpeg", "pipe:1",
+	}
+}
+
+// makesprites runs ffmpeg for the sheet of the video at path.
+func makeSprites(ffmpeg, path string) spriteSheet {
+	spriteMu.Lock()
+	defer spriteMu.Unlock()
+
+	duration := probeDuration(path)
+	interval, count := spriteLayout(duration)
+
+	// the sheet outlives the request that asked for it, so a client going
+	// away doesn't stop it or leave a broken sheet in the cache
+	ctx, cancel := context.WithTimeout(context.Background(), spriteTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ffmpeg, spriteArgs(path, interval, count)...)
+	var stdout bytes.Buffer
+	var stderr strings.Builder
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("Error making sprites for %s: %v %s", path, err, strings.TrimSpace(stderr.String()))
+		return spriteSheet{}
+	}
+	return spriteSheet{jpeg: stdout.Bytes(), interval: interval, count: count, duration: duration}
+}
+
+// writespritevtt writes the webvtt that maps each stretch of the video to its
+// cell in the sheet at sheetURL, in the #xywh form players look for.
+func writeSpriteVTT(w *bytes.Buffer, sheet spriteSheet, sheetURL string) {
+	w.WriteString("WEBVTT\n")
+	for i := 0; i < sheet.count; i++ {
+		start := time.Duration(i) * sheet.interval
+		end := start + sheet.interval
+		if sheet.duration > 0 && end > sheet.duration {
+			end = sheet.duration
+		}
+		x, y := i%spriteColumns*spriteWidth, i/spriteColumns*spriteHeight
+		fmt.Fprintf(w, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), sheetURL, x, y, spriteWidth, spriteHeight)
+	}
+}
+
+// vtttimestamp formats d as hh:mm:ss.mmm.
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// servesprites answers for the hover previews of the video at filePath, the
+// sheet for a .sprites.jpg and the webvtt track pointing into it for a .vtt.
+// the sheet is made the first time either is asked for.
+func serveSprites(w http.ResponseWriter, r *http.Request, ffmpeg, filePath string, info os.FileInfo, ext string) {
+	sheet := sprites.get(filePath, info, func(string) spriteSheet { return makeSprites(ffmpeg, filePath) })
+	if len(sheet.jpeg) == 0 {
+		http.Error(w, "could not make previews", http.StatusInternalServerError)
+		return
+	}
+
+	// the vtt sits next to the sheet, so a relative link finds it
+	if ext == spriteSheetExt {
+		w.Header().Set("Content-Type", "image/jpeg")
+		http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(sheet.jpeg))
+		return
+	}
+	var vtt bytes.Buffer
+	writeSpriteVTT(&vtt, sheet, url.PathEscape(filepath.Base(filePath))+spriteSheetExt)
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(vtt.Bytes()))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// streamwait is how long a file transfer queues for a free slot before it's
+// turned away.
+const streamWait = 2 * time.Second
+
+// streamretryafter is the Retry-After, in seconds, sent with a refused transfer.
+const streamRetryAfter = "5"
+
+// startstream takes one of the MaxConcurrentStreams slots for a file transfer,
+// waiting up to streamWait for one to free up. when none does it answers 503
+// with Retry-After and reports false. otherwise the caller must call the
+// returned release once the transfer is done. head requests read nothing, so
+// they don't need a slot.
+func (s *server) startStream(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	if s.streams == nil || r.Method == http.MethodHead {
+		return func() {}, true
+	}
+	release := func() { <-s.streams }
+
+	timer := time.NewTimer(streamWait)
+	defer timer.Stop()
+	select {
+	case s.streams <- struct{}{}:
+		return release, true
+	case <-r.Context().Done():
+		return nil, false
+	case <-timer.C:
+		w.Header().Set("Retry-After", streamRetryAfter)
+		http.Error(w, "too many files are being streamed, try again shortly", http.StatusServiceUnavailable)
+		return nil, false
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// maxsubtitlesize caps how much of an srt file is read to convert it.
+const maxSubtitleSize = 4 << 20
+
+// subtitle is a sidecar subtitle file found next to a video.
+type Subtitle struct {
+	Path     string `json:"path"`
+	Language string `json:"language,omitempty"`
+}
+
+// issubtitle reports whether name has a subtitle extension chill can serve.
+func isSubtitle(name string) bool {
+	switch normalizeFileType(path.Ext(name)) {
+	case ".srt", ".vtt":
+		return true
+	}
+	return false
+}
+
+// subtitleindex maps a file's path without its extension to the subtitles that
+// share that stem.
+type subtitleIndex map[string][]Subtitle
+
+// add records a subtitle by its slash-separated path relative to the category.
+// movie.srt belongs to movie.mkv, and so does movie.en.srt, in english.
+func (idx subtitleIndex) add(relPath string) {
+	stem := strings.TrimSuffix(relPath, path.Ext(relPath))
+	idx[stem] = append(idx[stem], Subtitle{Path: relPath})
+
+	// a short second extension is taken as a language code, like en or pt-BR
+	if lang := path.Ext(stem); len(lang) >= 3 && len(lang) <= 7 && isLanguageTag(lang[1:]) {
+		base := strings.TrimSuffix(stem, lang)
+		idx[base] = append(idx[base], Subtitle{Path: relPath, Language: lang[1:]})
+	}
+}
+
+// islanguagetag reports whether s looks like a language code, so movie.2019.srt
+// isn't taken for a subtitle of movie.mkv.
+func isLanguageTag(s string) bool {
+	for _, r := range s {
+		if r != '-' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// attach gives every video in files the subtitles that share its stem.
+func (idx subtitleIndex) attach(files []MediaFile) {
+	if len(idx) == 0 {
+		return
+	}
+	for i, file := range files {
+		if file.Kind() != kindVideo {
+			continue
+		}
+		files[i].Subtitles = idx[strings.TrimSuffix(file.Path, path.Ext(file.Path))]
+	}
+}
+
+// handlesubtitles serves a sidecar subtitle file as webvtt, which is the only
+// format browsers accept for a <track>. srt files are converted on the fly.
+func (s *server) handleSubtitles(w http.ResponseWriter, r *http.Request) {
+	urlPath := strings.TrimPrefix(r.URL.Path, "/subtitles")
+	if !isSubtitle(urlPath) || (!s.cfg.Server.ShowHidden && hasHiddenSegment(urlPath)) {
+		s.notFound(w, r)
+		return
+	}
+	config, filePath, ok := s.locate(r, urlPath)
+	if !ok {
+		s.notFound(w, r)
+		return
+	}
+	if !s.authorize(w, r, config) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	vtt := normalizeFileType(path.Ext(urlPath)) == ".vtt"
+	if vtt && !isArchive(config.Directory) {
+		http.ServeFile(w, r, filePath)
+		return
+	}
+
+	f, err := openCategoryFile(config, strings.TrimPrefix(urlPath, "/"))
+	if err != nil {
+		s.notFound(w, r)
+		return
+	}
+	defer f.Close()
+	subtitles, err := io.ReadAll(io.LimitReader(f, maxSubtitleSize))
+	if err != nil {
+		http.Error(w, "could not read subtitles", http.StatusInternalServerError)
+		return
+	}
+	if vtt {
+		w.Write(subtitles)
+		return
+	}
+	w.Write(srtToVTT(subtitles))
+}
+
+// srttovtt converts srt subtitles to webvtt. the formats only differ in the
+// header and the decimal separator in cue timings.
+func srtToVTT(srt []byte) []byte {
+	srt = bytes.TrimPrefix(srt, []byte("\xef\xbb\xbf"))
+	srt = bytes.ReplaceAll(srt, []byte("\r\n"), []byte("\n"))
+
+	var out bytes.Buffer
+	out.WriteString("WEBVTT\n\n")
+	for _, line := range bytes.SplitAfter(srt, []byte("\n")) {
+
+		// only timing lines like 00:00:01,000 --> 00:00:04,000 use commas
+		if bytes.Contains(line, []byte("-->")) {
+			line = bytes.ReplaceAll(line, []byte(","), []byte("."))
+		}
+		out.Write(line)
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// audiotags holds the tag fields shown in the listing and the api.
+type audioTags struct {
+	Title       string
+	Artist      string
+	AlbumArtist string
+	Album       string
+	Track       int
+}
+
+// maxcommentsize caps how much of an id3v2 tag or a flac or ogg comment block
+// is read. cover art can be embedded in it, and the text fields come first
+// anyway, so a tag claiming to be larger is read only that far.
+const maxCommentSize = 1 << 20
+
+// tags caches parsed tags by path, size and modification time.
+var tags = newFileCache[audioTags]("tags")
+
+// readtags returns the tags of the audio file at path, or empty tags if the file
+// has none or the format isn't supported: id3 in mp3 files, and vorbis
+// comments in flac, ogg and opus files.
+func readTags(path string) audioTags {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".mp3", ".flac", ".ogg", ".oga", ".opus":
+	default:
+		return audioTags{}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return audioTags{}
+	}
+	defer f.Close()
+
+	switch ext {
+	case ".flac":
+		return readFLACTags(f)
+	case ".ogg", ".oga", ".opus":
+		return readOggTags(f)
+	}
+
+	// prefer id3v2 at the start of the file and fall back to id3v1 at the end
+	t := readID3v2(f)
+	if t.Title == "" || t.Artist == "" || t.Album == "" || t.Track == 0 {
+
+		// id3v1 has no album artist, so that one only comes from id3v2
+		v1 := readID3v1(f)
+		if t.Title == "" {
+			t.Title = v1.Title
+		}
+		if t.Artist == "" {
+			t.Artist = v1.Artist
+		}
+		if t.Album == "" {
+			t.Album = v1.Album
+		}
+		if t.Track == 0 {
+			t.Track = v1.Track
+		}
+	}
+	return t
+}
+
+// readid3v2 parses the title, artist, album artist, album and track frames
+// from an id3v2.2, 2.3 or 2.4 tag.
+func readID3v2(f *os.File) audioTags {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[:3]) != "ID3" {
+		return audioTags{}
+	}
+	version := header[3]
+	flags := header[5]
+	size := synchsafe(header[6:10])
+
+	// the size comes from the file, so a crafted one can't make a huge buffer;
+	// the frames past the cap are left unread
+	if size > maxCommentSize {
+		size = maxCommentSize
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(f, body); err != nil {
+		return audioTags{}
+	}
+
+	// an unsynchronised tag has a zero byte inserted after every 0xff
+	if flags&0x80 != 0 && version < 4 {
+		body = bytes.ReplaceAll(body, []byte{0xff, 0x00}, []byte{0xff})
+	}
+
+	// skip the extended header when present
+	if flags&0x40 != 0 && len(body) >= 4 {
+		extended := int(binary.BigEndian.Uint32(body[:4])) + 4
+		if version == 4 {
+			extended = synchsafe(body[:4])
+		}
+		if extended > len(body) {
+			return audioTags{}
+		}
+		body = body[extended:]
+	}
+
+	// v2.2 uses three character frame ids with three byte sizes
+	idLen, sizeLen, headerLen := 4, 4, 10
+	titleID, artistID, albumArtistID, albumID, trackID := "TIT2", "TPE1", "TPE2", "TALB", "TRCK"
+	if version == 2 {
+		idLen, sizeLen, headerLen = 3, 3, 6
+		titleID, artistID, albumArtistID, albumID, trackID = "TT2", "TP1", "TP2", "TAL", "TRK"
+	}
+
+	var t audioTags
+	for len(body) >= headerLen && body[0] != 0 {
+		id := string(body[:idLen])
+		sizeBytes := body[idLen : idLen+sizeLen]
+
+		var frameSize int
+		switch {
+		case version == 2:
+			frameSize = int(sizeBytes[0])<<16 | int(sizeBytes[1])<<8 | int(sizeBytes[2])
+		case version == 4:
+			frameSize = synchsafe(sizeBytes)
+		default:
+			frameSize = int(binary.BigEndian.Uint32(sizeBytes))
+		}
+		if frameSize <= 0 || headerLen+frameSize > len(body) {
+			break
+		}
+		data := body[headerLen : headerLen+frameSize]
+		body = body[headerLen+frameSize:]
+
+		switch id {
+		case titleID:
+			t.Title = decodeID3Text(data)
+		case artistID:
+			t.Artist = decodeID3Text(data)
+		case albumArtistID:
+			t.AlbumArtist = decodeID3Text(data)
+		case albumID:
+			t.Album = decodeID3Text(data)
+		case trackID:
+			t.Track = parseTrack(decodeID3Text(data))
+		}
+	}
+	return t
+}
+
+// readid3v1 parses the fixed-width fields at the end of the file. id3v1.1 keeps
+// the track number in the last byte of the comment, after a zero.
+func readID3v1(f *os.File) audioTags {
+	info, err := f.Stat()
+	if err != nil || info.Size() < 128 {
+		return audioTags{}
+	}
+	tag := make([]byte, 128)
+	if _, err := f.ReadAt(tag, info.Size()-128); err != nil || string(tag[:3]) != "TAG" {
+		return audioTags{}
+	}
+	t := audioTags{
+		Title:  latin1(bytes.TrimRight(tag[3:33], "\x00 ")),
+		Artist: latin1(bytes.TrimRight(tag[33:63], "\x00 ")),
+		Album:  latin1(bytes.TrimRight(tag[63:93], "\x00 ")),
+	}
+	if tag[125] == 0 && tag[126] != 0 {
+		t.Track = int(tag[126])
+	}
+	return t
+}
+
+// readflactags finds the vorbis comment block among the metadata blocks that
+// follow the flac marker.
+func readFLACTags(f *os.File) audioTags {
+	marker := make([]byte, 4)
+	if _, err := io.ReadFull(f, marker); err != nil || string(marker) != "fLaC" {
+		return audioTags{}
+	}
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(f, header); err != nil {
+			return audioTags{}
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		if blockType == 4 {
+			if size > maxCommentSize {
+				size = maxCommentSize
+			}
+			block := make([]byte, size)
+			if _, err := io.ReadFull(f, block); err != nil {
+				return audioTags{}
+			}
+			return parseVorbisComment(block)
+		}
+		if last {
+			return audioTags{}
+		}
+		if _, err := f.Seek(size, io.SeekCurrent); err != nil {
+			return audioTags{}
+		}
+	}
+}
+
+// readoggtags reads the comment header, the second packet of an ogg vorbis or
+// opus stream. packets can span pages, so the pages are read in order and
+// their segments put back together until it's complete.
+func readOggTags(f *os.File) audioTags {
+	r := io.LimitReader(f, maxCommentSize)
+	var packet []byte
+	packets := 0
+	header := make([]byte, 27)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil || string(header[:4]) != "OggS" {
+			break
+		}
+		lacing := make([]byte, header[26])
+		if _, err := io.ReadFull(r, lacing); err != nil {
+			break
+		}
+		for _, n := range lacing {
+			segment := make([]byte, n)
+			if _, err := io.ReadFull(r, segment); err != nil {
+				return oggComment(packet)
+			}
+			if packets == 1 {
+				packet = append(packet, segment...)
+			}
+
+			// a segment shorter than 255 bytes ends its packet
+			if n < 255 {
+				packets++
+				if packets == 2 {
+					return oggComment(packet)
+				}
+			}
+		}
+	}
+
+	// a comment cut short by the size limit still has its first fields
+	return oggComment(packet)
+}
+
+// oggcomment parses a vorbis or opus comment header packet.
+func oggComment(packet []byte) audioTags {
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x03vorbis")):
+		return parseVorbisComment(packet[7:])
+	case bytes.HasPrefix(packet, []byte("OpusTags")):
+		return parseVorbisComment(packet[8:])
+	}
+	return audioTags{}
+}
+
+// parsevorbiscomment reads the fields of a vorbis comment: a vendor string,
+// then a count of KEY=value strings, each with a little endian length. keys
+// are case insensitive, and a comment cut short keeps the fields before the cut.
+func parseVorbisComment(data []byte) audioTags {
+	var t audioTags
+	next := func() ([]byte, bool) {
+		if len(data) < 4 {
+			return nil, false
+		}
+		n := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if uint64(n) > uint64(len(data)) {
+			return nil, false
+		}
+		field := data[:n]
+		data = data[n:]
+		return field, true
+	}
+	if _, ok := next(); !ok || len(data) < 4 {
+		return t
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+
+	for i := uint32(0); i < count; i++ {
+		field, ok := next()
+		if !ok {
+			break
+		}
+		key, value, ok := strings.Cut(string(field), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		// only the first of a repeated field is kept, like with id3
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			if t.Title == "" {
+				t.Title = value
+			}
+		case "ARTIST":
+			if t.Artist == "" {
+				t.Artist = value
+			}
+		case "ALBUMARTIST", "ALBUM ARTIST":
+			if t.AlbumArtist == "" {
+				t.AlbumArtist = value
+			}
+		case "ALBUM":
+			if t.Album == "" {
+				t.Album = value
+			}
+		case "TRACKNUMBER":
+			if t.Track == 0 {
+				t.Track = parseTrack(value)
+			}
+		}
+	}
+	if t.Artist == "" {
+		t.Artist = t.AlbumArtist
+	}
+	return t
+}
+
+// parsetrack reads a track number, which is often written as 3/12.
+func parseTrack(s string) int {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "/")
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// decodeid3text decodes a text frame according to its leading encoding byte.
+// only the first value of a multi-value frame is returned.
+func decodeID3Text(data []byte) string {
+	if len(data) < 2 {
+		return ""
+	}
+	text := data[1:]
+
+	var s string
+	switch data[0] {
+	case 1, 2:
+
+		// utf-16 with a byte order mark, or big endian without one
+		bigEndian := data[0] == 2
+		if len(text) >= 2 && text[0] == 0xfe && text[1] == 0xff {
+			bigEndian, text = true, text[2:]
+		} else if len(text) >= 2 && text[0] == 0xff && text[1] == 0xfe {
+			bigEndian, text = false, text[2:]
+		}
+		units := make([]uint16, 0, len(text)/2)
+		for i := 0; i+1 < len(text); i += 2 {
+			u := binary.LittleEndian.Uint16(text[i:])
+			if bigEndian {
+				u = binary.BigEndian.Uint16(text[i:])
+			}
+			if u == 0 {
+				break
+			}
+			units = append(units, u)
+		}
+		s = string(utf16.Decode(units))
+	case 3:
+		s = string(text)
+	default:
+		s = latin1(text)
+	}
+
+	// cut at the first terminator
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+// latin1 converts iso-8859-1 bytes into a string.
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// synchsafe decodes a 28-bit integer stored in the low seven bits of four bytes.
+func synchsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
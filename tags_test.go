@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// id3v2tag builds an id3v2.3 tag holding a title frame, with the tag size
+// in its header set to claimed rather than the real one.
+func id3v2Tag(title string, claimed int) []byte {
+	var b bytes.Buffer
+	b.WriteString("ID3\x03\x00\x00")
+	b.Write([]byte{byte(claimed >> 21 & 0x7f), byte(claimed >> 14 & 0x7f), byte(claimed >> 7 & 0x7f), byte(claimed & 0x7f)})
+	frame := append([]byte{0x03}, title...)
+	b.WriteString("TIT2")
+	b.Write([]byte{0, 0, 0, byte(len(frame)), 0, 0})
+	b.Write(frame)
+	return b.Bytes()
+}
+
+func TestReadID3v2CapsTheTagSize(t *testing.T) {
+	dir := t.TempDir()
+	huge := 0x0fffffff
+
+	tests := []struct {
+		name    string
+		padding int
+		want    string
+	}{
+		// the claimed size is cut to maxcommentsize, and the frames before it are read
+		{"longer than the cap", maxCommentSize + 1024, "Song"},
+
+		// a file too short for even the capped size has no tags
+		{"shorter than the cap", 1024, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".mp3")
+			data := append(id3v2Tag("Song", huge), make([]byte, tt.padding)...)
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			// the header claims 256mb, which must not be allocated
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			got := readID3v2(f).Title
+			runtime.ReadMemStats(&after)
+			if got != tt.want {
+				t.Errorf("title = %q, want %q", got, tt.want)
+			}
+			if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 4*maxCommentSize {
+				t.Errorf("allocated %d bytes reading the tag", allocated)
+			}
+		})
+	}
+}
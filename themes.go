@@ -0,0 +1,55 @@
+package main
+
+import "net/http"
+
+// themecookie remembers the theme picked with ?theme= between visits.
+const themeCookie = "chill_theme"
+
+// the themes a page can be shown in. auto follows the browser's light or dark
+// preference, and falls back to light without javascript.
+const (
+	themeAuto  = "auto"
+	themeLight = "light"
+	themeDark  = "dark"
+)
+
+// isvalidtheme reports whether name is one of the known themes.
+func isValidTheme(name string) bool {
+	switch name {
+	case themeAuto, themeLight, themeDark:
+		return true
+	}
+	return false
+}
+
+// theme picks the theme for a page from ?theme=, then the cookie, then the
+// config. choosing a theme with the query parameter also saves it in the cookie.
+func (s *server) theme(w http.ResponseWriter, r *http.Request) string {
+	if name := r.URL.Query().Get("theme"); isValidTheme(name) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     themeCookie,
+			Value:    name,
+			Path:     s.cfg.Server.BasePath + "/",
+			MaxAge:   365 * 24 * 60 * 60,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return name
+	}
+	if c, err := r.Cookie(themeCookie); err == nil && isValidTheme(c.Value) {
+		return c.Value
+	}
+	if isValidTheme(s.cfg.Server.Theme) {
+		return s.cfg.Server.Theme
+	}
+	return themeAuto
+}
+
+// themehead sets data-bs-theme on the page. it is shared by the page templates
+// and goes inside <head>, so the page is drawn in the right colors from the start.
+const themeHead = `{{define "theme"}}{{if eq .Theme "auto"}}<script>
+        if (window.matchMedia && window.matchMedia("(prefers-color-scheme: dark)").matches) {
+            document.documentElement.setAttribute("data-bs-theme", "dark");
+        }
+    </script>{{end}}{{end}}
+{{define "theme-switch"}}<small class="text-muted">theme: <a href="?theme=light">light</a> · <a href="?theme=dark">dark</a> · <a href="?theme=auto">auto</a></small>{{end}}`
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// thumbnails are fitted into thumbWidth by thumbHeight, and a frame that takes
+// longer than thumbTimeout to find is given up on
+const (
+	thumbWidth   = 320
+	thumbHeight  = 180
+	thumbTimeout = time.Minute
+)
+
+// thumbnails caches each video's thumbnail by path, size and modification
+// time. a video ffmpeg couldn't read caches an empty one until the file changes.
+var thumbnails = newFileCache[[]byte]("thumbnails")
+
+// thumbslots lets a couple of thumbnails be made at once, since a grid asks
+// for a screenful of them together but each one keeps ffmpeg busy.
+var thumbSlots = make(chan struct{}, 2)
+
+// thumbnailargs returns the ffmpeg arguments that write the frame at offset
+// into the video at path as a jpeg on stdout.
+func thumbnailArgs(path string, offset time.Duration) []string {
+	filter := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2",
+		thumbWidth, thumbHeight, thumbWidth, thumbHeight)
+	return []string{
+		"-nostdin", "-loglevel", "error",
+		"-ss", fmt.Sprintf("%.3f", offset.Seconds()), "-i", path,
+		"-an", "-sn", "-vf", filter, "-frames:v", "1",
+		"-q:v", "5", "-f", "mjpeg", "pipe:1",
+	}
+}
+
+// makethumbnail runs ffmpeg for the thumbnail of the video at path, a tenth
+// of the way in to skip past black openings and titles. a video too short for
+// that, or whose length isn't known, gets its first frame.
+func makeThumbnail(ffmpeg, path string) []byte {
+	thumbSlots <- struct{}{}
+	defer func() { <-thumbSlots }()
+
+	offsets := []time.Duration{0}
+	if duration := probeDuration(path); duration > 0 {
+		offsets = []time.Duration{duration / 10, 0}
+	}
+	for _, offset := range offsets {
+
+		// like a sprite sheet, the thumbnail is kept even if the client goes away
+		ctx, cancel := context.WithTimeout(context.Background(), thumbTimeout)
+		cmd := exec.CommandContext(ctx, ffmpeg, thumbnailArgs(path, offset)...)
+		var stdout bytes.Buffer
+		var stderr strings.Builder
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		err := cmd.Run()
+		cancel()
+		if err == nil && stdout.Len() > 0 {
+			return stdout.Bytes()
+		}
+		if err != nil {
+			log.Printf("Error making a thumbnail for %s: %v %s", path, err, strings.TrimSpace(stderr.String()))
+			return nil
+		}
+	}
+	return nil
+}
+
+// hasthumbnails reports whether a category's videos can have thumbnails,
+// which takes them being files on disk that ffmpeg can read.
+func hasThumbnails(config CategoryConfig) bool {
+	return !isCollection(config) && !isArchive(config.Directory)
+}
+
+// thumbnailsfor reports whether group is one of the categories with thumbnails.
+// recently added isn't, as its files come from every category.
+func thumbnailsFor(categories []string, group MediaGroup) bool {
+	if playCategory(group) == "" {
+		return false
+	}
+	for _, name := range categories {
+		if name == group.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// handlethumbs serves pictures of a video made with ffmpeg:
+// /thumbs/{category}/{path}.jpg is its thumbnail, with Thumbnails, and with
+// Sprites /thumbs/{category}/{path}.vtt is a webvtt thumbnail track pointing
+// into the sheet at /thumbs/{category}/{path}.sprites.jpg. each is made the
+// first time it's asked for, and nothing is served when ffmpeg isn't installed.
+func (s *server) handleThumbs(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/thumbs/")
+	var ext string
+	switch {
+	case strings.HasSuffix(rest, spriteSheetExt):
+		ext = spriteSheetExt
+	case strings.HasSuffix(rest, ".vtt"):
+		ext = ".vtt"
+	case strings.HasSuffix(rest, ".jpg"):
+		ext = ".jpg"
+	}
+	wanted := s.cfg.Server.Thumbnails
+	if ext != ".jpg" {
+		wanted = s.cfg.Server.Sprites
+	}
+	name, relPath, _ := strings.Cut(strings.TrimSuffix(rest, ext), "/")
+	if ext == "" || !wanted || relPath == "" {
+		s.notFound(w, r)
+		return
+	}
+	relPath = strings.Trim(path.Clean("/"+relPath), "/")
+	if !s.cfg.Server.ShowHidden && hasHiddenSegment("/"+relPath) {
+		s.notFound(w, r)
+		return
+	}
+
+	// ffmpeg reads from disk, so videos inside archives have no pictures
+	config, ok := s.category(name)
+	if !ok || !hasThumbnails(config) {
+		s.notFound(w, r)
+		return
+	}
+	if !isAllowedFileType(relPath, config.FileTypes) || (MediaFile{Path: relPath}).Kind() != kindVideo {
+		s.notFound(w, r)
+		return
+	}
+	if !s.authorize(w, r, config) {
+		return
+	}
+	ffmpeg, ok := ffmpegPath(s.cfg.Server)
+	if !ok {
+		s.notFound(w, r)
+		return
+	}
+
+	filePath := filepath.Join(config.partWith(relPath).Directory, filepath.FromSlash(relPath))
+	info, err := os.Stat(filePath)
+	if err != nil || !info.Mode().IsRegular() {
+		s.notFound(w, r)
+		return
+	}
+	if ext != ".jpg" {
+		serveSprites(w, r, ffmpeg, filePath, info, ext)
+		return
+	}
+
+	thumb := thumbnails.get(filePath, info, func(string) []byte { return makeThumbnail(ffmpeg, filePath) })
+	if len(thumb) == 0 {
+		http.Error(w, "could not make a thumbnail", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(thumb))
+}
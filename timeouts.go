@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// the default timeouts drop clients that open a connection and never finish
+// sending their request, or leave it idle, without limiting how long a
+// response may take. ReadTimeout and WriteTimeout are off unless configured,
+// and a shutdown gives downloads half a minute to finish.
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultShutdownTimeout   = 30 * time.Second
+)
+
+// httpserver builds the http server with the configured timeouts.
+func httpServer(server ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: server.ReadHeaderTimeout,
+		ReadTimeout:       server.ReadTimeout,
+		WriteTimeout:      server.WriteTimeout,
+		IdleTimeout:       server.IdleTimeout,
+	}
+}
+
+// shutdown stops the server taking connections and waits for the requests in
+// flight, like a film being watched, to finish. after timeout, unless it's 0,
+// the ones still running are cut off. websockets aren't waited for, pages
+// reconnect to the next server by themselves.
+func shutdown(srv *http.Server, timeout time.Duration) error {
+	log.Println("Shutting down, letting the requests in flight finish")
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Cut off the requests still running after %v", timeout)
+		return srv.Close()
+	}
+	return err
+}
+
+// withoutdeadline lifts the read and write timeouts for a response that may
+// rightly take longer than them, such as a film or a zip of a whole category,
+// so WriteTimeout only bounds the pages and the api.
+func withoutDeadline(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+}
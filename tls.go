@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// tlsconfig loads the certificate and key named by TLSCert and TLSKey, or
+// returns nil when neither is set and chill serves plain http. they're loaded
+// before the banner, so a wrong path or a key that doesn't match stops startup.
+func tlsConfig(server ServerConfig) (*tls.Config, error) {
+	if server.TLSCert == "" && server.TLSKey == "" {
+		return nil, nil
+	}
+	if server.TLSCert == "" || server.TLSKey == "" {
+		return nil, errors.New("TLSCert and TLSKey must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(server.TLSCert, server.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the tls certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// runserver answers requests on ln, over https when tlsconfig is set, until
+// the server fails or ctx is done. then it shuts the server down, letting the
+// requests in flight finish, and returns nil once they have.
+func runServer(ctx context.Context, srv *http.Server, ln net.Listener, tlsConfig *tls.Config, shutdownTimeout time.Duration) error {
+	served := make(chan error, 1)
+	go func() {
+		if tlsConfig == nil {
+			served <- srv.Serve(ln)
+			return
+		}
+		srv.TLSConfig = tlsConfig
+
+		// the certificate is already in the config, so no files are named here
+		served <- srv.ServeTLS(ln, "", "")
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+		return shutdown(srv, shutdownTimeout)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// the audio bitrates in kbit/s that ?bitrate= may ask for
+const (
+	minTranscodeBitrate = 32
+	maxTranscodeBitrate = 320
+)
+
+// defaulttranscodeformat is what /transcode/ converts to without ?format=.
+const defaultTranscodeFormat = "mp4"
+
+// transcodeformat is a format /transcode/ converts to: the ffmpeg arguments
+// for its codecs and container, and the content type it's served as.
+type transcodeFormat struct {
+	contentType string
+	audioOnly   bool
+	codec       []string
+	container   []string
+}
+
+// transcodeformats are the formats ?format= can pick. mp4 keeps the video as
+// h.264, the rest are audio only, for files like flac that browsers such as
+// older safari won't play.
+var transcodeFormats = map[string]transcodeFormat{
+	"mp4": {
+		contentType: "video/mp4",
+		codec:       []string{"-c:a", "aac"},
+		container:   []string{"-movflags", "frag_keyframe+empty_moov+default_base_moof", "-f", "mp4"},
+	},
+	"mp3":  {contentType: "audio/mpeg", audioOnly: true, codec: []string{"-c:a", "libmp3lame"}, container: []string{"-f", "mp3"}},
+	"aac":  {contentType: "audio/aac", audioOnly: true, codec: []string{"-c:a", "aac"}, container: []string{"-f", "adts"}},
+	"opus": {contentType: "audio/ogg", audioOnly: true, codec: []string{"-c:a", "libopus"}, container: []string{"-f", "ogg"}},
+}
+
+// ffmpegpath finds ffmpeg at FFmpeg, or on the path when that isn't set,
+// reporting false when it isn't installed.
+func ffmpegPath(server ServerConfig) (string, bool) {
+	name := "ffmpeg"
+	if server.FFmpeg != "" {
+		name = server.FFmpeg
+	}
+	path, err := exec.LookPath(name)
+	return path, err == nil
+}
+
+// transcodeargs returns the ffmpeg arguments that turn the file at path into
+// format on stdout, in a form browsers can play while it's still being
+// written. audio files, and every format but mp4, are left without a video
+// track. a bitrate of 0 leaves the audio bitrate to ffmpeg.
+func transcodeArgs(path string, kind mediaKind, format transcodeFormat, bitrate int) []string {
+	args := []string{"-nostdin", "-loglevel", "error", "-i", path}
+	if kind == kindAudio || format.audioOnly {
+		args = append(args, "-vn")
+	} else {
+		args = append(args, "-map", "0:v:0?", "-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p")
+	}
+	args = append(args, "-map", "0:a:0?")
+	args = append(args, format.codec...)
+	args = append(args, "-ac", "2")
+	if bitrate > 0 {
+		args = append(args, "-b:a", strconv.Itoa(bitrate)+"k")
+	}
+	args = append(args, format.container...)
+	return append(args, "pipe:1")
+}
+
+// parsetranscodeformats reads a category's TranscodeFormats, which must all
+// be formats /transcode/ knows.
+func parseTranscodeFormats(key string, value configValue) ([]string, error) {
+	var formats []string
+	for _, v := range value.strings() {
+		format := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(v), ".")))
+		if format == "" {
+			continue
+		}
+		if _, ok := transcodeFormats[format]; !ok {
+			return nil, fmt.Errorf("invalid value for %s: %q", key, v)
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
+// allowstranscode reports whether a category's files may be converted to
+// format. without TranscodeFormats every format is allowed.
+func allowsTranscode(config CategoryConfig, format string) bool {
+	if len(config.TranscodeFormats) == 0 {
+		return true
+	}
+	for _, allowed := range config.TranscodeFormats {
+		if allowed == format {
+			return true
+		}
+	}
+	return false
+}
+
+// handletranscode streams a file converted to h.264 and aac in mp4 at
+// /transcode/{path}, the same path the file itself is served at, for codecs
+// browsers won't play. ?format=mp3, aac or opus converts to audio instead,
+// and ?bitrate=128 sets the audio bitrate in kbit/s. ffmpeg is killed as soon
+// as the client goes away.
+func (s *server) handleTranscode(w http.ResponseWriter, r *http.Request) {
+	urlPath := strings.TrimPrefix(r.URL.Path, "/transcode")
+	if !s.cfg.Server.ShowHidden && hasHiddenSegment(urlPath) {
+		s.notFound(w, r)
+		return
+	}
+
+	// ffmpeg reads from disk, so files inside archives can't be transcoded
+	config, filePath, ok := s.locate(r, urlPath)
+	if !ok || isArchive(config.Directory) || !isAllowedFileType(urlPath, config.FileTypes) {
+		s.notFound(w, r)
+		return
+	}
+	if !s.authorize(w, r, config) {
+		return
+	}
+
+	query := r.URL.Query()
+	name := strings.ToLower(query.Get("format"))
+	if name == "" {
+		name = defaultTranscodeFormat
+	}
+	format, ok := transcodeFormats[name]
+	if !ok {
+		http.Error(w, "format must be mp4, mp3, aac or opus", http.StatusBadRequest)
+		return
+	}
+	if !allowsTranscode(config, name) {
+		http.Error(w, name+" is not one of this category's TranscodeFormats", http.StatusForbidden)
+		return
+	}
+	bitrate := 0
+	if v := query.Get("bitrate"); v != "" {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(v), "k"))
+		if err != nil || n < minTranscodeBitrate || n > maxTranscodeBitrate {
+			http.Error(w, fmt.Sprintf("bitrate must be between %d and %d kbit/s", minTranscodeBitrate, maxTranscodeBitrate), http.StatusBadRequest)
+			return
+		}
+		bitrate = n
+	}
+
+	ffmpeg, ok := ffmpegPath(s.cfg.Server)
+	if !ok {
+		http.Error(w, "ffmpeg is not installed", http.StatusServiceUnavailable)
+		return
+	}
+
+	release, ok := s.startStream(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+	w, done := s.track(w, r)
+	defer done()
+
+	kind := MediaFile{Path: urlPath}.Kind()
+	contentType := format.contentType
+	if kind == kindAudio && name == "mp4" {
+		contentType = "audio/mp4"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// the request's context ends when the client disconnects, which kills ffmpeg
+	cmd := exec.CommandContext(r.Context(), ffmpeg, transcodeArgs(filePath, kind, format, bitrate)...)
+	cmd.Stdout = w
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	// send the headers now, the first output can take a moment
+	withoutDeadline(w)
+	http.NewResponseController(w).Flush()
+	if err := cmd.Run(); err != nil && r.Context().Err() == nil {
+		log.Printf("Error transcoding %s: %v %s", filePath, err, strings.TrimSpace(stderr.String()))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeofdaylayout is how VisibleFrom and VisibleUntil are written, like 21:00.
+const timeOfDayLayout = "15:04"
+
+// clock tells the time for the visibility windows. it's a variable so the
+// windows can be checked against a fixed time instead of the real one.
+var clock = time.Now
+
+// parsetimeofday checks a VisibleFrom or VisibleUntil value, returning it as
+// hh:mm. an empty value leaves that end of the window open.
+func parseTimeOfDay(key string, value configValue) (string, error) {
+	v, err := value.scalar(key)
+	if err != nil {
+		return "", err
+	}
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "", nil
+	}
+
+	// a single digit hour, like 9:00 or 0:30, is fine too
+	padded := v
+	if len(v) == len("9:00") && v[1] == ':' {
+		padded = "0" + v
+	}
+	t, err := time.Parse(timeOfDayLayout, padded)
+	if err != nil {
+		return "", fmt.Errorf("invalid value for %s: %q, expected a time like 21:00", key, v)
+	}
+	return t.Format(timeOfDayLayout), nil
+}
+
+// minuteofday returns how many minutes past midnight an hh:mm value is.
+func minuteOfDay(hhmm string) int {
+	t, _ := time.Parse(timeOfDayLayout, hhmm)
+	return t.Hour()*60 + t.Minute()
+}
+
+// visibleat reports whether a category is shown at t, in t's time zone. a
+// window runs from VisibleFrom up to but not including VisibleUntil, and one
+// ending earlier than it starts, like 21:00 to 06:00, runs past midnight. a
+// category with neither set is always shown.
+func (c CategoryConfig) visibleAt(t time.Time) bool {
+	if c.VisibleFrom == "" && c.VisibleUntil == "" {
+		return true
+	}
+	now := t.Hour()*60 + t.Minute()
+	from, until := 0, 24*60
+	if c.VisibleFrom != "" {
+		from = minuteOfDay(c.VisibleFrom)
+	}
+	if c.VisibleUntil != "" {
+		until = minuteOfDay(c.VisibleUntil)
+	}
+	if from <= until {
+		return from <= now && now < until
+	}
+	return now >= from || now < until
+}
+
+// visible reports whether a category is shown right now, in local time.
+func (c CategoryConfig) visible() bool {
+	return c.visibleAt(clock())
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// at returns a time on an ordinary day at hh:mm, in local time.
+func at(hhmm string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", "2024-03-05 "+hhmm, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// setClock makes clock return t until the test ends.
+func setClock(t *testing.T, now time.Time) {
+	t.Helper()
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = time.Now })
+}
+
+func TestParseTimeOfDay(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "21:00", want: "21:00"},
+		{value: " 06:30 ", want: "06:30"},
+		{value: "9:00", want: "09:00"},
+		{value: "0:30", want: "00:30"},
+		{value: "00:00", want: "00:00"},
+		{value: "23:59", want: "23:59"},
+		{value: "", want: ""},
+		{value: "24:00", wantErr: true},
+		{value: "9:60", wantErr: true},
+		{value: "9pm", wantErr: true},
+		{value: "21", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeOfDay("VisibleFrom", scalarValue(tt.value))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseTimeOfDay(%q) = %q, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseTimeOfDay(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestVisibleAt(t *testing.T) {
+	tests := []struct {
+		name        string
+		from, until string
+		now         string
+		want        bool
+	}{
+		// a window inside the day, from 07:00 up to 19:00
+		{"day, before", "07:00", "19:00", "06:59", false},
+		{"day, at the start", "07:00", "19:00", "07:00", true},
+		{"day, midday", "07:00", "19:00", "12:00", true},
+		{"day, at the end", "07:00", "19:00", "19:00", false},
+		{"day, night", "07:00", "19:00", "23:30", false},
+
+		// a window across midnight, from 21:00 up to 06:00
+		{"night, evening", "21:00", "06:00", "20:59", false},
+		{"night, at the start", "21:00", "06:00", "21:00", true},
+		{"night, before midnight", "21:00", "06:00", "23:59", true},
+		{"night, midnight", "21:00", "06:00", "00:00", true},
+		{"night, early", "21:00", "06:00", "05:59", true},
+		{"night, at the end", "21:00", "06:00", "06:00", false},
+		{"night, midday", "21:00", "06:00", "12:00", false},
+
+		// an open end is the start or the end of the day
+		{"from only, before", "21:00", "", "20:00", false},
+		{"from only, after", "21:00", "", "23:00", true},
+		{"until only, before", "", "09:00", "00:30", true},
+		{"until only, after", "", "09:00", "09:30", false},
+		{"always", "", "", "03:00", true},
+	}
+	for _, tt := range tests {
+		c := CategoryConfig{Name: "Cartoons", VisibleFrom: tt.from, VisibleUntil: tt.until}
+		if got := c.visibleAt(at(tt.now)); got != tt.want {
+			t.Errorf("%s: %s-%s at %s = %v, want %v", tt.name, tt.from, tt.until, tt.now, got, tt.want)
+		}
+
+		// visible reads the same through the clock
+		setClock(t, at(tt.now))
+		if got := c.visible(); got != tt.want {
+			t.Errorf("%s: visible() at %s = %v, want %v", tt.name, tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestHiddenCategoryIsNotListedOrServed(t *testing.T) {
+	cartoons := testTree(t, "cartoon.mp4")
+	films := testTree(t, "late.mkv")
+	h := testServer(t, "[Cartoons]\nDirectory="+cartoons+"\nFileTypes=.mp4\nVisibleFrom=7:00\nVisibleUntil=19:00\n"+
+		"[Late]\nDirectory="+films+"\nFileTypes=.mkv\nVisibleFrom=21:00\nVisibleUntil=0:30\n").routes()
+
+	tests := []struct {
+		now        string
+		shown      string
+		hidden     string
+		hiddenFile string
+	}{
+		{"12:00", "cartoon.mp4", "late.mkv", "/late.mkv"},
+		{"00:15", "late.mkv", "cartoon.mp4", "/cartoon.mp4"},
+	}
+	for _, tt := range tests {
+		setClock(t, at(tt.now))
+		body := request(h, http.MethodGet, "/api/media").Body.String()
+		if !strings.Contains(body, tt.shown) || strings.Contains(body, tt.hidden) {
+			t.Errorf("at %s /api/media should have %s and not %s:\n%s", tt.now, tt.shown, tt.hidden, body)
+		}
+		if w := request(h, http.MethodGet, tt.hiddenFile); w.Code != http.StatusNotFound {
+			t.Errorf("at %s GET %s = %d, want 404", tt.now, tt.hiddenFile, w.Code)
+		}
+		if w := request(h, http.MethodGet, "/"+tt.shown); w.Code != http.StatusOK {
+			t.Errorf("at %s GET /%s = %d, want 200", tt.now, tt.shown, w.Code)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultwalkconcurrency is how many categories are walked at once when unset.
+const defaultWalkConcurrency = 4
+
+// buildmedialist walks every category and returns one mediagroup per category,
+// in the same order as the configs. the walk gives up when ctx is done or the
+// server's walk timeout passes.
+func buildMediaList(ctx context.Context, configs []CategoryConfig, server ServerConfig) ([]MediaGroup, error) {
+	groups := make([]MediaGroup, 0, len(configs))
+	err := walkInOrder(ctx, configs, server, func(group MediaGroup) error {
+		groups = append(groups, group)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// walkinorder walks every category and hands each group to emit in config
+// order as soon as it and the ones before it are done, so a page can show the
+// first categories while later ones are still being walked. categories are
+// walked in parallel, bounded by the server's walk concurrency. the first error
+// from a walk or from emit stops it.
+func walkInOrder(ctx context.Context, configs []CategoryConfig, server ServerConfig, emit func(MediaGroup) error) error {
+	ctx, cancel := walkContext(ctx, server)
+	defer cancel()
+
+	concurrency := server.WalkConcurrency
+	if concurrency < 1 {
+		concurrency = defaultWalkConcurrency
+	}
+
+	walkStart := time.Now()
+
+	// each walk writes only to its own index and then closes its channel, so no
+	// lock is needed for the results
+	groups := make([]MediaGroup, len(configs))
+	errs := make([]error, len(configs))
+	done := make([]chan struct{}, len(configs))
+
+	// the semaphore limits how many directories are walked at the same time
+	sem := make(chan struct{}, concurrency)
+
+	// walked hands a collection the group of a category in this same walk
+	walked := func(name string) (MediaGroup, bool, error) {
+		for j, config := range configs {
+			if config.Name != name || isCollection(config) {
+				continue
+			}
+			select {
+			case <-done[j]:
+				return groups[j], true, errs[j]
+			case <-ctx.Done():
+				return MediaGroup{}, true, ctx.Err()
+			}
+		}
+		return MediaGroup{}, false, nil
+	}
+
+	// every channel exists before any walk starts, since a collection may wait
+	// on a category that comes after it
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	for i, config := range configs {
+		go func(i int, config CategoryConfig) {
+			defer close(done[i])
+
+			// a collection only waits for the categories it draws on, so it
+			// doesn't take a walk's place in the semaphore
+			if isCollection(config) {
+				groups[i], errs[i] = collect(ctx, config, server, walked)
+			} else {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				groups[i], errs[i] = walkCategory(ctx, config, server)
+			}
+			groups[i].tally()
+		}(i, config)
+	}
+
+	for i := range configs {
+
+		// a stalled disk can block a walk inside a single stat, where it never
+		// gets to check the context, so stop waiting for it rather than hang
+		select {
+		case <-done[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if errs[i] != nil {
+			return errs[i]
+		}
+
+		// keep the id lookup in step with what was just walked
+		if server.StableIDs {
+			fileIDs.record(groups[i : i+1])
+		}
+		if err := emit(groups[i]); err != nil {
+			return err
+		}
+	}
+
+	metrics.observeWalk(time.Since(walkStart))
+	return nil
+}
+
+// walkcategory returns a single category's media files, from the library index
+// when there is one and otherwise by walking the directory.
+func walkCategory(ctx context.Context, config CategoryConfig, server ServerConfig) (MediaGroup, error) {
+	if group, ok, err := library.group(ctx, config); ok {
+		return group, err
+	}
+	group, err := scanCategory(ctx, config, server)
+	if err == nil {
+		database.record(config, group)
+	}
+	return group, err
+}
+
+// scancategory walks a single category directory and collects its media files,
+// stopping early with the context's error once ctx is done.
+func scanCategory(ctx context.Context, config CategoryConfig, server ServerConfig) (MediaGroup, error) {
+	if isCollection(config) {
+		return collect(ctx, config, server, nil)
+	}
+	if isArchive(config.Directory) {
+		return walkArchive(ctx, config, server)
+	}
+	group := MediaGroup{Name: config.Name, Title: config.Title, Directory: config.Directory, Files: []MediaFile{}}
+	subtitles := subtitleIndex{}
+	orders := orderIndex{}
+
+	// a directory that's gone, like an unplugged drive, leaves the category
+	// offline rather than failing the other categories. a glob category goes
+	// offline once every directory it matched is gone
+	var parts []CategoryConfig
+	var roots []os.FileInfo
+	var missing error
+	for _, part := range config.parts() {
+		root, err := os.Stat(part.Directory)
+		if err == nil && !root.IsDir() {
+			err = fmt.Errorf("%s is not a directory", part.Directory)
+		}
+		if err != nil {
+			if missing == nil {
+				missing = err
+			}
+			if len(config.Directories) > 1 && missingDirectories.first(part.Directory) {
+				log.Printf("Category %s is missing a directory: %v", config.Name, err)
+			}
+			continue
+		}
+		missingDirectories.forget(part.Directory)
+		parts = append(parts, part)
+		roots = append(roots, root)
+	}
+	if len(parts) == 0 {
+		return offline(config, group, missing), nil
+	}
+	online(config)
+
+	limit := maxFiles(config, server)
+
+	// the directories of a glob category share the category's urls, so a path
+	// in an earlier one hides the same path in a later one, as when serving
+	seen := make(map[string]bool)
+	for i, part := range parts {
+
+		// note the root's device so mounts below it can be left out
+		var rootDev uint64
+		stayOnDev := false
+		if config.StayOnFilesystem {
+			rootDev, stayOnDev = deviceID(roots[i])
+		}
+
+		// walk through the files in the directory and its subdirectories
+		err := filepath.Walk(part.Directory, func(path string, info os.FileInfo, err error) error {
+
+			// give up on the whole walk once the request is cancelled or times out
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+
+			if err != nil {
+
+				// unreadable folders stay unreadable, so skip them and only say so once
+				if os.IsPermission(err) {
+					if deniedPaths.first(path) {
+						log.Println("Skipping unreadable path:", err)
+					}
+					if info != nil && info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+
+				// handle the error and continue traversal
+				log.Println("Error accessing file:", err)
+				return nil
+			}
+
+			// an order file is hidden itself, but says how its directory is sorted
+			if !info.IsDir() && info.Name() == orderFileName {
+				relPath, _ := filepath.Rel(part.Directory, path)
+				if !seen[relPath] {
+					seen[relPath] = true
+					orders.add(part, filepath.ToSlash(relPath))
+				}
+				return nil
+			}
+
+			// prune dotfiles and hidden directories such as .git or .Trash-1000
+			if !server.ShowHidden && path != part.Directory && isHidden(info.Name()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			// a directory on another device is a mount point, which may be slow or offline
+			if info.IsDir() && stayOnDev && path != part.Directory {
+				if dev, ok := deviceID(info); ok && dev != rootDev {
+					return filepath.SkipDir
+				}
+			}
+
+			// stop descending past the category's depth limit
+			if info.IsDir() && config.MaxDepth > 0 && path != part.Directory {
+				relPath, _ := filepath.Rel(part.Directory, path)
+				if pathDepth(filepath.ToSlash(relPath)) > config.MaxDepth {
+					return filepath.SkipDir
+				}
+			}
+
+			// remember sidecar subtitles even when they aren't listed themselves
+			if server.Subtitles && !info.IsDir() && isSubtitle(path) {
+				relPath, _ := filepath.Rel(part.Directory, path)
+				subtitles.add(filepath.ToSlash(relPath))
+			}
+
+			// check if the file is not a directory and has an allowed file type
+			if !info.IsDir() && isAllowedFileType(path, config.FileTypes) {
+
+				// get the relative path to the directory. a file an earlier
+				// folder of a glob already has isn't listed, so it doesn't count
+				relPath, _ := filepath.Rel(part.Directory, path)
+				if seen[relPath] {
+					return nil
+				}
+
+				// a directory pointed at the wrong place could hold millions of files
+				if limit > 0 && len(group.Files) >= limit {
+					group.Truncated = true
+					return filepath.SkipAll
+				}
+				seen[relPath] = true
+				file := newMediaFile(path, relPath, info, server)
+
+				// append the mediafile to the group's files
+				group.Files = append(group.Files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return MediaGroup{}, err
+		}
+		if group.Truncated {
+			break
+		}
+	}
+	sortMedia(group.Files, config, server)
+	orders.apply(group.Files)
+	subtitles.attach(group.Files)
+	if server.StableIDs {
+		assignIDs(config.Name, group.Files)
+	}
+	warnTruncated(config, group, limit)
+	group.Cover = findCover(config, server, "")
+	group.Description = findDescription(config, "")
+
+	// record the number of files found in the category
+	metrics.setCategoryFiles(config.Name, len(group.Files))
+
+	return group, nil
+}
+
+// offlinecategories holds the categories whose directory is currently missing,
+// so the outage is logged when it starts rather than on every walk.
+var offlineCategories = &pathSet{paths: make(map[string]bool)}
+
+// missingdirectories holds the directories of glob categories that are gone
+// while others the glob matched are still there, logged once like offline ones.
+var missingDirectories = &pathSet{paths: make(map[string]bool)}
+
+// offline marks group as unavailable, logging why the first time.
+func offline(config CategoryConfig, group MediaGroup, err error) MediaGroup {
+	if offlineCategories.first(config.Name) {
+		log.Printf("Category %s is offline: %v", config.Name, err)
+	}
+	metrics.setCategoryFiles(config.Name, 0)
+	group.Offline = true
+	return group
+}
+
+// online notes that a category's directory is readable again.
+func online(config CategoryConfig) {
+	if offlineCategories.forget(config.Name) {
+		log.Printf("Category %s is back online", config.Name)
+	}
+}
+
+// maxfiles returns how many files a category lists at most, its own MaxFiles
+// or else the server's, where 0 means no limit.
+func maxFiles(config CategoryConfig, server ServerConfig) int {
+	if config.MaxFiles > 0 {
+		return config.MaxFiles
+	}
+	return server.MaxFiles
+}
+
+// truncatedcategories holds the categories already reported as cut off.
+var truncatedCategories = &pathSet{paths: make(map[string]bool)}
+
+// warntruncated logs, once per category, that the walk stopped at the limit.
+func warnTruncated(config CategoryConfig, group MediaGroup, limit int) {
+	if group.Truncated && truncatedCategories.first(config.Name) {
+		log.Printf("Category %s has more than %d files, only the first %d are listed; check its Directory or raise MaxFiles", config.Name, limit, limit)
+	}
+}
+
+// pathset remembers paths that have already been reported.
+type pathSet struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+// deniedpaths holds the unreadable paths that have been logged, so every walk
+// doesn't log them again.
+var deniedPaths = &pathSet{paths: make(map[string]bool)}
+
+// first reports whether path is new to the set, adding it.
+func (p *pathSet) first(path string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paths[path] {
+		return false
+	}
+	p.paths[path] = true
+	return true
+}
+
+// forget removes path from the set, reporting whether it was there.
+func (p *pathSet) forget(path string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paths[path] {
+		return false
+	}
+	delete(p.paths, path)
+	return true
+}
+
+// walkcontext limits ctx by the server's walk timeout, when one is set.
+func walkContext(ctx context.Context, server ServerConfig) (context.Context, context.CancelFunc) {
+	if server.WalkTimeout > 0 {
+		return context.WithTimeout(ctx, server.WalkTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// newmediafile describes the file at path, whose path relative to its category
+// root is relpath, filling in the optional metadata the server has enabled.
+func newMediaFile(path, relPath string, info os.FileInfo, server ServerConfig) MediaFile {
+	file := MediaFile{Name: info.Name(), Path: filepath.ToSlash(relPath), Size: info.Size(), ModTime: info.ModTime()}
+
+	// probing and tag reading are expensive, so they're opt-in and cached per file
+	if server.Durations {
+		file.Duration = durations.get(path, info, probeDuration)
+	}
+	if server.Tags {
+		t := tags.get(path, info, readTags)
+		file.Title, file.Artist, file.Album, file.Track = t.Title, t.Artist, t.Album, t.Track
+		if t.AlbumArtist != t.Artist {
+			file.AlbumArtist = t.AlbumArtist
+		}
+	}
+
+	return file
+}
+
+// pathdepth returns how many directory levels a slash-separated relative path
+// has, counting sub/deep as 2 and the category root as 0.
+func pathDepth(relPath string) int {
+	if relPath == "" || relPath == "." {
+		return 0
+	}
+	return strings.Count(relPath, "/") + 1
+}
+
+// withoutempty returns the groups that have at least one file. offline
+// categories are kept so it's clear why their files are missing.
+func withoutEmpty(groups []MediaGroup) []MediaGroup {
+	kept := groups[:0]
+	for _, group := range groups {
+		if len(group.Files) > 0 || group.Offline {
+			kept = append(kept, group)
+		}
+	}
+	return kept
+}
+
+// recentlyaddedname is the heading of the synthetic recently added group.
+const recentlyAddedName = "Recently Added"
+
+// recentlyadded returns a synthetic group with the n most recently modified files
+// across all groups, newest first. the files have already passed each category's
+// filters during the walk.
+func recentlyAdded(groups []MediaGroup, n int) MediaGroup {
+	var files []MediaFile
+	for _, group := range groups {
+		files = append(files, group.Files...)
+	}
+
+	// newest first, with the path as a tie breaker for a stable order
+	sort.SliceStable(files, func(i, j int) bool {
+		if !files[i].ModTime.Equal(files[j].ModTime) {
+			return files[i].ModTime.After(files[j].ModTime)
+		}
+		return files[i].Path < files[j].Path
+	})
+
+	if len(files) > n {
+		files = files[:n]
+	}
+	group := MediaGroup{Name: recentlyAddedName, Files: files}
+	group.tally()
+	return group
+}
+
+// ishidden reports whether a file or directory name is a dotfile.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// hashiddensegment reports whether any element of a slash-separated url path is hidden.
+func hasHiddenSegment(urlPath string) bool {
+	for _, segment := range strings.Split(urlPath, "/") {
+		if isHidden(segment) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// benchmarkFiles is the size of the synthetic library the benchmarks walk
+// and list, 100 folders of 500 files.
+const benchmarkFiles = 50000
+
+// benchmarkTree creates a library of benchmarkFiles files and returns its
+// config.
+func benchmarkTree(b *testing.B) CategoryConfig {
+	b.Helper()
+	names := make([]string, 0, benchmarkFiles)
+	for i := 0; i < benchmarkFiles; i++ {
+		names = append(names, fmt.Sprintf("album %03d/track %04d.mp3", i/500, i%500))
+	}
+	return CategoryConfig{Name: "Music", Directory: testTree(b, names...), FileTypes: []string{".mp3"}}
+}
+
+func BenchmarkWalk(b *testing.B) {
+	config := benchmarkTree(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		group, err := scanCategory(context.Background(), config, ServerConfig{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(group.Files) != benchmarkFiles {
+			b.Fatalf("walked %d files, want %d", len(group.Files), benchmarkFiles)
+		}
+	}
+}
+
+// BenchmarkIndex renders the listing of the whole library. the recorder keeps
+// the page it's sent, so compare against BenchmarkWalk for what rendering adds.
+func BenchmarkIndex(b *testing.B) {
+	config := benchmarkTree(b)
+	h := testServer(b, "[Music]\nDirectory="+config.Directory+"\nFileTypes=.mp3\n").routes()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if w := request(h, http.MethodGet, "/"); w.Code != http.StatusOK {
+			b.Fatalf("GET / = %d", w.Code)
+		}
+	}
+}
+
+// pathsOf returns the relative paths of a group's files.
+func pathsOf(group MediaGroup) []string {
+	paths := []string{}
+	for _, file := range group.Files {
+		paths = append(paths, file.Path)
+	}
+	return paths
+}
+
+func TestMaxDepth(t *testing.T) {
+	dir := testTree(t, "top.mp3", "one/a.mp3", "one/two/b.mp3", "one/two/three/c.mp3")
+
+	tests := []struct {
+		maxDepth int
+		want     []string
+	}{
+		{0, []string{"one/a.mp3", "one/two/b.mp3", "one/two/three/c.mp3", "top.mp3"}},
+		{1, []string{"one/a.mp3", "top.mp3"}},
+		{2, []string{"one/a.mp3", "one/two/b.mp3", "top.mp3"}},
+	}
+	for _, tt := range tests {
+		config := CategoryConfig{Name: "Music", Directory: dir, FileTypes: []string{".mp3"}, MaxDepth: tt.maxDepth}
+		group, err := scanCategory(context.Background(), config, ServerConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pathsOf(group); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MaxDepth=%d walked %v, want %v", tt.maxDepth, got, tt.want)
+		}
+	}
+
+	// the browse pages stop at the same depth
+	h := testServer(t, "[Music]\nDirectory="+dir+"\nFileTypes=.mp3\nMaxDepth=1\n").routes()
+	for target, want := range map[string]int{"/browse/Music/one/": http.StatusOK, "/browse/Music/one/two/": http.StatusNotFound} {
+		if w := request(h, http.MethodGet, target); w.Code != want {
+			t.Errorf("GET %s = %d, want %d", target, w.Code, want)
+		}
+	}
+}
+
+func TestUnreadableDirectoryIsSkipped(t *testing.T) {
+	dir := testTree(t, "open/song.mp3", "locked/secret.mp3")
+	locked := filepath.Join(dir, "locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(locked, 0o755) })
+	if _, err := os.ReadDir(locked); err == nil {
+		t.Skip("file modes don't stop this user reading directories, as with root")
+	}
+
+	logged := captureLog(t)
+	h := testServer(t, "[Music]\nDirectory="+dir+"\nFileTypes=.mp3\n").routes()
+	for i := 0; i < 3; i++ {
+		w := request(h, http.MethodGet, "/")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "song.mp3") {
+			t.Fatalf("GET / = %d, want the readable files listed:\n%s", w.Code, w.Body)
+		}
+	}
+
+	// three walks, but the folder is only reported the first time
+	if n := strings.Count(logged.String(), "Skipping unreadable path"); n != 1 {
+		t.Errorf("logged the unreadable folder %d times, want once:\n%s", n, logged)
+	}
+	if strings.Contains(logged.String(), "Error accessing file") {
+		t.Errorf("the unreadable folder was logged as an error:\n%s", logged)
+	}
+}
+
+func TestRemovedDirectoryIsOffline(t *testing.T) {
+	logged := captureLog(t)
+	music := testTree(t, "song.mp3")
+	drive := testTree(t, "usb/film.mkv")
+	h := testServer(t, "[Music]\nDirectory="+music+"\nFileTypes=.mp3\n"+
+		"[Drive]\nDirectory="+filepath.Join(drive, "usb")+"\nFileTypes=.mkv\n").routes()
+	if w := request(h, http.MethodGet, "/film.mkv"); w.Code != http.StatusOK {
+		t.Fatalf("GET /film.mkv before unplugging = %d", w.Code)
+	}
+
+	// the drive is unplugged between requests
+	if err := os.RemoveAll(filepath.Join(drive, "usb")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		w := request(h, http.MethodGet, "/")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET / = %d, want the other categories still listed", w.Code)
+		}
+		if body := w.Body.String(); !strings.Contains(body, "song.mp3") || !strings.Contains(body, `text-muted">offline<`) {
+			t.Errorf("GET / should list song.mp3 and show Drive offline:\n%s", body)
+		}
+	}
+	var drives []MediaGroup
+	for _, group := range getMedia(t, h, nil).Groups {
+		if group.Name == "Drive" {
+			drives = append(drives, group)
+		}
+	}
+	if len(drives) != 1 || !drives[0].Offline || len(drives[0].Files) != 0 {
+		t.Errorf("the api has Drive as %+v, want it offline with no files", drives)
+	}
+	if w := request(h, http.MethodGet, "/film.mkv"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /film.mkv after unplugging = %d, want 404", w.Code)
+	}
+	if w := request(h, http.MethodGet, "/song.mp3"); w.Code != http.StatusOK {
+		t.Errorf("GET /song.mp3 = %d, the other categories should still serve", w.Code)
+	}
+	if n := strings.Count(logged.String(), "Category Drive is offline"); n != 1 {
+		t.Errorf("logged Drive going offline %d times, want once:\n%s", n, logged)
+	}
+
+	// and it comes back when the drive does
+	testTreeAt(t, filepath.Join(drive, "usb"), "film.mkv")
+	if body := request(h, http.MethodGet, "/").Body.String(); !strings.Contains(body, "film.mkv") {
+		t.Errorf("Drive didn't come back:\n%s", body)
+	}
+	if !strings.Contains(logged.String(), "Category Drive is back online") {
+		t.Errorf("Drive coming back wasn't logged:\n%s", logged)
+	}
+}
+
+func TestShowHidden(t *testing.T) {
+	dir := testTree(t, "song.mp3", ".dotfile.mp3", ".git/objects/blob.mp3", ".Trash/old.mp3", "album/.hidden.mp3")
+	hidden := []string{".dotfile.mp3", ".git/objects/blob.mp3", ".Trash/old.mp3", "album/.hidden.mp3"}
+
+	for _, show := range []bool{false, true} {
+		h := testServer(t, "[Server]\nShowHidden="+fmt.Sprint(show)+"\n[Music]\nDirectory="+dir+"\nFileTypes=.mp3\n").routes()
+
+		listed := make(map[string]bool)
+		for _, file := range filesOf(getMedia(t, h, nil).Groups) {
+			listed[file.Path] = true
+		}
+		if !listed["song.mp3"] {
+			t.Errorf("ShowHidden=%v: song.mp3 isn't listed", show)
+		}
+		if w := request(h, http.MethodGet, "/song.mp3"); w.Code != http.StatusOK {
+			t.Errorf("ShowHidden=%v: GET /song.mp3 = %d, want 200", show, w.Code)
+		}
+
+		// hidden files and everything in hidden folders are listed and served
+		// only with ShowHidden
+		want := http.StatusNotFound
+		if show {
+			want = http.StatusOK
+		}
+		for _, name := range hidden {
+			if listed[name] != show {
+				t.Errorf("ShowHidden=%v: %s listed = %v", show, name, listed[name])
+			}
+			w := request(h, http.MethodGet, "/"+name)
+			if w.Code != want {
+				t.Errorf("ShowHidden=%v: GET /%s = %d, want %d", show, name, w.Code, want)
+			}
+			if show && w.Body.String() != name {
+				t.Errorf("ShowHidden=%v: GET /%s = %q", show, name, w.Body)
+			}
+		}
+	}
+}
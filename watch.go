@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchdebounce is how long a category has to be quiet before the watcher acts
+// on its changes, so copying in a whole album is one rescan rather than one
+// per file.
+const watchDebounce = time.Second
+
+// watcher follows the category folders for files being added, renamed or
+// deleted. a change rescans the category in the library index, when there is
+// one, and tells open pages the library changed. collections follow their
+// sources, and zip archives are only read at startup, so neither is watched.
+type watcher struct {
+	configs []CategoryConfig
+	server  ServerConfig
+
+	mu      sync.Mutex
+	pending map[string]*time.Timer
+}
+
+// newwatcher creates a watcher for the categories that have a folder.
+func newWatcher(configs []CategoryConfig, server ServerConfig) *watcher {
+	w := &watcher{server: server, pending: make(map[string]*time.Timer)}
+	for _, config := range configs {
+		if !isCollection(config) && !isArchive(config.Directory) {
+			w.configs = append(w.configs, config)
+		}
+	}
+	return w
+}
+
+// matters reports whether a change to the named entry can change what the
+// category lists: a folder, a file of one of its FileTypes, or a sidecar such
+// as subtitles, a cover, a description or an order file.
+func (w *watcher) matters(config CategoryConfig, name string, isDir bool) bool {
+	if name == orderFileName {
+		return true
+	}
+	for _, description := range descriptionNames {
+		if strings.EqualFold(name, description) {
+			return true
+		}
+	}
+	if !w.server.ShowHidden && isHidden(name) {
+		return false
+	}
+	if isDir || isAllowedFileType(name, config.FileTypes) {
+		return true
+	}
+	if w.server.Subtitles && isSubtitle(name) {
+		return true
+	}
+	for _, cover := range coverNames(w.server) {
+		if strings.EqualFold(name, cover) {
+			return true
+		}
+	}
+	return false
+}
+
+// changed picks the category up once it has gone watchdebounce without
+// another change.
+func (w *watcher) changed(config CategoryConfig) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if timer, ok := w.pending[config.Name]; ok {
+		timer.Reset(watchDebounce)
+		return
+	}
+	w.pending[config.Name] = time.AfterFunc(watchDebounce, func() {
+		w.mu.Lock()
+		delete(w.pending, config.Name)
+		w.mu.Unlock()
+		w.apply(config)
+	})
+}
+
+// stop drops the changes still waiting out watchdebounce, once the watcher
+// has stopped.
+func (w *watcher) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for name, timer := range w.pending {
+		timer.Stop()
+		delete(w.pending, name)
+	}
+}
+
+// apply rescans a changed category. without an index every request walks the
+// folders anyway, so only the open pages need telling.
+func (w *watcher) apply(config CategoryConfig) {
+	if library == nil {
+		libraryEvents.publish()
+		return
+	}
+	if library.rescan(context.Background(), config) {
+		libraryEvents.publish()
+	}
+}
@@ -0,0 +1,131 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// watchmask is what inotify reports for each watched folder: entries coming,
+// going or being renamed, a file finished being written, and a changed
+// modification time.
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB
+
+// watcheddir is a folder inotify watches, with a category it belongs to.
+type watchedDir struct {
+	config CategoryConfig
+	path   string
+}
+
+// run watches every folder of every category with inotify until ctx is done.
+// folders created later are watched as they appear.
+func (w *watcher) run(ctx context.Context) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+
+	// a non-blocking descriptor is read through the runtime's poller, so
+	// closing it once ctx is done wakes the read below
+	events := os.NewFile(uintptr(fd), "inotify")
+	defer events.Close()
+	defer w.stop()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			events.Close()
+		case <-done:
+		}
+	}()
+
+	// categories whose folders overlap share a watch, so each watch has
+	// every category it's a folder of
+	dirs := make(map[int32][]watchedDir)
+	warned := false
+	addTree := func(config CategoryConfig, root string) {
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+			if p != root && !w.server.ShowHidden && isHidden(d.Name()) {
+				return filepath.SkipDir
+			}
+			wd, err := syscall.InotifyAddWatch(fd, p, watchMask)
+			if err != nil {
+
+				// usually fs.inotify.max_user_watches, which is worth saying once
+				if !warned {
+					log.Printf("Can't watch %s, changes below it need a rescan: %v", p, err)
+					warned = true
+				}
+				return filepath.SkipDir
+			}
+			for _, dir := range dirs[int32(wd)] {
+				if dir.config.Name == config.Name {
+					return nil
+				}
+			}
+			dirs[int32(wd)] = append(dirs[int32(wd)], watchedDir{config: config, path: p})
+			return nil
+		})
+	}
+	for _, config := range w.configs {
+		for _, dir := range config.roots() {
+			addTree(config, dir)
+		}
+	}
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := events.Read(buf)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + syscall.SizeofInotifyEvent
+			off = start + int(event.Len)
+			name := strings.TrimRight(string(buf[start:off]), "\x00")
+
+			// events were dropped, so anything could have changed
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				for _, config := range w.configs {
+					w.changed(config)
+				}
+				continue
+			}
+
+			watched, ok := dirs[event.Wd]
+			if !ok {
+				continue
+			}
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(dirs, event.Wd)
+				continue
+			}
+			isDir := event.Mask&syscall.IN_ISDIR != 0
+			for _, dir := range watched {
+				if isDir && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					addTree(dir.config, filepath.Join(dir.path, name))
+				}
+				if w.matters(dir.config, name, isDir) {
+					w.changed(dir.config)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// pendingNames returns the categories the watcher has a change waiting for.
+func (w *watcher) pendingNames() map[string]bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	names := make(map[string]bool)
+	for name := range w.pending {
+		names[name] = true
+	}
+	return names
+}
+
+func TestWatchOverlappingCategories(t *testing.T) {
+	root := testTree(t, "live/a.mp3")
+	live := filepath.Join(root, "live")
+	w := newWatcher([]CategoryConfig{
+		{Name: "Music", Directory: root, FileTypes: []string{".mp3"}},
+		{Name: "Live", Directory: live, FileTypes: []string{".mp3"}},
+		{Name: "Bootlegs", Directory: live, FileTypes: []string{".mp3"}},
+		{Name: "Films", Directory: live, FileTypes: []string{".mkv"}},
+	}, ServerConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- w.run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-stopped; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// the watches go on in the background, so keep adding files until one is seen
+	want := map[string]bool{"Music": true, "Live": true, "Bootlegs": true}
+	deadline := time.Now().Add(watchDebounce / 2)
+	for i := 0; time.Now().Before(deadline); i++ {
+		if err := os.WriteFile(filepath.Join(live, "new"+strconv.Itoa(i)+".mp3"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if got := w.pendingNames(); len(got) >= len(want) {
+			break
+		}
+	}
+
+	// every category with the folder hears of the file, not just the first
+	got := w.pendingNames()
+	for name := range want {
+		if !got[name] {
+			t.Errorf("%s didn't hear of the new file, pending %v", name, got)
+		}
+	}
+	if got["Films"] {
+		t.Error("Films heard of an .mp3 it doesn't list")
+	}
+}
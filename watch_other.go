@@ -0,0 +1,72 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// watchpollinterval is how often the folders are checked where there's no
+// inotify.
+const watchPollInterval = 5 * time.Second
+
+// run checks the modification time of every folder of every category every
+// watchpollinterval until ctx is done. a folder's time changes when an
+// entry in it is added, removed or renamed, which is enough to notice new and
+// deleted files, though not a file being rewritten in place.
+func (w *watcher) run(ctx context.Context) error {
+	defer w.stop()
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	last := make(map[string]map[string]time.Time)
+	for {
+		for _, config := range w.configs {
+			times := w.folderTimes(config)
+			if previous, ok := last[config.Name]; ok && !sameFolderTimes(previous, times) {
+				w.changed(config)
+			}
+			last[config.Name] = times
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// foldertimes returns the modification time of every folder in a category.
+func (w *watcher) folderTimes(config CategoryConfig) map[string]time.Time {
+	times := make(map[string]time.Time)
+	for _, root := range config.roots() {
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+			if p != root && !w.server.ShowHidden && isHidden(d.Name()) {
+				return filepath.SkipDir
+			}
+			if info, err := d.Info(); err == nil {
+				times[p] = info.ModTime()
+			}
+			return nil
+		})
+	}
+	return times
+}
+
+// samefoldertimes reports whether two checks found the same folders, unchanged.
+func sameFolderTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, t := range a {
+		if u, ok := b[p]; !ok || !u.Equal(t) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketguid is the fixed value mixed into the handshake key by rfc 6455.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxsubscribers caps how many websocket clients can listen for library changes.
+const maxSubscribers = 64
+
+// websocketpinginterval is how often idle connections are pinged to detect dead clients.
+const websocketPingInterval = 30 * time.Second
+
+// websocket opcodes used by the server
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+// librarychangedmessage is sent to every subscriber when the library changes.
+const libraryChangedMessage = `{"type":"library-changed"}`
+
+// errtoomanysubscribers is returned when the subscriber cap is reached.
+var errTooManySubscribers = errors.New("too many subscribers")
+
+// hub fans library change notifications out to websocket subscribers.
+type hub struct {
+	mu          sync.Mutex
+	subscribers map[chan struct{}]struct{}
+}
+
+// libraryevents is the hub the rest of the server publishes library changes to.
+// without a watcher nothing publishes, and subscribers simply stay idle.
+var libraryEvents = &hub{subscribers: make(map[chan struct{}]struct{})}
+
+// subscribe registers a new subscriber, failing once the cap is reached.
+func (h *hub) subscribe() (chan struct{}, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subscribers) >= maxSubscribers {
+		return nil, errTooManySubscribers
+	}
+	ch := make(chan struct{}, 1)
+	h.subscribers[ch] = struct{}{}
+	return ch, nil
+}
+
+// unsubscribe removes a subscriber.
+func (h *hub) unsubscribe(ch chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, ch)
+}
+
+// publish notifies every subscriber without blocking. a subscriber that hasn't
+// handled the previous notification yet just gets the one pending event.
+func (h *hub) publish() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// handlewebsocket upgrades the request and pushes a message on every library change.
+func (s *server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || !headerContainsToken(r.Header, "Connection", "upgrade") {
+		http.Error(w, "expected a websocket upgrade", http.StatusBadRequest)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+
+	events, err := libraryEvents.subscribe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer libraryEvents.unsubscribe(events)
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	// the connection is kept open for as long as the page is, past any server timeout
+	conn.SetDeadline(time.Time{})
+
+	// complete the handshake
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	// the reader notices when the client goes away and answers pings
+	var writeMu sync.Mutex
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readWebSocket(rw.Reader, conn, &writeMu)
+	}()
+
+	ping := time.NewTicker(websocketPingInterval)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+		case <-events:
+			err = writeFrame(conn, &writeMu, opText, []byte(libraryChangedMessage))
+		case <-ping.C:
+			err = writeFrame(conn, &writeMu, opPing, nil)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readwebsocket reads client frames until the connection closes, replying to
+// pings and close frames. the payloads of other frames are discarded.
+func readWebSocket(r *bufio.Reader, conn net.Conn, writeMu *sync.Mutex) {
+	for {
+		opcode, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case opClose:
+			writeFrame(conn, writeMu, opClose, payload)
+			return
+		case opPing:
+			if writeFrame(conn, writeMu, opPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+// readframe reads a single masked client frame.
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7f)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	// the server never expects large messages from clients
+	if length > 64*1024 {
+		return 0, nil, errors.New("websocket frame too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
+
+// writeframe writes a single unmasked, unfragmented server frame.
+func writeFrame(conn net.Conn, writeMu *sync.Mutex, opcode byte, payload []byte) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := conn.Write(frame)
+	return err
+}
+
+// headercontainstoken reports whether a comma-separated header contains token.
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}